@@ -0,0 +1,754 @@
+package gex
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-sys"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+type (
+	// A gene from an HGNC or MGI table
+	GeneAnnotation struct {
+		GeneId  string
+		Symbol  string
+		Ensembl string
+		Refseq  string
+		Ncbi    int
+		// stored as alt names so genes can still be found
+		// by their old symbols
+		PreviousSymbols []string
+		// only used to map probes
+		AliasSymbols []string
+		// HGNC gene groups, which MGI does not have
+		Groups []string
+	}
+
+	GeneRef struct {
+		GeneId string `json:"geneId"`
+		Symbol string `json:"symbol"`
+	}
+
+	GeneRename struct {
+		GeneId string `json:"geneId"`
+		From   string `json:"from"`
+		To     string `json:"to"`
+	}
+
+	ProbeChange struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+		// the symbol the probe was given in the original data
+		Symbol string   `json:"symbol"`
+		From   *GeneRef `json:"from,omitempty"`
+		To     *GeneRef `json:"to,omitempty"`
+	}
+
+	// What changed when the genes of a source were refreshed
+	AnnotationReport struct {
+		Source         string         `json:"source"`
+		DryRun         bool           `json:"dryRun"`
+		GenesAdded     int            `json:"genesAdded"`
+		GenesUpdated   int            `json:"genesUpdated"`
+		GenesWithdrawn int            `json:"genesWithdrawn"`
+		Renamed        []*GeneRename  `json:"renamed"`
+		NewlyMapped    []*ProbeChange `json:"newlyMapped"`
+		Remapped       []*ProbeChange `json:"remapped"`
+		Lost           []*ProbeChange `json:"lost"`
+		// probes with no gene after the refresh
+		Unmapped int `json:"unmapped"`
+	}
+
+	annotatedGene struct {
+		id int
+		GeneAnnotation
+	}
+
+	annotatedProbe struct {
+		id       int
+		publicId string
+		name     string
+		symbol   string
+		geneId   int
+	}
+
+	// the HGNC and MGI lookups used by the build script, searched in
+	// order of preference
+	geneLookup struct {
+		official map[string]int
+		previous map[string]int
+		alias    map[string]int
+	}
+)
+
+const (
+	SourceHgnc = "HGNC"
+	SourceMgi  = "MGI"
+
+	AnnotationSourceSQL = `SELECT
+		s.id,
+		s.genome_id,
+		s.name
+		FROM sources s
+		WHERE LOWER(s.name) = :name`
+
+	AnnotatedGenesSQL = `SELECT
+		g.id,
+		g.gene_id,
+		g.symbol,
+		g.ensembl,
+		g.refseq,
+		CAST(g.ncbi AS TEXT),
+		g.gene_group
+		FROM genes g
+		WHERE g.source_id = :source`
+
+	AltGeneNamesSQL = `SELECT
+		agn.id,
+		agn.gene_id,
+		agn.name
+		FROM alt_gene_names agn
+		WHERE agn.source_id = :source`
+
+	AnnotatedProbesSQL = `SELECT
+		p.id,
+		p.public_id,
+		p.name,
+		p.symbol,
+		COALESCE(p.gene_id, -1)
+		FROM probes p
+		WHERE p.genome_id = :genome
+		ORDER BY p.id`
+
+	// genes and alt names have no autoincrement in postgres so we
+	// number them ourselves
+	MaxGeneIdSQL = `SELECT COALESCE(MAX(g.id), 0) FROM genes g`
+
+	MaxAltGeneNameIdSQL = `SELECT COALESCE(MAX(agn.id), 0) FROM alt_gene_names agn`
+
+	// ncbi is NOT NULL so genes without an NCBI id are written with
+	// 0, which ProbesSQL reads as missing like the '' of older catalogs
+	InsertGeneSQL = `INSERT INTO genes (id, public_id, source_id, gene_id, ensembl, refseq, ncbi, symbol, gene_group)
+		VALUES (:id, :public_id, :source, :gene_id, :ensembl, :refseq, :ncbi, :symbol, :gene_group)`
+
+	UpdateGeneSQL = `UPDATE genes
+		SET symbol = :symbol, ensembl = :ensembl, refseq = :refseq, ncbi = :ncbi, gene_group = :gene_group
+		WHERE id = :id`
+
+	InsertAltGeneNameSQL = `INSERT INTO alt_gene_names (id, public_id, source_id, gene_id, name)
+		VALUES (:id, :public_id, :source, :gene, :name)`
+
+	DeleteAltGeneNameSQL = `DELETE FROM alt_gene_names WHERE id = :id`
+
+	UpdateProbeGeneSQL = `UPDATE probes SET gene_id = :gene WHERE id = :id`
+)
+
+var (
+	ErrSourceNotFound = errors.New("gene source not found")
+
+	hgncColumns = []string{"HGNC ID",
+		"Approved symbol",
+		"Previous symbols",
+		"Alias symbols",
+		"Ensembl gene ID",
+		"RefSeq IDs",
+		"NCBI Gene ID",
+		"Gene group name"}
+
+	// columns that older downloads do not have
+	optionalGeneColumns = map[string]struct{}{"Gene group name": {}}
+
+	mgiColumns = []string{"mgi", "gene_symbol", "ensembl", "refseq", "entrez"}
+)
+
+// Reads the approved genes table downloaded from
+// https://www.genenames.org/download/custom/
+func ReadHgncGenes(r io.Reader) ([]*GeneAnnotation, error) {
+	return readGeneTable(r, hgncColumns, func(row []string) *GeneAnnotation {
+		return &GeneAnnotation{GeneId: row[0],
+			Symbol:          row[1],
+			PreviousSymbols: splitSymbols(row[2]),
+			AliasSymbols:    splitSymbols(row[3]),
+			Ensembl:         stripVersion(row[4]),
+			Refseq:          strings.ReplaceAll(row[5], " ", ""),
+			Ncbi:            parseNcbi(row[6]),
+			Groups:          splitGroups(row[7])}
+	})
+}
+
+// Reads an MGI gene list with mgi, gene_symbol, ensembl, refseq
+// and entrez columns where missing ids are "null"
+func ReadMgiGenes(r io.Reader) ([]*GeneAnnotation, error) {
+	return readGeneTable(r, mgiColumns, func(row []string) *GeneAnnotation {
+		for i, v := range row {
+			if v == "null" {
+				row[i] = ""
+			}
+		}
+
+		return &GeneAnnotation{GeneId: row[0],
+			Symbol:  row[1],
+			Ensembl: stripVersion(row[2]),
+			Refseq:  strings.ReplaceAll(row[3], " ", ""),
+			Ncbi:    parseNcbi(row[4])}
+	})
+}
+
+// Reads a tab delimited table with a header, passing the named
+// columns of each row to f in the order given
+func readGeneTable(r io.Reader, columns []string, f func(row []string) *GeneAnnotation) ([]*GeneAnnotation, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, len(columns))
+
+	for i, column := range columns {
+		indexes[i] = -1
+
+		for j, name := range header {
+			if strings.TrimSpace(name) == column {
+				indexes[i] = j
+				break
+			}
+		}
+
+		if indexes[i] == -1 {
+			if _, ok := optionalGeneColumns[column]; ok {
+				continue
+			}
+
+			return nil, fmt.Errorf("gene table is missing column %q", column)
+		}
+	}
+
+	ret := make([]*GeneAnnotation, 0, 50000)
+
+	for {
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(indexes))
+
+		for i, j := range indexes {
+			if j != -1 && j < len(record) {
+				row[i] = strings.TrimSpace(record[j])
+			}
+		}
+
+		gene := f(row)
+
+		if gene.GeneId == "" || gene.Symbol == "" {
+			continue
+		}
+
+		ret = append(ret, gene)
+	}
+
+	return ret, nil
+}
+
+// Reloads the genes of a source such as HGNC or MGI and remaps every
+// probe in its genome using the symbol the probe was originally given.
+// Existing genes keep their ids and public ids so nothing that refers
+// to them, such as gene sets, is affected, and the expression files
+// are untouched. Genes no longer in the table are kept but probes are
+// no longer mapped to them. If dryRun is set the report is returned
+// but nothing is changed.
+func (gdb *GexDB) Annotate(source string, genes []*GeneAnnotation, dryRun bool) (*AnnotationReport, error) {
+	if err := gdb.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	var sourceId int
+	var genomeId int
+
+	report := AnnotationReport{DryRun: dryRun,
+		Renamed:     make([]*GeneRename, 0, 100),
+		NewlyMapped: make([]*ProbeChange, 0, 100),
+		Remapped:    make([]*ProbeChange, 0, 100),
+		Lost:        make([]*ProbeChange, 0, 100)}
+
+	err := gdb.rwdb.QueryRow(AnnotationSourceSQL, sql.Named("name", strings.ToLower(source))).Scan(
+		&sourceId,
+		&genomeId,
+		&report.Source)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, source)
+		}
+
+		return nil, err
+	}
+
+	tx, err := gdb.rwdb.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// all genes of the source by database id, including the ones
+	// withdrawn from the table, so probe changes can be reported
+	current, err := annotatedGenes(tx, sourceId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	byGeneId := make(map[string]*annotatedGene, len(current))
+
+	// so probe changes show the genes as they were
+	before := make(map[int]*GeneRef, len(current))
+
+	for _, gene := range current {
+		byGeneId[gene.GeneId] = gene
+		before[gene.id] = &GeneRef{GeneId: gene.GeneId, Symbol: gene.Symbol}
+	}
+
+	var nextId int
+
+	err = tx.QueryRow(MaxGeneIdSQL).Scan(&nextId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]*annotatedGene, len(genes))
+
+	for _, annotation := range genes {
+		// first row wins if a table repeats a gene
+		if _, ok := seen[annotation.GeneId]; ok {
+			continue
+		}
+
+		gene, ok := byGeneId[annotation.GeneId]
+
+		if ok {
+			if gene.Symbol != annotation.Symbol {
+				report.Renamed = append(report.Renamed, &GeneRename{GeneId: gene.GeneId,
+					From: gene.Symbol,
+					To:   annotation.Symbol})
+			}
+
+			if gene.Symbol != annotation.Symbol ||
+				gene.Ensembl != annotation.Ensembl ||
+				gene.Refseq != annotation.Refseq ||
+				gene.Ncbi != annotation.Ncbi ||
+				!slices.Equal(gene.Groups, annotation.Groups) {
+				_, err = tx.Exec(UpdateGeneSQL,
+					sql.Named("id", gene.id),
+					sql.Named("symbol", annotation.Symbol),
+					sql.Named("ensembl", annotation.Ensembl),
+					sql.Named("refseq", annotation.Refseq),
+					sql.Named("ncbi", annotation.Ncbi),
+					sql.Named("gene_group", joinGroups(annotation.Groups)))
+
+				if err != nil {
+					return nil, err
+				}
+
+				report.GenesUpdated++
+			}
+		} else {
+			publicId, err := sys.Uuidv7()
+
+			if err != nil {
+				return nil, err
+			}
+
+			nextId++
+
+			gene = &annotatedGene{id: nextId}
+
+			_, err = tx.Exec(InsertGeneSQL,
+				sql.Named("id", gene.id),
+				sql.Named("public_id", publicId),
+				sql.Named("source", sourceId),
+				sql.Named("gene_id", annotation.GeneId),
+				sql.Named("ensembl", annotation.Ensembl),
+				sql.Named("refseq", annotation.Refseq),
+				sql.Named("ncbi", annotation.Ncbi),
+				sql.Named("symbol", annotation.Symbol),
+				sql.Named("gene_group", joinGroups(annotation.Groups)))
+
+			if err != nil {
+				return nil, err
+			}
+
+			current[gene.id] = gene
+			report.GenesAdded++
+		}
+
+		gene.GeneAnnotation = *annotation
+		seen[annotation.GeneId] = gene
+	}
+
+	report.GenesWithdrawn = len(byGeneId) - (len(seen) - report.GenesAdded)
+
+	err = updateAltGeneNames(tx, sourceId, seen)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = remapProbes(tx, genomeId, newGeneLookup(seen), before, current, &report)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msgf("annotated %s: %d added, %d updated, %d renamed, %d newly mapped, %d remapped, %d lost",
+		report.Source,
+		report.GenesAdded,
+		report.GenesUpdated,
+		len(report.Renamed),
+		len(report.NewlyMapped),
+		len(report.Remapped),
+		len(report.Lost))
+
+	if dryRun {
+		return &report, nil
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// genes are in cached lists and search results
+	gdb.catalogChanged()
+
+	err = gdb.InvalidateResults()
+
+	if err != nil {
+		log.Warn().Msgf("could not drop cached results: %s", err)
+	}
+
+	return &report, nil
+}
+
+func annotatedGenes(tx *catalogTx, sourceId int) (map[int]*annotatedGene, error) {
+	rows, err := tx.Query(AnnotatedGenesSQL, sql.Named("source", sourceId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := make(map[int]*annotatedGene, 50000)
+
+	for rows.Next() {
+		var gene annotatedGene
+		var ncbi string
+		var groups string
+
+		// older catalogs store missing ncbi ids as ''
+		err := rows.Scan(&gene.id,
+			&gene.GeneId,
+			&gene.Symbol,
+			&gene.Ensembl,
+			&gene.Refseq,
+			&ncbi,
+			&groups)
+
+		if err != nil {
+			return nil, err
+		}
+
+		gene.Ncbi = parseNcbi(ncbi)
+		gene.Groups = splitGroups(groups)
+
+		ret[gene.id] = &gene
+	}
+
+	return ret, rows.Err()
+}
+
+// Makes the alt names of a source match the previous symbols of its
+// genes, keeping the rows, and so the public ids, of names that have
+// not changed
+func updateAltGeneNames(tx *catalogTx, sourceId int, genes map[string]*annotatedGene) error {
+	// gene id and name to row id
+	current := make(map[string]int, 50000)
+
+	rows, err := tx.Query(AltGeneNamesSQL, sql.Named("source", sourceId))
+
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var id int
+		var geneId int
+		var name string
+
+		err := rows.Scan(&id, &geneId, &name)
+
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		current[altGeneNameKey(geneId, name)] = id
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var nextId int
+
+	err = tx.QueryRow(MaxAltGeneNameIdSQL).Scan(&nextId)
+
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[int]struct{}, len(current))
+
+	for _, gene := range genes {
+		for _, name := range gene.PreviousSymbols {
+			if id, ok := current[altGeneNameKey(gene.id, name)]; ok {
+				keep[id] = struct{}{}
+				continue
+			}
+
+			publicId, err := sys.Uuidv7()
+
+			if err != nil {
+				return err
+			}
+
+			nextId++
+
+			_, err = tx.Exec(InsertAltGeneNameSQL,
+				sql.Named("id", nextId),
+				sql.Named("public_id", publicId),
+				sql.Named("source", sourceId),
+				sql.Named("gene", gene.id),
+				sql.Named("name", name))
+
+			if err != nil {
+				return err
+			}
+
+			current[altGeneNameKey(gene.id, name)] = nextId
+			keep[nextId] = struct{}{}
+		}
+	}
+
+	for _, id := range current {
+		if _, ok := keep[id]; ok {
+			continue
+		}
+
+		_, err = tx.Exec(DeleteAltGeneNameSQL, sql.Named("id", id))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func altGeneNameKey(geneId int, name string) string {
+	return strconv.Itoa(geneId) + ":" + name
+}
+
+// Points each probe of a genome at the gene its symbol now resolves to
+func remapProbes(tx *catalogTx,
+	genomeId int,
+	lookup *geneLookup,
+	before map[int]*GeneRef,
+	genes map[int]*annotatedGene,
+	report *AnnotationReport) error {
+
+	rows, err := tx.Query(AnnotatedProbesSQL, sql.Named("genome", genomeId))
+
+	if err != nil {
+		return err
+	}
+
+	probes := make([]*annotatedProbe, 0, 50000)
+
+	for rows.Next() {
+		var probe annotatedProbe
+
+		err := rows.Scan(&probe.id,
+			&probe.publicId,
+			&probe.name,
+			&probe.symbol,
+			&probe.geneId)
+
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		probes = append(probes, &probe)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, probe := range probes {
+		geneId := lookup.find(probe.symbol)
+
+		if geneId == -1 {
+			report.Unmapped++
+		}
+
+		if geneId == probe.geneId {
+			continue
+		}
+
+		change := &ProbeChange{Id: probe.publicId,
+			Name:   probe.name,
+			Symbol: probe.symbol,
+			From:   before[probe.geneId],
+			To:     geneRef(genes, geneId)}
+
+		switch {
+		case probe.geneId == -1:
+			report.NewlyMapped = append(report.NewlyMapped, change)
+		case geneId == -1:
+			report.Lost = append(report.Lost, change)
+		default:
+			report.Remapped = append(report.Remapped, change)
+		}
+
+		var gene any
+
+		if geneId != -1 {
+			gene = geneId
+		}
+
+		_, err = tx.Exec(UpdateProbeGeneSQL,
+			sql.Named("id", probe.id),
+			sql.Named("gene", gene))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func geneRef(genes map[int]*annotatedGene, id int) *GeneRef {
+	gene, ok := genes[id]
+
+	if !ok {
+		return nil
+	}
+
+	return &GeneRef{GeneId: gene.GeneId, Symbol: gene.Symbol}
+}
+
+func newGeneLookup(genes map[string]*annotatedGene) *geneLookup {
+	lookup := geneLookup{official: make(map[string]int, len(genes)*5),
+		previous: make(map[string]int, len(genes)),
+		alias:    make(map[string]int, len(genes))}
+
+	for _, gene := range genes {
+		ids := []string{gene.GeneId, gene.Symbol, gene.Ensembl}
+		ids = append(ids, strings.Split(gene.Refseq, ",")...)
+
+		if gene.Ncbi > 0 {
+			ids = append(ids, strconv.Itoa(gene.Ncbi))
+		}
+
+		for _, id := range ids {
+			if id != "" {
+				lookup.official[id] = gene.id
+			}
+		}
+
+		for _, name := range gene.PreviousSymbols {
+			lookup.previous[name] = gene.id
+		}
+
+		for _, name := range gene.AliasSymbols {
+			lookup.alias[name] = gene.id
+		}
+	}
+
+	return &lookup
+}
+
+// Matches a probe symbol the same way the build script does, trying
+// official ids, then previous symbols, then aliases, first as given
+// and then without a version suffix. Returns -1 if there is no match.
+func (lookup *geneLookup) find(symbol string) int {
+	for _, s := range []string{symbol, stripVersion(symbol)} {
+		for _, m := range []map[string]int{lookup.official, lookup.previous, lookup.alias} {
+			if id, ok := m[s]; ok {
+				return id
+			}
+		}
+	}
+
+	return -1
+}
+
+func splitSymbols(s string) []string {
+	ret := make([]string, 0, 5)
+
+	for _, symbol := range strings.Split(s, ",") {
+		symbol = strings.TrimSpace(symbol)
+
+		if symbol != "" {
+			ret = append(ret, symbol)
+		}
+	}
+
+	return ret
+}
+
+// removes version numbers from ids such as ENSG00000141510.17
+func stripVersion(id string) string {
+	id, _, _ = strings.Cut(id, ".")
+	return id
+}
+
+func parseNcbi(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
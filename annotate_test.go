@@ -0,0 +1,81 @@
+package gex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+// Cached results have the old symbols so are dropped by an annotation
+func TestAnnotateInvalidatesResults(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	results := cache.NewMemory(1 << 20)
+
+	gdb.SetResultsCache(results, gex.DefaultResultsTTL)
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+	check(t, "FindProbes", err)
+
+	_, err = gdb.CachedExpression([]string{"MYC"}, gextest.OpenDataset, exprType, probes, true, nil, nil)
+	check(t, "CachedExpression", err)
+
+	key := (&gex.ResultsKey{Generation: gdb.Generation(),
+		Dataset:  gextest.OpenDataset,
+		ExprType: exprType.PublicId,
+		Genes:    []string{"MYC"},
+		IsAdmin:  true}).String()
+
+	_, err = results.Get(context.Background(), key)
+	check(t, "cached results", err)
+
+	report, err := gdb.Annotate("HGNC", []*gex.GeneAnnotation{{GeneId: "HGNC:1001", Symbol: "BCL6"},
+		{GeneId: "HGNC:7553", Symbol: "MYCX"},
+		{GeneId: "HGNC:11998", Symbol: "TP53"}}, false)
+	check(t, "Annotate", err)
+	want(t, "renamed", len(report.Renamed), 1)
+
+	_, err = results.Get(context.Background(), key)
+
+	if !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("got %v, want the cached results to be dropped", err)
+	}
+}
+
+// Genes without an NCBI id are stored with 0, which must not be
+// reported as an id
+func TestAnnotateRenameWithoutNcbi(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	_, err := gdb.Annotate("HGNC", []*gex.GeneAnnotation{{GeneId: "HGNC:1001", Symbol: "BCL6", Ncbi: 604},
+		{GeneId: "HGNC:7553", Symbol: "MYCX", PreviousSymbols: []string{"MYC"}},
+		{GeneId: "HGNC:11998", Symbol: "TP53", Ncbi: 7157}}, false)
+	check(t, "Annotate", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYCX", "BCL6"}, nil)
+	check(t, "FindProbes", err)
+	want(t, "probes", len(probes), 2)
+
+	for _, probe := range probes {
+		if probe.Gene == nil {
+			t.Fatalf("probe %s has no gene", probe.Name)
+		}
+	}
+
+	want(t, "MYCX symbol", probes[0].Gene.GeneSymbol, "MYCX")
+	want(t, "MYCX ncbi", probes[0].Gene.Ncbi, "")
+	want(t, "BCL6 ncbi", probes[1].Gene.Ncbi, "604")
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/antonybholmes/go-web/auth/token"
+)
+
+type (
+	// Who accessed which datasets and when. Records are only ever
+	// appended so they can be used to report access to controlled
+	// data such as EGA cohorts.
+	Record struct {
+		Time     time.Time `json:"time"`
+		User     string    `json:"user"`
+		Action   string    `json:"action"`
+		Datasets []string  `json:"datasets"`
+		ExprType string    `json:"type,omitempty"`
+		Genes    int       `json:"genes"`
+	}
+
+	// Filters records. Empty fields match everything.
+	Query struct {
+		User    string
+		Dataset string
+		From    time.Time
+		To      time.Time
+		N       int
+	}
+
+	Logger interface {
+		Log(record *Record) error
+		// records matching the query, most recent first
+		Query(query *Query) ([]*Record, error)
+		Close() error
+	}
+)
+
+const (
+	ActionExpression = "expression"
+
+	DefaultQueryN = 1000
+	MaxQueryN     = 10000
+)
+
+var (
+	logger Logger
+	mu     sync.RWMutex
+)
+
+// Sets where records are written. Until this is called records are
+// discarded.
+func InitAudit(l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	logger = l
+}
+
+func GetInstance() Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return logger
+}
+
+func NewRecord(user *token.AuthUserJwtClaims,
+	action string,
+	datasets []string,
+	exprType string,
+	genes int) *Record {
+	return &Record{Time: time.Now().UTC(),
+		User:     user.Subject,
+		Action:   action,
+		Datasets: datasets,
+		ExprType: exprType,
+		Genes:    genes}
+}
+
+func Log(record *Record) error {
+	l := GetInstance()
+
+	if l == nil {
+		return nil
+	}
+
+	return l.Log(record)
+}
+
+func Find(query *Query) ([]*Record, error) {
+	l := GetInstance()
+
+	if l == nil {
+		return []*Record{}, nil
+	}
+
+	return l.Query(query)
+}
+
+// number of records to return, between 1 and MaxQueryN
+func (query *Query) Limit() int {
+	if query.N <= 0 {
+		return DefaultQueryN
+	}
+
+	return min(query.N, MaxQueryN)
+}
+
+// whether a record satisfies the query
+func (query *Query) Matches(record *Record) bool {
+	if query.User != "" && record.User != query.User {
+		return false
+	}
+
+	if !query.From.IsZero() && record.Time.Before(query.From) {
+		return false
+	}
+
+	if !query.To.IsZero() && !record.Time.Before(query.To) {
+		return false
+	}
+
+	if query.Dataset == "" {
+		return true
+	}
+
+	for _, dataset := range record.Datasets {
+		if dataset == query.Dataset {
+			return true
+		}
+	}
+
+	return false
+}
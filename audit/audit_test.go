@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// the time of the first test record
+var start = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// Records with distinct gene counts so they can be told apart. The
+// second is half a second after the first, whose time has no fraction,
+// so times only compare correctly if they are written at a fixed width.
+func testRecords() []*Record {
+	return []*Record{{Time: start, User: "alice", Action: ActionExpression, Datasets: []string{"d1"}, ExprType: "TPM", Genes: 1},
+		{Time: start.Add(500 * time.Millisecond), User: "bob", Action: ActionExpression, Datasets: []string{"d1", "d2"}, ExprType: "TPM", Genes: 2},
+		{Time: start.Add(time.Second), User: "alice", Action: ActionExpression, Datasets: []string{"d2"}, ExprType: "TPM", Genes: 3},
+		{Time: start.Add(2 * time.Second), User: "bob", Action: ActionExpression, Datasets: []string{}, Genes: 4}}
+}
+
+// Logs the test records and checks queries return the right ones, most
+// recent first, so every logger filters the same way
+func checkQueries(t *testing.T, l Logger) {
+	t.Helper()
+
+	records := testRecords()
+
+	for _, record := range records {
+		err := l.Log(record)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name  string
+		query Query
+		want  []int
+	}{{name: "all", want: []int{4, 3, 2, 1}},
+		{name: "user", query: Query{User: "alice"}, want: []int{3, 1}},
+		{name: "dataset", query: Query{Dataset: "d1"}, want: []int{2, 1}},
+		{name: "user and dataset", query: Query{User: "bob", Dataset: "d1"}, want: []int{2}},
+		{name: "unknown dataset", query: Query{Dataset: "d3"}, want: []int{}},
+		// from is inclusive
+		{name: "from", query: Query{From: start.Add(500 * time.Millisecond)}, want: []int{4, 3, 2}},
+		// to is not
+		{name: "to", query: Query{To: start.Add(time.Second)}, want: []int{2, 1}},
+		{name: "from and to", query: Query{From: start.Add(time.Millisecond), To: start.Add(2 * time.Second)}, want: []int{3, 2}},
+		// times in other zones are compared as the same instant
+		{name: "zone", query: Query{From: start.In(time.FixedZone("EST", -5*60*60)).Add(time.Second)}, want: []int{4, 3}},
+		{name: "n", query: Query{N: 2}, want: []int{4, 3}}} {
+		ret, err := l.Query(&test.query)
+
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		got := make([]int, 0, len(ret))
+
+		for _, record := range ret {
+			got = append(got, record.Genes)
+		}
+
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got records %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// records read back as they were written
+	ret, err := l.Query(&Query{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, record := range ret {
+		want := records[len(records)-1-i]
+
+		if !record.Time.Equal(want.Time) ||
+			record.User != want.User ||
+			record.Action != want.Action ||
+			record.ExprType != want.ExprType ||
+			!slices.Equal(record.Datasets, want.Datasets) {
+			t.Errorf("got %+v, want %+v", record, want)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	for _, test := range []struct{ n, want int }{{0, DefaultQueryN}, {-1, DefaultQueryN}, {5, 5}, {MaxQueryN + 1, MaxQueryN}} {
+		if got := (&Query{N: test.n}).Limit(); got != test.want {
+			t.Errorf("n %d: got %d, want %d", test.n, got, test.want)
+		}
+	}
+}
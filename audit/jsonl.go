@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Writes one JSON record per line to a file that is only ever
+// appended to
+type JsonlLogger struct {
+	file *os.File
+	path string
+	mu   sync.Mutex
+}
+
+func NewJsonlLogger(path string) (*JsonlLogger, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &JsonlLogger{file: file, path: path}, nil
+}
+
+func (l *JsonlLogger) Log(record *Record) error {
+	b, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.file.Write(append(b, '\n'))
+
+	return err
+}
+
+// Scans the whole file so this is only suitable for occasional
+// reporting
+func (l *JsonlLogger) Query(query *Query) ([]*Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	ret := make([]*Record, 0, 100)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var record Record
+
+		err := json.Unmarshal(scanner.Bytes(), &record)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if query.Matches(&record) {
+			ret = append(ret, &record)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// records are appended in time order so reverse to get the
+	// most recent first
+	slices.Reverse(ret)
+
+	if len(ret) > query.Limit() {
+		ret = ret[:query.Limit()]
+	}
+
+	return ret, nil
+}
+
+func (l *JsonlLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestJsonlQuery(t *testing.T) {
+	l, err := NewJsonlLogger(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	checkQueries(t, l)
+}
+
+// Opening the log again appends rather than replacing it
+func TestJsonlReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	for _, record := range testRecords()[:2] {
+		l, err := NewJsonlLogger(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = l.Log(record)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l.Close()
+	}
+
+	l, err := NewJsonlLogger(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	ret, err := l.Query(&Query{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ret) != 2 || ret[0].Genes != 2 || ret[1].Genes != 1 {
+		t.Errorf("got %d records, want both, most recent first", len(ret))
+	}
+}
@@ -0,0 +1,209 @@
+package audit
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/antonybholmes/go-sys/db"
+)
+
+const (
+	// fixed width so that times sort and compare as strings
+	TimeFormat = "2006-01-02T15:04:05.000000Z"
+
+	SqliteDSN = "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=ON"
+
+	CreateAuditLogSQL = `CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time TEXT NOT NULL,
+		user TEXT NOT NULL,
+		action TEXT NOT NULL,
+		expression_type TEXT NOT NULL DEFAULT '',
+		genes INTEGER NOT NULL DEFAULT 0)`
+
+	CreateAuditLogDatasetsSQL = `CREATE TABLE IF NOT EXISTS audit_log_datasets (
+		audit_id INTEGER NOT NULL,
+		dataset TEXT NOT NULL,
+		ord INTEGER NOT NULL,
+		PRIMARY KEY (audit_id, ord),
+		FOREIGN KEY (audit_id) REFERENCES audit_log(id))`
+
+	CreateAuditLogIndexesSQL = `CREATE INDEX IF NOT EXISTS audit_log_user_time_idx ON audit_log (user, time);
+		CREATE INDEX IF NOT EXISTS audit_log_time_idx ON audit_log (time);
+		CREATE INDEX IF NOT EXISTS audit_log_datasets_dataset_idx ON audit_log_datasets (dataset)`
+
+	// records cannot be changed or removed once written
+	CreateAppendOnlySQL = `CREATE TRIGGER IF NOT EXISTS audit_log_no_update
+		BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append only'); END;
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_delete
+		BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append only'); END;
+		CREATE TRIGGER IF NOT EXISTS audit_log_datasets_no_update
+		BEFORE UPDATE ON audit_log_datasets
+		BEGIN SELECT RAISE(ABORT, 'audit log is append only'); END;
+		CREATE TRIGGER IF NOT EXISTS audit_log_datasets_no_delete
+		BEFORE DELETE ON audit_log_datasets
+		BEGIN SELECT RAISE(ABORT, 'audit log is append only'); END`
+
+	InsertRecordSQL = `INSERT INTO audit_log
+		(time, user, action, expression_type, genes)
+		VALUES (:time, :user, :action, :type, :genes)`
+
+	InsertRecordDatasetSQL = `INSERT INTO audit_log_datasets
+		(audit_id, dataset, ord)
+		VALUES (:id, :dataset, :ord)`
+
+	RecordsSQL = `SELECT
+		a.time,
+		a.user,
+		a.action,
+		a.expression_type,
+		a.genes,
+		COALESCE((SELECT GROUP_CONCAT(ad.dataset, ',') FROM
+			(SELECT dataset FROM audit_log_datasets WHERE audit_id = a.id ORDER BY ord) ad), '') AS datasets
+		FROM audit_log a
+		WHERE
+			(:user = '' OR a.user = :user)
+			AND (:from = '' OR a.time >= :from)
+			AND (:to = '' OR a.time < :to)
+			AND (:dataset = '' OR a.id IN (
+				SELECT ad.audit_id FROM audit_log_datasets ad WHERE ad.dataset = :dataset))
+		ORDER BY a.id DESC
+		LIMIT :n`
+)
+
+// Writes records to their own append only sqlite database, separate
+// from the read only catalog
+type SqliteLogger struct {
+	db *sql.DB
+}
+
+func NewSqliteLogger(path string) (*SqliteLogger, error) {
+	auditdb, err := sql.Open(db.Sqlite3DB, path+SqliteDSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only allows one writer at a time anyway
+	auditdb.SetMaxOpenConns(1)
+
+	for _, query := range []string{CreateAuditLogSQL,
+		CreateAuditLogDatasetsSQL,
+		CreateAuditLogIndexesSQL,
+		CreateAppendOnlySQL} {
+		_, err := auditdb.Exec(query)
+
+		if err != nil {
+			auditdb.Close()
+			return nil, err
+		}
+	}
+
+	return &SqliteLogger{db: auditdb}, nil
+}
+
+func (l *SqliteLogger) Log(record *Record) error {
+	tx, err := l.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.Exec(InsertRecordSQL,
+		sql.Named("time", record.Time.UTC().Format(TimeFormat)),
+		sql.Named("user", record.User),
+		sql.Named("action", record.Action),
+		sql.Named("type", record.ExprType),
+		sql.Named("genes", record.Genes))
+
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+
+	if err != nil {
+		return err
+	}
+
+	for i, dataset := range record.Datasets {
+		_, err := tx.Exec(InsertRecordDatasetSQL,
+			sql.Named("id", id),
+			sql.Named("dataset", dataset),
+			sql.Named("ord", i+1))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (l *SqliteLogger) Query(query *Query) ([]*Record, error) {
+	rows, err := l.db.Query(RecordsSQL,
+		sql.Named("user", query.User),
+		sql.Named("dataset", query.Dataset),
+		sql.Named("from", formatTime(query.From)),
+		sql.Named("to", formatTime(query.To)),
+		sql.Named("n", query.Limit()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := make([]*Record, 0, 100)
+
+	for rows.Next() {
+		var record Record
+		var t string
+		var datasets string
+
+		err := rows.Scan(&t,
+			&record.User,
+			&record.Action,
+			&record.ExprType,
+			&record.Genes,
+			&datasets)
+
+		if err != nil {
+			return nil, err
+		}
+
+		record.Time, err = time.Parse(TimeFormat, t)
+
+		if err != nil {
+			return nil, err
+		}
+
+		record.Datasets = make([]string, 0, 5)
+
+		if datasets != "" {
+			record.Datasets = strings.Split(datasets, ",")
+		}
+
+		ret = append(ret, &record)
+	}
+
+	return ret, rows.Err()
+}
+
+func (l *SqliteLogger) Close() error {
+	return l.db.Close()
+}
+
+// zero times are left empty so that they match everything
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(TimeFormat)
+}
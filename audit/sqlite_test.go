@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	// registers the sqlite3 database/sql driver, which the service
+	// does itself
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newSqliteLogger(t *testing.T) *SqliteLogger {
+	t.Helper()
+
+	l, err := NewSqliteLogger(filepath.Join(t.TempDir(), "audit.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	return l
+}
+
+func TestSqliteQuery(t *testing.T) {
+	checkQueries(t, newSqliteLogger(t))
+}
+
+// Records cannot be changed or removed once written
+func TestSqliteAppendOnly(t *testing.T) {
+	l := newSqliteLogger(t)
+
+	err := l.Log(testRecords()[1])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, statement := range []string{`UPDATE audit_log SET user = 'mallory'`,
+		`DELETE FROM audit_log`,
+		`UPDATE audit_log_datasets SET dataset = 'd3'`,
+		`DELETE FROM audit_log_datasets`} {
+		_, err := l.db.Exec(statement)
+
+		if err == nil || !strings.Contains(err.Error(), "append only") {
+			t.Errorf("%s: got %v, want the change to be refused", statement, err)
+		}
+	}
+
+	ret, err := l.Query(&Query{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ret) != 1 || ret[0].User != "bob" || len(ret[0].Datasets) != 2 {
+		t.Errorf("got %+v, want the record unchanged", ret)
+	}
+}
+
+// The triggers are kept when the log is opened again
+func TestSqliteReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+
+	for range 2 {
+		l, err := NewSqliteLogger(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = l.Log(testRecords()[0])
+
+		if err == nil {
+			_, err = l.db.Exec(`DELETE FROM audit_log`)
+
+			if err == nil {
+				t.Error("a record was deleted")
+			}
+		}
+
+		l.Close()
+	}
+}
@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// A cache of serialized values that can be shared by several replicas
+// of a service, such as Redis, or kept in memory
+type Cache interface {
+	// Returns ErrMiss if the key is not cached or has expired
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Caches a value for ttl. A ttl of 0 means the value only leaves
+	// the cache when it is evicted or deleted.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Deletes every key starting with prefix so related values, such
+	// as the results for a dataset, can be dropped together
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+var ErrMiss = errors.New("not in cache")
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Counters so cache effectiveness can be monitored
+	Stats struct {
+		Hits      int64 `json:"hits"`
+		Misses    int64 `json:"misses"`
+		Evictions int64 `json:"evictions"`
+		Entries   int   `json:"entries"`
+		// the total size of the entries as measured by the cache's
+		// size function
+		Size    int64 `json:"size"`
+		MaxSize int64 `json:"maxSize"`
+	}
+
+	// A least recently used cache bounded by the total size of its
+	// entries rather than their number, so large and small values
+	// can share a memory budget. It is safe for concurrent use.
+	LRU[K comparable, V any] struct {
+		lock    sync.Mutex
+		entries map[K]*list.Element
+		order   *list.List
+		size    int64
+		maxSize int64
+		sizeOf  func(V) int64
+
+		hits      atomic.Int64
+		misses    atomic.Int64
+		evictions atomic.Int64
+	}
+
+	lruEntry[K comparable, V any] struct {
+		key   K
+		value V
+		size  int64
+	}
+)
+
+// Creates a cache holding at most maxSize, as measured by sizeOf. A
+// maxSize of 0 or less disables the cache.
+func NewLRU[K comparable, V any](maxSize int64, sizeOf func(V) int64) *LRU[K, V] {
+	return &LRU[K, V]{entries: make(map[K]*list.Element),
+		order:   list.New(),
+		maxSize: maxSize,
+		sizeOf:  sizeOf}
+}
+
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.hits.Add(1)
+		return e.Value.(*lruEntry[K, V]).value, true
+	}
+
+	c.misses.Add(1)
+
+	var zero V
+
+	return zero, false
+}
+
+// Adds a value, evicting the least recently used entries to make room.
+// Values bigger than the whole cache are not added.
+func (c *LRU[K, V]) Add(key K, value V) {
+	size := c.sizeOf(value)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if size > c.maxSize {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*lruEntry[K, V])
+		c.size += size - entry.size
+		entry.value = value
+		entry.size = size
+		c.order.MoveToFront(e)
+	} else {
+		c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value, size: size})
+		c.size += size
+	}
+
+	for c.size > c.maxSize {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *LRU[K, V]) Remove(key K) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// Removes every entry whose key matches
+func (c *LRU[K, V]) RemoveFunc(match func(K) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, e := range c.entries {
+		if match(key) {
+			c.removeElement(e)
+		}
+	}
+}
+
+func (c *LRU[K, V]) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[K]*list.Element)
+	c.order.Init()
+	c.size = 0
+}
+
+// Changes the most the cache can hold, evicting entries if it
+// now holds too much
+func (c *LRU[K, V]) SetMaxSize(maxSize int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.maxSize = maxSize
+
+	for c.size > max(0, c.maxSize) && c.order.Len() > 0 {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *LRU[K, V]) Stats() *Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return &Stats{Hits: c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   len(c.entries),
+		Size:      c.size,
+		MaxSize:   c.maxSize}
+}
+
+func (c *LRU[K, V]) removeElement(e *list.Element) {
+	entry := c.order.Remove(e).(*lruEntry[K, V])
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type (
+	// A Cache kept in the memory of one process and bounded by the
+	// total size of its values
+	Memory struct {
+		entries *LRU[string, *memoryEntry]
+	}
+
+	memoryEntry struct {
+		value []byte
+		// zero if the entry does not expire
+		expires time.Time
+	}
+)
+
+func NewMemory(maxSize int64) *Memory {
+	return &Memory{entries: NewLRU[string](maxSize, func(entry *memoryEntry) int64 {
+		return int64(len(entry.value))
+	})}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, ok := m.entries.Get(key)
+
+	if !ok {
+		return nil, ErrMiss
+	}
+
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.entries.Remove(key)
+		return nil, ErrMiss
+	}
+
+	return entry.value, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	entry := memoryEntry{value: value}
+
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	m.entries.Add(key, &entry)
+
+	return nil
+}
+
+func (m *Memory) DeletePrefix(ctx context.Context, prefix string) error {
+	m.entries.RemoveFunc(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+
+	return nil
+}
+
+func (m *Memory) Stats() *Stats {
+	return m.entries.Stats()
+}
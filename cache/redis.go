@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keys are deleted in batches of this size
+const redisDeleteBatch = 100
+
+// A Cache shared through Redis so replicas of a service do not each
+// start cold. Any go-redis client works, including a cluster client
+// or one connected to miniredis for testing.
+type Redis struct {
+	client redis.UniversalClient
+	// added to every key so several services can share a server
+	prefix string
+}
+
+// Creates a cache whose keys all start with prefix, e.g. gex:
+func NewRedis(client redis.UniversalClient, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrMiss
+		}
+
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+// Scans for the keys to delete since Redis cannot delete by pattern.
+// In a cluster every master is scanned since each holds some of the
+// keys.
+func (r *Redis) DeletePrefix(ctx context.Context, prefix string) error {
+	pattern := escapeGlob(r.prefix+prefix) + "*"
+
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return deleteMatching(ctx, client, pattern)
+		})
+	}
+
+	return deleteMatching(ctx, r.client, pattern)
+}
+
+func deleteMatching(ctx context.Context, client redis.Cmdable, pattern string) error {
+	iter := client.Scan(ctx, 0, pattern, redisDeleteBatch).Iterator()
+
+	keys := make([]string, 0, redisDeleteBatch)
+
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+
+		if len(keys) == redisDeleteBatch {
+			if err := client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+
+			keys = keys[:0]
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		return client.Del(ctx, keys...).Err()
+	}
+
+	return nil
+}
+
+// Escapes the characters Redis treats specially in a SCAN pattern so
+// a prefix only matches itself
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T, prefix string) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+
+	server, err := miniredis.Run()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(server.Close)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedis(client, prefix), server
+}
+
+func TestRedisGetSet(t *testing.T) {
+	r, server := newTestRedis(t, "gex:")
+
+	ctx := context.Background()
+
+	_, err := r.Get(ctx, "results:a")
+
+	if !errors.Is(err, ErrMiss) {
+		t.Fatalf("got %v, want %v", err, ErrMiss)
+	}
+
+	err = r.Set(ctx, "results:a", []byte("value"), time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := r.Get(ctx, "results:a")
+
+	if err != nil || string(value) != "value" {
+		t.Fatalf("got %q %v, want value", value, err)
+	}
+
+	// the prefix is added to the keys on the server
+	if !server.Exists("gex:results:a") {
+		t.Errorf("gex:results:a is not on the server: %v", server.Keys())
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	_, err = r.Get(ctx, "results:a")
+
+	if !errors.Is(err, ErrMiss) {
+		t.Errorf("after the ttl: got %v, want %v", err, ErrMiss)
+	}
+}
+
+func TestRedisDeletePrefix(t *testing.T) {
+	r, server := newTestRedis(t, "gex:")
+
+	ctx := context.Background()
+
+	// more than one batch
+	for i := range 2*redisDeleteBatch + 10 {
+		server.Set(fmt.Sprintf("gex:results:dataset-1:%d", i), "value")
+	}
+
+	// a prefix with pattern characters must only match itself
+	server.Set("gex:results:*:1", "value")
+	server.Set("gex:results:dataset-2:1", "value")
+	// another service sharing the server
+	server.Set("other:results:dataset-1:1", "value")
+
+	for _, prefix := range []string{"results:dataset-1:", "results:*"} {
+		err := r.DeletePrefix(ctx, prefix)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := server.Keys()
+
+	want := []string{"gex:results:dataset-2:1", "other:results:dataset-1:1"}
+
+	if !slices.Equal(keys, want) {
+		t.Errorf("got %v, want %v", keys, want)
+	}
+}
+
+// A server that is down is an error rather than a miss so it can be
+// logged
+func TestRedisDown(t *testing.T) {
+	r, server := newTestRedis(t, "gex:")
+
+	server.Close()
+
+	_, err := r.Get(context.Background(), "results:a")
+
+	if err == nil || errors.Is(err, ErrMiss) {
+		t.Errorf("got %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,187 @@
+package gex
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+type (
+	// Identifies a decoded block of expression values in a store
+	blockKey struct {
+		url    string
+		probe  int
+		offset int64
+	}
+
+	// What the catalog looked like when it was last checked so cached
+	// lists can be dropped once it changes
+	catalogStamp struct {
+		modTime time.Time
+		size    int64
+		version int64
+	}
+
+	// Caches the small lists, such as genomes and datasets, that every
+	// page of the app asks for
+	catalogCache struct {
+		lock    sync.Mutex
+		stamp   catalogStamp
+		checked time.Time
+		entries *cache.LRU[string, any]
+	}
+)
+
+const (
+	// 64 MB holds around a million values
+	DefaultBlockCacheSize int64 = 64 * 1024 * 1024
+
+	// most distinct genome, technology and permission combinations
+	// whose datasets are cached
+	MaxCatalogCacheEntries = 1000
+
+	// how often the catalog is checked for changes
+	CatalogCheckInterval = time.Second
+
+	// the bytes a cached block uses beyond its values
+	blockOverhead = 64
+
+	CatalogVersionSQL = `SELECT cv.version FROM catalog_version cv`
+
+	BumpCatalogVersionSQL = `UPDATE catalog_version SET version = version + 1`
+)
+
+func newBlockCache(maxSize int64) *cache.LRU[blockKey, []float32] {
+	return cache.NewLRU[blockKey](maxSize, func(values []float32) int64 {
+		return int64(4*len(values) + blockOverhead)
+	})
+}
+
+func newCatalogCache() *catalogCache {
+	return &catalogCache{entries: cache.NewLRU[string](MaxCatalogCacheEntries, func(any) int64 {
+		return 1
+	})}
+}
+
+// Sets the most memory in bytes that decoded expression values can
+// use. A size of 0 disables the cache.
+func (gdb *GexDB) SetBlockCacheSize(maxSize int64) {
+	gdb.blocks.SetMaxSize(maxSize)
+}
+
+func (gdb *GexDB) BlockCacheStats() *cache.Stats {
+	return gdb.blocks.Stats()
+}
+
+func (gdb *GexDB) CatalogCacheStats() *cache.Stats {
+	return gdb.catalogCache.entries.Stats()
+}
+
+// Returns a cached catalog list, loading it if it is not cached or
+// the catalog has changed since it was
+func cachedCatalog[T any](gdb *GexDB, key string, load func() (T, error)) (T, error) {
+	c := gdb.catalogCache
+
+	c.validate(gdb.catalogStamp)
+
+	if v, ok := c.entries.Get(key); ok {
+		return v.(T), nil
+	}
+
+	v, err := load()
+
+	if err != nil {
+		return v, err
+	}
+
+	c.entries.Add(key, v)
+
+	return v, nil
+}
+
+// Clears the cache if the catalog has changed. To keep requests fast
+// this is done at most once every CatalogCheckInterval.
+func (c *catalogCache) validate(stamp func() catalogStamp) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if time.Since(c.checked) < CatalogCheckInterval {
+		return
+	}
+
+	s := stamp()
+
+	if s != c.stamp {
+		c.entries.Clear()
+		c.stamp = s
+	}
+
+	c.checked = time.Now()
+}
+
+// The modification time of a sqlite catalog, including its write
+// ahead log, and the catalog_version row, which is updated by changes
+// made through the API and is the only way to tell a postgres catalog
+// has changed
+func (gdb *GexDB) catalogStamp() catalogStamp {
+	var stamp catalogStamp
+
+	if gdb.dbpath != "" {
+		for _, path := range []string{gdb.dbpath, gdb.dbpath + "-wal"} {
+			info, err := os.Stat(path)
+
+			if err != nil {
+				continue
+			}
+
+			if info.ModTime().After(stamp.modTime) {
+				stamp.modTime = info.ModTime()
+			}
+
+			stamp.size += info.Size()
+		}
+	}
+
+	// older catalogs do not have a version
+	err := gdb.db.QueryRow(CatalogVersionSQL).Scan(&stamp.version)
+
+	if err != nil {
+		stamp.version = -1
+	}
+
+	return stamp
+}
+
+// Lets every instance sharing the catalog know it has changed so they
+// drop their cached lists
+func (gdb *GexDB) catalogChanged() {
+	gdb.catalogCache.entries.Clear()
+
+	_, err := gdb.rwdb.Exec(BumpCatalogVersionSQL)
+
+	if err != nil {
+		log.Warn().Msgf("could not update catalog version: %s", err)
+	}
+}
+
+// Datasets depend on who is asking, so the key includes the user's
+// permissions in a fixed order, unless they are an admin who can see
+// everything
+func datasetsCacheKey(name string, genome string, technology string, isAdmin bool, permissions []string) string {
+	key := []string{name, genome, technology, strconv.FormatBool(isAdmin)}
+
+	if !isAdmin {
+		sorted := slices.Clone(permissions)
+		slices.Sort(sorted)
+		key = append(key, sorted...)
+	}
+
+	// a separator that cannot appear in a name
+	return strings.Join(key, "\x00")
+}
@@ -0,0 +1,221 @@
+package gex_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+var viewer = []string{gextest.ViewPermission}
+
+// Runs every query of the catalog against the gextest rows as a user
+// with the rdf:view permission, who can see O1 to O3 of Open
+func checkQueries(t *testing.T, gdb *gex.GexDB) {
+	t.Helper()
+
+	version, versioned, err := gdb.SchemaVersion()
+
+	if err != nil || !versioned || version != gex.SchemaVersion {
+		t.Fatalf("schema version %d %v %v, want %d", version, versioned, err, gex.SchemaVersion)
+	}
+
+	genomes, err := gdb.Genomes()
+	check(t, "Genomes", err)
+	want(t, "genomes", len(genomes), 2)
+
+	technologies, err := gdb.Technologies()
+	check(t, "Technologies", err)
+	want(t, "technologies", len(technologies), 2)
+
+	datasets, err := gdb.Datasets("human", "rna-seq", viewer, false)
+	check(t, "Datasets", err)
+	want(t, "datasets", len(datasets), 1)
+	want(t, "dataset", datasets[0].PublicId, gextest.OpenDataset)
+	want(t, "samples", sampleNames(datasets[0].Samples), "O1,O2,O3")
+	want(t, "sample count", datasets[0].SampleCount, 3)
+	want(t, "probe count", datasets[0].ProbeCount, 4)
+
+	if datasets[0].CreatedAt == "" || datasets[0].UpdatedAt == "" {
+		t.Errorf("dataset has no created or updated time")
+	}
+
+	datasets, err = gdb.Datasets("human", "rna-seq", nil, true)
+	check(t, "Datasets", err)
+	want(t, "admin datasets", len(datasets), 2)
+
+	summaries, err := gdb.DatasetSummaries("human", "rna-seq", viewer, false)
+	check(t, "DatasetSummaries", err)
+	want(t, "summaries", len(summaries), 1)
+	want(t, "summary sample count", summaries[0].SampleCount, 3)
+
+	dataset, err := gdb.Dataset(gextest.OpenDataset, false, viewer)
+	check(t, "Dataset", err)
+	want(t, "dataset samples", sampleNames(dataset.Samples), "O1,O2,O3")
+
+	page, err := gdb.DatasetSamples(gextest.OpenDataset, 1, 10, false, viewer)
+	check(t, "DatasetSamples", err)
+	want(t, "page samples", sampleNames(page.Samples), "O1,O2,O3")
+
+	basic, err := gdb.BasicDataset(gextest.OpenDataset, viewer, false)
+	check(t, "BasicDataset", err)
+	want(t, "basic dataset", basic.Name, "Open")
+
+	metadata, err := gdb.Metadata()
+	check(t, "Metadata", err)
+	want(t, "metadata", len(metadata), 3)
+
+	samples, err := gdb.Samples()
+	check(t, "Samples", err)
+	want(t, "all samples", len(samples), 7)
+
+	schema, err := gdb.MetadataSchema(gextest.OpenDataset, false, viewer)
+	check(t, "MetadataSchema", err)
+	want(t, "fields", len(schema), 3)
+	want(t, "COO categories", len(schema[1].Categories), 2)
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+	want(t, "expression type", exprType.PublicId, gextest.TPM)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, false, viewer)
+	check(t, "GenomeTechnology", err)
+	want(t, "genome", genome.Name, "Human")
+	want(t, "technology", technology.Name, "RNA-seq")
+
+	// BCL5 is an old name of BCL6
+	probes, err := gdb.FindProbes(genome, technology, []string{"BCL5", "MYC"}, nil)
+	check(t, "FindProbes", err)
+	want(t, "probes", len(probes), 2)
+	want(t, "first probe", probes[0].Name, "BCL6")
+
+	results, err := gdb.Expression(gextest.OpenDataset, exprType, probes, false, viewer)
+	check(t, "Expression", err)
+	want(t, "expression probes", len(results.Probes), 2)
+	want(t, "MYC values", values(results.Probes[1].Values), values([]float32{20, 21, 22}))
+
+	// the files of the secret dataset list its samples in reverse
+	results, err = gdb.Expression(gextest.SecretDataset, exprType, probes, true, nil)
+	check(t, "Expression", err)
+	want(t, "secret MYC values", values(results.Probes[1].Values), values([]float32{22, 21, 20}))
+
+	geneSets, err := gdb.GeneSets("human")
+	check(t, "GeneSets", err)
+	want(t, "gene sets", len(geneSets), 2)
+
+	geneSets, err = gdb.SearchGeneSets("human", "hallmark", 10)
+	check(t, "SearchGeneSets", err)
+	want(t, "found gene sets", len(geneSets), 1)
+
+	geneSet, err := gdb.GeneSet(genome, gextest.GeneSet)
+	check(t, "GeneSet", err)
+	want(t, "gene set size", geneSet.Size, 4)
+
+	genes, err := gdb.GeneSetGenes(genome, []string{gextest.GeneSet})
+	check(t, "GeneSetGenes", err)
+	want(t, "gene set genes", len(genes), 4)
+
+	probes, err = gdb.FindProbes(genome, technology, genes, nil)
+	check(t, "FindProbes", err)
+	want(t, "gene set probes", len(probes), 3)
+
+	score, err := gdb.ScoreGeneSet(gextest.OpenDataset, exprType, geneSet, probes, gex.ScoreMethodZScore, false, viewer, nil)
+	check(t, "ScoreGeneSet", err)
+	want(t, "scores", len(score.Values), 3)
+
+	score, err = gdb.ScoreGeneSet(gextest.OpenDataset, exprType, geneSet, probes, gex.ScoreMethodSSGSEA, false, viewer, nil)
+	check(t, "ScoreGeneSet", err)
+	want(t, "ssgsea scores", len(score.Values), 3)
+
+	genes, err = gdb.LocusGenes(genome, &gex.Locus{Chr: "chr8", Start: 127000000, End: 128000000}, 10)
+	check(t, "LocusGenes", err)
+	want(t, "locus genes", len(genes), 1)
+
+	genes, err = gdb.GroupGenes(genome, "Basic helix-loop-helix proteins", 10)
+	check(t, "GroupGenes", err)
+	want(t, "group genes", len(genes), 1)
+
+	genes, err = gdb.BiotypeGenes(genome, "protein_coding", 10)
+	check(t, "BiotypeGenes", err)
+	want(t, "biotype genes", len(genes), 3)
+
+	permissions, err := gdb.Permissions()
+	check(t, "Permissions", err)
+	want(t, "permissions", len(permissions), 3)
+
+	permissions, err = gdb.DatasetPermissions(gextest.OpenDataset)
+	check(t, "DatasetPermissions", err)
+	want(t, "dataset permissions", len(permissions), 1)
+
+	permissions, err = gdb.SamplePermissions(gextest.HiddenSample)
+	check(t, "SamplePermissions", err)
+	want(t, "sample permissions", len(permissions), 1)
+
+	_, err = gdb.Permission(gextest.ConsortiumPermission)
+	check(t, "Permission", err)
+
+	report, err := gdb.Check()
+	check(t, "Check", err)
+
+	if !report.Ok {
+		t.Errorf("check found problems: %v", report.Problems)
+	}
+
+	reports, err := gdb.VerifyAll(nil)
+	check(t, "VerifyAll", err)
+	want(t, "verified datasets", len(reports), 2)
+
+	for _, report := range reports {
+		if !report.Ok {
+			t.Errorf("%s has problems: %v", report.Name, report.Problems)
+		}
+	}
+
+	_, err = gdb.CreatePermission("reviewers")
+	check(t, "CreatePermission", err)
+
+	check(t, "GrantDatasetPermission", gdb.GrantDatasetPermission(gextest.SecretDataset, "reviewers"))
+	check(t, "RevokeDatasetPermission", gdb.RevokeDatasetPermission(gextest.SecretDataset, "reviewers"))
+	check(t, "GrantSamplePermission", gdb.GrantSamplePermission(gextest.HiddenSample, "reviewers"))
+	check(t, "RevokeSamplePermission", gdb.RevokeSamplePermission(gextest.HiddenSample, "reviewers"))
+
+	annotation, err := gdb.Annotate("HGNC", []*gex.GeneAnnotation{{GeneId: "HGNC:1001", Symbol: "BCL6"}}, true)
+	check(t, "Annotate", err)
+	want(t, "annotation dry run", annotation.DryRun, true)
+
+	locations, err := gdb.LoadGeneLocations("HGNC", []*gex.GeneLocation{{Ids: []string{"MYC"}, Locus: gex.Locus{Chr: "chr8", Start: 1, End: 2}}}, true)
+	check(t, "LoadGeneLocations", err)
+	want(t, "matched locations", locations.Matched, 1)
+}
+
+func check(t *testing.T, name string, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+}
+
+func want[T comparable](t *testing.T, name string, got T, expected T) {
+	t.Helper()
+
+	if got != expected {
+		t.Errorf("%s: got %v, want %v", name, got, expected)
+	}
+}
+
+func sampleNames(samples []*gex.Sample) string {
+	names := make([]string, 0, len(samples))
+
+	for _, sample := range samples {
+		names = append(names, sample.Name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func values(values []float32) string {
+	return fmt.Sprint(values)
+}
@@ -0,0 +1,365 @@
+package gex
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antonybholmes/go-gex/store"
+)
+
+type (
+	// The problems found with the files of one dataset
+	DatasetCheck struct {
+		Id       string   `json:"id"`
+		Name     string   `json:"name"`
+		Files    int      `json:"files"`
+		Problems []string `json:"problems"`
+	}
+
+	// Whether the catalog and every expression file it refers to can
+	// be used. Problems with the catalog itself, such as a missing
+	// table, are listed separately from those of each dataset.
+	CheckReport struct {
+		Ok       bool            `json:"ok"`
+		Problems []string        `json:"problems"`
+		Datasets []*DatasetCheck `json:"datasets"`
+	}
+
+	// The header at the start of every binary expression file
+	BinHeader struct {
+		Magic     uint32
+		Version   uint32
+		Probes    uint32
+		Samples   uint32
+		BlockSize uint32
+	}
+)
+
+const (
+	// the blocks each dataset reads from each file
+	CheckFilesSQL = `SELECT
+		d.public_id,
+		d.name,
+		f.url,
+		COUNT(e.id),
+		MIN(e.length),
+		MAX(e.length),
+		MAX(e.offset)
+		FROM expression e
+		JOIN datasets d ON d.id = e.dataset_id
+		JOIN files f ON f.id = e.file_id
+		GROUP BY d.public_id, d.name, f.url
+		ORDER BY d.name, f.url`
+)
+
+var (
+	ErrInvalidSchema = errors.New("catalog schema is not supported")
+	ErrReadOnly      = errors.New("catalog is read only")
+)
+
+// The columns the queries read or write in each table, which older
+// catalogs get by being migrated with gex-migrate. Keep in step with
+// Migrations.
+var RequiredColumns = map[string][]string{
+	"genomes":        {"id", "public_id", "name"},
+	"sources":        {"id", "genome_id", "name"},
+	"genes":          {"id", "public_id", "source_id", "gene_id", "ensembl", "refseq", "ncbi", "symbol", "chr", "start", `"end"`, "strand", "biotype", "gene_group"},
+	"alt_gene_names": {"id", "public_id", "source_id", "gene_id", "name"},
+	"technologies":   {"id", "public_id", "name"},
+	"probes":         {"id", "public_id", "genome_id", "technology_id", "gene_id", "name", "symbol"},
+	"datasets": {"id", "public_id", "genome_id", "technology_id", "name", "platform", "institution", "description",
+		"pubmed", "geo", "ega", "sample_count", "probe_count", "created_at", "updated_at"},
+	"permissions":         {"id", "public_id", "name"},
+	"dataset_permissions": {"dataset_id", "permission_id"},
+	"samples":             {"id", "public_id", "dataset_id", "name", "file_column"},
+	"sample_permissions":  {"sample_id", "permission_id"},
+	"metadata":            {"id", "public_id", "name", "color"},
+	"sample_metadata":     {"sample_id", "metadata_id", "value"},
+	"dataset_metadata":    {"dataset_id", "metadata_id", "type", "units", "ord"},
+	"metadata_categories": {"id", "public_id", "dataset_id", "metadata_id", "name", "color", "ord"},
+	"expression_types":    {"id", "public_id", "name"},
+	"files":               {"id", "url"},
+	"expression":          {"id", "dataset_id", "probe_id", "expression_type_id", "file_id", "offset", "length"},
+	"gene_sets":           {"id", "public_id", "genome_id", "collection", "name", "description", "url"},
+	"gene_set_members":    {"gene_set_id", "ord", "symbol", "gene_id"},
+	"catalog_version":     {"version"}}
+
+// The tables the queries use
+var RequiredTables = []string{"genomes",
+	"sources",
+	"genes",
+	"alt_gene_names",
+	"technologies",
+	"probes",
+	"datasets",
+	"permissions",
+	"dataset_permissions",
+	"samples",
+	"sample_permissions",
+	"metadata",
+	"sample_metadata",
+	"dataset_metadata",
+	"metadata_categories",
+	"expression_types",
+	"files",
+	"expression",
+	"gene_sets",
+	"gene_set_members",
+	"catalog_version"}
+
+// Checks the catalog can be queried, is not missing any tables and
+// that every expression file it refers to exists with a valid header
+// and the size its blocks need. This reads the header of every file so
+// can be slow for a store such as S3.
+func (gdb *GexDB) Check() (*CheckReport, error) {
+	ctx := context.Background()
+
+	report := CheckReport{Problems: []string{}, Datasets: []*DatasetCheck{}}
+
+	err := gdb.Ping()
+
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("database cannot be opened: %s", err))
+		return &report, nil
+	}
+
+	report.Problems = append(report.Problems, gdb.schemaProblems()...)
+
+	// without the tables the files cannot be found
+	if len(report.Problems) > 0 {
+		return &report, nil
+	}
+
+	rows, err := gdb.db.Query(CheckFilesSQL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var dataset *DatasetCheck
+
+	for rows.Next() {
+		var id string
+		var name string
+		var url string
+		var blocks int64
+		var minLength int
+		var maxLength int
+		var maxOffset int64
+
+		err := rows.Scan(&id, &name, &url, &blocks, &minLength, &maxLength, &maxOffset)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if dataset == nil || dataset.Id != id {
+			dataset = &DatasetCheck{Id: id, Name: name, Problems: []string{}}
+			report.Datasets = append(report.Datasets, dataset)
+		}
+
+		dataset.Files++
+
+		dataset.Problems = append(dataset.Problems, gdb.checkFile(ctx, url, blocks, minLength, maxLength, maxOffset)...)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report.Ok = true
+
+	for _, dataset := range report.Datasets {
+		if len(dataset.Problems) > 0 {
+			report.Ok = false
+		}
+	}
+
+	return &report, nil
+}
+
+// Checks the catalog database can be reached
+func (gdb *GexDB) Ping() error {
+	return gdb.db.db.Ping()
+}
+
+// Returns an error if the catalog cannot be reached or is missing any
+// of the tables or columns searches need
+func (gdb *GexDB) checkSchema() error {
+	err := gdb.Ping()
+
+	if err != nil {
+		return fmt.Errorf("gex catalog cannot be opened: %w", err)
+	}
+
+	err = gdb.checkSchemaVersion()
+
+	if err != nil {
+		return err
+	}
+
+	problems := gdb.schemaProblems()
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidSchema, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func (gdb *GexDB) schemaProblems() []string {
+	problems := []string{}
+
+	for _, table := range RequiredTables {
+		columns := "1"
+
+		// qualified so that columns such as offset, which Postgres
+		// reserves, can be read
+		if required, ok := RequiredColumns[table]; ok {
+			qualified := make([]string, 0, len(required))
+
+			for _, column := range required {
+				qualified = append(qualified, table+"."+column)
+			}
+
+			columns = strings.Join(qualified, ", ")
+		}
+
+		// the names are constants so can be used in the query
+		rows, err := gdb.db.Query(fmt.Sprintf("SELECT %s FROM %s LIMIT 1", columns, table))
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("table %s cannot be read: %s", table, err))
+			continue
+		}
+
+		rows.Close()
+	}
+
+	return problems
+}
+
+// Returns ErrReadOnly if the catalog was opened read only
+func (gdb *GexDB) checkWritable() error {
+	if gdb.rwdb == nil {
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+// Returns the problems with a file that the catalog says has blocks
+// blocks of between minLength and maxLength values
+func (gdb *GexDB) checkFile(ctx context.Context,
+	url string,
+	blocks int64,
+	minLength int,
+	maxLength int,
+	maxOffset int64) []string {
+
+	info, err := gdb.store.Stat(ctx, url)
+
+	if err != nil {
+		if errors.Is(err, store.ErrFileNotFound) {
+			return []string{fmt.Sprintf("%s is missing", url)}
+		}
+
+		return []string{fmt.Sprintf("%s cannot be read: %s", url, err)}
+	}
+
+	header, err := gdb.readBinHeader(ctx, url)
+
+	if err != nil {
+		return []string{fmt.Sprintf("%s header cannot be read: %s", url, err)}
+	}
+
+	if header.Magic != BinMagic {
+		return []string{fmt.Sprintf("%s is not a gex binary file", url)}
+	}
+
+	if header.Version > BinVersion {
+		return []string{fmt.Sprintf("%s is version %d but only up to %d can be read", url, header.Version, BinVersion)}
+	}
+
+	problems := []string{}
+
+	blockSize := store.BlockSize(int(header.Samples))
+
+	if int64(header.BlockSize) != blockSize {
+		problems = append(problems, fmt.Sprintf("%s has a block size of %d but %d samples need %d", url, header.BlockSize, header.Samples, blockSize))
+	}
+
+	if minLength != int(header.Samples) || maxLength != int(header.Samples) {
+		problems = append(problems, fmt.Sprintf("%s has %d samples but the catalog reads %d to %d", url, header.Samples, minLength, maxLength))
+	}
+
+	if int64(header.Probes) != blocks {
+		problems = append(problems, fmt.Sprintf("%s has %d probes but the catalog has %d", url, header.Probes, blocks))
+	}
+
+	size := BinHeaderSize + int64(header.Probes)*blockSize
+
+	if info.Size != size {
+		problems = append(problems, fmt.Sprintf("%s is %d bytes but should be %d", url, info.Size, size))
+	}
+
+	if maxOffset+store.BlockSize(maxLength) > info.Size {
+		problems = append(problems, fmt.Sprintf("%s is %d bytes but the catalog reads up to %d", url, info.Size, maxOffset+store.BlockSize(maxLength)))
+	}
+
+	return problems
+}
+
+func (gdb *GexDB) readBinHeader(ctx context.Context, url string) (*BinHeader, error) {
+	f, err := gdb.store.Open(ctx, url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return decodeBinHeader(f)
+}
+
+func decodeBinHeader(r io.Reader) (*BinHeader, error) {
+	var header BinHeader
+
+	err := binary.Read(io.LimitReader(r, BinHeaderSize), binary.LittleEndian, &header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+// Reads the header at the start of r, returning an error if it is not
+// a file this version can read
+func parseBinHeader(r io.Reader, url string) (*BinHeader, error) {
+	header, err := decodeBinHeader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Magic != BinMagic {
+		return nil, fmt.Errorf("%s is not a gex binary file", url)
+	}
+
+	if header.Version > BinVersion {
+		return nil, fmt.Errorf("%s is version %d but only up to %d can be read", url, header.Version, BinVersion)
+	}
+
+	if int64(header.BlockSize) < store.BlockSize(int(header.Samples)) {
+		return nil, fmt.Errorf("%s has a block size of %d but %d samples need %d", url, header.BlockSize, header.Samples, store.BlockSize(int(header.Samples)))
+	}
+
+	return header, nil
+}
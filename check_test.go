@@ -0,0 +1,62 @@
+package gex_test
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+var (
+	createTableRegex = regexp.MustCompile(`^CREATE TABLE (\w+)`)
+	addColumnRegex   = regexp.MustCompile(`^ALTER TABLE (\w+) ADD COLUMN ("?\w+"?)`)
+)
+
+// Whatever a migration adds must be checked for when a catalog is
+// opened, so that a catalog that was not migrated is not served
+func TestRequiredSchemaMatchesMigrations(t *testing.T) {
+	for _, migration := range gex.Migrations {
+		for _, statement := range migration.Statements {
+			if match := createTableRegex.FindStringSubmatch(statement); match != nil {
+				if !slices.Contains(gex.RequiredTables, match[1]) {
+					t.Errorf("migration %d creates %s, which is not in RequiredTables", migration.Version, match[1])
+				}
+			}
+
+			if match := addColumnRegex.FindStringSubmatch(statement); match != nil {
+				if !slices.Contains(gex.RequiredColumns[match[1]], match[2]) {
+					t.Errorf("migration %d adds %s.%s, which is not in RequiredColumns", migration.Version, match[1], match[2])
+				}
+			}
+		}
+	}
+
+	for table := range gex.RequiredColumns {
+		if !slices.Contains(gex.RequiredTables, table) {
+			t.Errorf("RequiredColumns has %s, which is not in RequiredTables", table)
+		}
+	}
+}
+
+func TestCheckSchemaFindsMissingColumn(t *testing.T) {
+	path := gextest.NewBaseline(t)
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gdb.Close()
+
+	exec(t, path, `ALTER TABLE gene_sets DROP COLUMN url`)
+
+	_, err = gex.OpenGexDB(path, nil)
+
+	if !errors.Is(err, gex.ErrInvalidSchema) {
+		t.Fatalf("got %v, want %v", err, gex.ErrInvalidSchema)
+	}
+}
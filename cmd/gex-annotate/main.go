@@ -0,0 +1,186 @@
+// Refreshes the probe to gene mapping of a catalog from the latest
+// HGNC and MGI tables without rebuilding the expression files, e.g.
+//
+//	gex-annotate -db data/modules/gex/gex.db -hgnc hgnc.tsv -mgi mgi.tsv -dry-run
+//
+// A JSON report of renamed genes and of probes that were newly mapped,
+// remapped or lost their mapping is written to stdout.
+//
+// Gene locations and biotypes, used to search by locus or biotype, can
+// be loaded at the same time from a GTF or BED file for the genes of
+// one source, e.g.
+//
+//	gex-annotate -db gex.db -gtf gencode.v49.annotation.gtf -source HGNC
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/store"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type (
+	geneTable struct {
+		source string
+		file   string
+		read   func(io.Reader) ([]*gex.GeneAnnotation, error)
+	}
+
+	report struct {
+		Annotations []*gex.AnnotationReport `json:"annotations,omitempty"`
+		Locations   *gex.LocationReport     `json:"locations,omitempty"`
+	}
+)
+
+func main() {
+	dbpath := flag.String("db", "", "sqlite catalog")
+	dsn := flag.String("postgres", "", "postgres catalog dsn, used instead of -db")
+	hgnc := flag.String("hgnc", "", "HGNC approved genes table")
+	mgi := flag.String("mgi", "", "MGI gene list")
+	gtf := flag.String("gtf", "", "GTF file of gene locations")
+	bed := flag.String("bed", "", "BED file of gene locations named by gene id or symbol")
+	source := flag.String("source", gex.SourceHgnc, "the genes the locations are for, HGNC or MGI")
+	dryRun := flag.Bool("dry-run", false, "report the changes without making them")
+	out := flag.String("out", "", "write the report to a file rather than stdout")
+
+	flag.Parse()
+
+	if (*dbpath == "") == (*dsn == "") {
+		fail(fmt.Errorf("one of -db or -postgres is required"))
+	}
+
+	tables := []*geneTable{}
+
+	if *hgnc != "" {
+		tables = append(tables, &geneTable{source: gex.SourceHgnc, file: *hgnc, read: gex.ReadHgncGenes})
+	}
+
+	if *mgi != "" {
+		tables = append(tables, &geneTable{source: gex.SourceMgi, file: *mgi, read: gex.ReadMgiGenes})
+	}
+
+	if *gtf != "" && *bed != "" {
+		fail(fmt.Errorf("only one of -gtf or -bed can be given"))
+	}
+
+	if len(tables) == 0 && *gtf == "" && *bed == "" {
+		fail(fmt.Errorf("at least one of -hgnc, -mgi, -gtf or -bed is required"))
+	}
+
+	var gdb *gex.GexDB
+	var err error
+
+	if *dsn != "" {
+		// expression files are never read
+		gdb, err = gex.NewPostgresGexDB(*dsn, store.NewLocalStore(""))
+	} else {
+		gdb, err = gex.NewGexDB(*dbpath)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+
+	defer gdb.Close()
+
+	var ret report
+
+	for _, table := range tables {
+		genes, err := readGenes(table)
+
+		if err != nil {
+			fail(err)
+		}
+
+		annotations, err := gdb.Annotate(table.source, genes, *dryRun)
+
+		if err != nil {
+			fail(err)
+		}
+
+		ret.Annotations = append(ret.Annotations, annotations)
+	}
+
+	// load locations after the genes are refreshed so new genes
+	// get them too
+	if *gtf != "" || *bed != "" {
+		locations, err := readLocations(*gtf, *bed)
+
+		if err != nil {
+			fail(err)
+		}
+
+		ret.Locations, err = gdb.LoadGeneLocations(*source, locations, *dryRun)
+
+		if err != nil {
+			fail(err)
+		}
+	}
+
+	w := os.Stdout
+
+	if *out != "" {
+		f, err := os.Create(*out)
+
+		if err != nil {
+			fail(err)
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(ret)
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func readGenes(table *geneTable) ([]*gex.GeneAnnotation, error) {
+	f, err := os.Open(table.file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return table.read(f)
+}
+
+func readLocations(gtf string, bed string) ([]*gex.GeneLocation, error) {
+	file := gtf
+	read := gex.ReadGtfLocations
+
+	if bed != "" {
+		file = bed
+		read = gex.ReadBedLocations
+	}
+
+	f, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return read(f)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gex-annotate:", err)
+	os.Exit(1)
+}
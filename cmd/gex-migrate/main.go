@@ -0,0 +1,78 @@
+// Upgrades a catalog built by an older version of
+// step2_make_gex_sql_bin.py to the schema this version of the service
+// needs, so it does not have to be rebuilt, e.g.
+//
+//	gex-migrate -db data/modules/gex/gex.db
+//
+// Catalogs made before there was a schema_version table are given one.
+// Each migration is logged and the version the catalog is now at is
+// written to stdout as JSON.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/store"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type report struct {
+	Version int `json:"version"`
+}
+
+func main() {
+	dbpath := flag.String("db", "", "sqlite catalog")
+	dsn := flag.String("postgres", "", "postgres catalog dsn, used instead of -db")
+
+	flag.Parse()
+
+	if (*dbpath == "") == (*dsn == "") {
+		fail(fmt.Errorf("one of -db or -postgres is required"))
+	}
+
+	var gdb *gex.GexDB
+	var err error
+
+	// an old catalog cannot be opened until it is migrated so it is
+	// migrated as it is opened
+	options := &gex.Options{Migrate: true}
+
+	if *dsn != "" {
+		// expression files are never read
+		options.Store = store.NewLocalStore("")
+		gdb, err = gex.OpenPostgresGexDB(*dsn, options)
+	} else {
+		gdb, err = gex.OpenGexDB(*dbpath, options)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+
+	defer gdb.Close()
+
+	version, _, err := gdb.SchemaVersion()
+
+	if err != nil {
+		fail(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(report{Version: version})
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gex-migrate:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,128 @@
+// Reads every block of expression values of a catalog to check it was
+// built correctly before it is deployed, e.g.
+//
+//	gex-verify -db data/modules/gex/gex.db -dataset ds1,ds2
+//
+// A JSON report of the problems with each dataset is written to stdout
+// and the command exits with status 1 if there are any, so it can be
+// used as a step of a build.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/store"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type report struct {
+	Ok       bool                `json:"ok"`
+	Datasets []*gex.VerifyReport `json:"datasets"`
+}
+
+func main() {
+	dbpath := flag.String("db", "", "sqlite catalog")
+	dsn := flag.String("postgres", "", "postgres catalog dsn, used instead of -db")
+	dir := flag.String("dir", "", "directory of the expression files of a postgres catalog")
+	datasets := flag.String("dataset", "", "comma separated public ids of the datasets to verify, by default all of them")
+	allowNonFinite := flag.Bool("allow-nan", false, "allow NaN and infinite values")
+	maxProblems := flag.Int("max-problems", gex.DefaultMaxVerifyProblems, "the most problems listed for each dataset")
+	out := flag.String("out", "", "write the report to a file rather than stdout")
+
+	flag.Parse()
+
+	if (*dbpath == "") == (*dsn == "") {
+		fail(fmt.Errorf("one of -db or -postgres is required"))
+	}
+
+	if *dsn != "" && *dir == "" {
+		fail(fmt.Errorf("-dir is required with -postgres"))
+	}
+
+	var gdb *gex.GexDB
+	var err error
+
+	if *dsn != "" {
+		gdb, err = gex.OpenPostgresGexDB(*dsn, &gex.Options{Store: store.NewLocalStore(*dir), ReadOnly: true})
+	} else {
+		gdb, err = gex.OpenGexDB(*dbpath, &gex.Options{ReadOnly: true})
+	}
+
+	if err != nil {
+		fail(err)
+	}
+
+	defer gdb.Close()
+
+	options := &gex.VerifyOptions{AllowNonFinite: *allowNonFinite, MaxProblems: *maxProblems}
+
+	ret := report{Ok: true}
+
+	if *datasets == "" {
+		ret.Datasets, err = gdb.VerifyAll(options)
+
+		if err != nil {
+			fail(err)
+		}
+	} else {
+		for _, id := range strings.Split(*datasets, ",") {
+			dataset, err := gdb.VerifyWithOptions(strings.TrimSpace(id), options)
+
+			if err != nil {
+				fail(err)
+			}
+
+			ret.Datasets = append(ret.Datasets, dataset)
+		}
+	}
+
+	for _, dataset := range ret.Datasets {
+		if !dataset.Ok {
+			ret.Ok = false
+		}
+	}
+
+	w := os.Stdout
+
+	if *out != "" {
+		f, err := os.Create(*out)
+
+		if err != nil {
+			fail(err)
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(ret)
+
+	if err != nil {
+		fail(err)
+	}
+
+	if !ret.Ok {
+		// the deferred calls are skipped by os.Exit
+		if w != os.Stdout {
+			w.Close()
+		}
+
+		gdb.Close()
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gex-verify:", err)
+	os.Exit(1)
+}
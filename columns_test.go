@@ -0,0 +1,85 @@
+package gex_test
+
+import (
+	"errors"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-sys/db"
+)
+
+// Migrating assumes the files list samples in the order they were
+// loaded, which is how older builds wrote them
+func TestMigrateSampleColumns(t *testing.T) {
+	gdb, err := gex.OpenGexDB(gextest.NewBaseline(t), &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer gdb.Close()
+
+	exprType, probes := myc(t, gdb)
+
+	results, err := gdb.Expression(gextest.SecretDataset, exprType, probes, true, nil)
+	check(t, "Expression", err)
+	want(t, "values", values(results.Probes[0].Values), values([]float32{20, 21, 22}))
+}
+
+// A catalog that gives a sample a column its files do not have is an
+// error rather than a panic or the values of the wrong sample
+func TestInvalidSampleColumn(t *testing.T) {
+	path := gextest.NewBaseline(t)
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gdb.Close()
+
+	// the secret dataset has 3 samples
+	exec(t, path, `UPDATE samples SET file_column = 3 WHERE id = 7`)
+
+	gdb, err = gex.OpenGexDB(path, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer gdb.Close()
+
+	exprType, probes := myc(t, gdb)
+
+	_, err = gdb.Expression(gextest.SecretDataset, exprType, probes, true, nil)
+
+	if !errors.Is(err, gex.ErrInvalidSampleColumn) {
+		t.Errorf("Expression: got %v, want %v", err, gex.ErrInvalidSampleColumn)
+	}
+
+	for _, method := range []string{gex.ScoreMethodZScore, gex.ScoreMethodSSGSEA} {
+		_, err = gdb.ScoreGeneSet(gextest.SecretDataset, exprType, &gex.GeneSet{}, probes, method, true, nil, nil)
+
+		if !errors.Is(err, gex.ErrInvalidSampleColumn) {
+			t.Errorf("%s: got %v, want %v", method, err, gex.ErrInvalidSampleColumn)
+		}
+	}
+}
+
+// Returns the TPM expression type and the probes of MYC
+func myc(t *testing.T, gdb *gex.GexDB) (*db.Entity, []*gex.Probe) {
+	t.Helper()
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.SecretDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+	check(t, "FindProbes", err)
+
+	return exprType, probes
+}
@@ -0,0 +1,243 @@
+package gex
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Queries are written for sqlite using :named params. A dialect
+	// rewrites them for the database the catalog is actually in.
+	Dialect interface {
+		Name() string
+		// The database/sql driver to open the catalog with
+		Driver() string
+		Rebind(query string, args []any) (string, []any)
+	}
+
+	sqliteDialect struct{}
+
+	// Postgres, via the pgx database/sql driver, only supports $n
+	// positional params so :named params are numbered in order of
+	// first use
+	postgresDialect struct {
+		// queries are constants so we only parse each one once
+		queries sync.Map
+	}
+
+	postgresQuery struct {
+		query string
+		names []string
+	}
+
+	// The catalog database with every query rebound for its dialect
+	// so callers can use the same SQL whatever the backend
+	catalog struct {
+		db      *sql.DB
+		dialect Dialect
+	}
+
+	// A transaction on the catalog whose queries are rebound the
+	// same way
+	catalogTx struct {
+		tx      *sql.Tx
+		dialect Dialect
+	}
+)
+
+const (
+	DialectSqlite   = "sqlite"
+	DialectPostgres = "postgres"
+
+	PgxDriver = "pgx"
+)
+
+var (
+	SqliteDialect   Dialect = &sqliteDialect{}
+	PostgresDialect Dialect = &postgresDialect{}
+)
+
+func (d *sqliteDialect) Name() string {
+	return DialectSqlite
+}
+
+func (d *sqliteDialect) Driver() string {
+	return "sqlite3"
+}
+
+// sqlite understands the queries as written
+func (d *sqliteDialect) Rebind(query string, args []any) (string, []any) {
+	return query, args
+}
+
+func (d *postgresDialect) Name() string {
+	return DialectPostgres
+}
+
+func (d *postgresDialect) Driver() string {
+	return PgxDriver
+}
+
+func (d *postgresDialect) Rebind(query string, args []any) (string, []any) {
+	var pq *postgresQuery
+
+	if v, ok := d.queries.Load(query); ok {
+		pq = v.(*postgresQuery)
+	} else {
+		pq = parsePostgresQuery(query)
+		d.queries.Store(query, pq)
+	}
+
+	// queries without named params are passed through as is
+	if len(pq.names) == 0 {
+		return query, args
+	}
+
+	namedArgs := make(map[string]any, len(args))
+
+	for _, arg := range args {
+		if namedArg, ok := arg.(sql.NamedArg); ok {
+			namedArgs[namedArg.Name] = namedArg.Value
+		}
+	}
+
+	ret := make([]any, len(pq.names))
+
+	for i, name := range pq.names {
+		v := namedArgs[name]
+
+		// sqlite has no bool type so queries compare flags such as
+		// :is_admin to 1
+		if b, ok := v.(bool); ok {
+			if b {
+				v = 1
+			} else {
+				v = 0
+			}
+		}
+
+		ret[i] = v
+	}
+
+	return pq.query, ret
+}
+
+// Replaces :name params with $n, using the same number each time a name
+// is repeated. Quoted strings and :: casts are left alone.
+func parsePostgresQuery(query string) *postgresQuery {
+	var b strings.Builder
+
+	names := make([]string, 0, 10)
+	index := make(map[string]int, 10)
+	inString := false
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		if c == '\'' {
+			inString = !inString
+		}
+
+		if inString || c != ':' {
+			b.WriteByte(c)
+			continue
+		}
+
+		// a cast
+		if i+1 < len(query) && query[i+1] == ':' {
+			b.WriteString("::")
+			i++
+			continue
+		}
+
+		j := i + 1
+
+		for j < len(query) && isParamChar(query[j], j == i+1) {
+			j++
+		}
+
+		// a lone colon
+		if j == i+1 {
+			b.WriteByte(c)
+			continue
+		}
+
+		name := query[i+1 : j]
+
+		n, ok := index[name]
+
+		if !ok {
+			names = append(names, name)
+			n = len(names)
+			index[name] = n
+		}
+
+		b.WriteString("$" + strconv.Itoa(n))
+
+		i = j - 1
+	}
+
+	return &postgresQuery{query: b.String(), names: names}
+}
+
+func isParamChar(c byte, first bool) bool {
+	if c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+		return true
+	}
+
+	return !first && '0' <= c && c <= '9'
+}
+
+func (c *catalog) Query(query string, args ...any) (*sql.Rows, error) {
+	query, args = c.dialect.Rebind(query, args)
+	return c.db.Query(query, args...)
+}
+
+func (c *catalog) QueryRow(query string, args ...any) *sql.Row {
+	query, args = c.dialect.Rebind(query, args)
+	return c.db.QueryRow(query, args...)
+}
+
+func (c *catalog) Exec(query string, args ...any) (sql.Result, error) {
+	query, args = c.dialect.Rebind(query, args)
+	return c.db.Exec(query, args...)
+}
+
+func (c *catalog) Close() error {
+	return c.db.Close()
+}
+
+func (c *catalog) Begin() (*catalogTx, error) {
+	tx, err := c.db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &catalogTx{tx: tx, dialect: c.dialect}, nil
+}
+
+func (t *catalogTx) Query(query string, args ...any) (*sql.Rows, error) {
+	query, args = t.dialect.Rebind(query, args)
+	return t.tx.Query(query, args...)
+}
+
+func (t *catalogTx) QueryRow(query string, args ...any) *sql.Row {
+	query, args = t.dialect.Rebind(query, args)
+	return t.tx.QueryRow(query, args...)
+}
+
+func (t *catalogTx) Exec(query string, args ...any) (sql.Result, error) {
+	query, args = t.dialect.Rebind(query, args)
+	return t.tx.Exec(query, args...)
+}
+
+func (t *catalogTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *catalogTx) Rollback() error {
+	return t.tx.Rollback()
+}
@@ -0,0 +1,212 @@
+package gex_test
+
+import (
+	"database/sql"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+)
+
+// what sqlite accepts but Postgres does not
+var sqliteOnly = regexp.MustCompile(`(?i)\b(GROUP_CONCAT|IFNULL|AUTOINCREMENT|PRAGMA|INSERT OR|REPLACE INTO|sqlite_\w+|julianday|strftime|datetime\()|` + "`")
+
+var postgresParam = regexp.MustCompile(`\$(\d+)`)
+
+// Every query is rebound for Postgres without any :name or ? params
+// left over, its $n params numbered from 1 with none skipped, and none
+// of the sqlite only syntax Postgres rejects. The queries run against
+// Postgres in TestBackends when GEX_TEST_POSTGRES_DSN is set, but this
+// covers every query, including those of admin changes and migrations.
+func TestPostgresRebind(t *testing.T) {
+	queries := sqlConstants(t)
+
+	for _, migration := range gex.Migrations {
+		for i, statement := range migration.Statements {
+			queries[fmt.Sprintf("migration %d statement %d", migration.Version, i+1)] = statement
+		}
+
+		queries[fmt.Sprintf("migration %d check", migration.Version)] = migration.Check
+	}
+
+	if len(queries) < 50 {
+		t.Fatalf("found %d queries, which is too few to be all of them", len(queries))
+	}
+
+	for name, query := range queries {
+		names := paramNames(query)
+
+		args := make([]any, 0, len(names))
+
+		for _, n := range names {
+			args = append(args, sql.Named(n, n))
+		}
+
+		rebound, reboundArgs := gex.PostgresDialect.Rebind(query, args)
+
+		code := outsideStrings(rebound)
+
+		if m := regexp.MustCompile(`[^:]:[A-Za-z_]|\?`).FindString(code); m != "" {
+			t.Errorf("%s: %q is left after rebinding: %s", name, m, rebound)
+		}
+
+		if m := sqliteOnly.FindString(code); m != "" {
+			t.Errorf("%s: %s is sqlite only: %s", name, m, query)
+		}
+
+		highest := 0
+
+		for _, m := range postgresParam.FindAllStringSubmatch(code, -1) {
+			n, _ := strconv.Atoi(m[1])
+			highest = max(highest, n)
+		}
+
+		if highest != len(names) || len(reboundArgs) != len(names) {
+			t.Errorf("%s: %d params became $%d with %d args: %s", name, len(names), highest, len(reboundArgs), rebound)
+		}
+
+		// each param is passed in the place of its number
+		for i, arg := range reboundArgs {
+			if !strings.Contains(code, "$"+strconv.Itoa(i+1)) || arg != names[i] {
+				t.Errorf("%s: $%d is %v, want %s", name, i+1, arg, names[i])
+			}
+		}
+	}
+}
+
+// The exported string constants of the package whose names end in SQL,
+// evaluated from the source since constants cannot be listed at run
+// time
+func sqlConstants(t *testing.T) map[string]string {
+	t.Helper()
+
+	fset := token.NewFileSet()
+
+	pkgs, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exprs := make(map[string]ast.Expr)
+
+	for _, file := range pkgs["gex"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+
+			for _, spec := range gen.Specs {
+				value := spec.(*ast.ValueSpec)
+
+				for i, name := range value.Names {
+					if i < len(value.Values) {
+						exprs[name.Name] = value.Values[i]
+					}
+				}
+			}
+		}
+	}
+
+	ret := make(map[string]string)
+
+	for name := range exprs {
+		if !ast.IsExported(name) || !(strings.HasSuffix(name, "SQL") || strings.HasSuffix(name, "Sql")) {
+			continue
+		}
+
+		s, ok := evalString(exprs, exprs[name])
+
+		if !ok {
+			t.Fatalf("%s is not a string constant", name)
+		}
+
+		ret[name] = s
+	}
+
+	return ret
+}
+
+// Evaluates string literals, other constants and + between them
+func evalString(exprs map[string]ast.Expr, expr ast.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+
+		s, err := strconv.Unquote(e.Value)
+
+		return s, err == nil
+	case *ast.Ident:
+		other, ok := exprs[e.Name]
+
+		if !ok {
+			return "", false
+		}
+
+		return evalString(exprs, other)
+	case *ast.ParenExpr:
+		return evalString(exprs, e.X)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+
+		x, ok := evalString(exprs, e.X)
+
+		if !ok {
+			return "", false
+		}
+
+		y, ok := evalString(exprs, e.Y)
+
+		return x + y, ok
+	default:
+		return "", false
+	}
+}
+
+var namedParam = regexp.MustCompile(`(^|[^:]):([A-Za-z_][A-Za-z0-9_]*)`)
+
+// The :name params of a query in order of first use
+func paramNames(query string) []string {
+	ret := []string{}
+	seen := make(map[string]struct{})
+
+	for _, m := range namedParam.FindAllStringSubmatch(outsideStrings(query), -1) {
+		if _, ok := seen[m[2]]; !ok {
+			seen[m[2]] = struct{}{}
+			ret = append(ret, m[2])
+		}
+	}
+
+	return ret
+}
+
+// Blanks out quoted strings so their contents are not read as SQL
+func outsideStrings(query string) string {
+	b := []byte(query)
+	inString := false
+
+	for i, c := range b {
+		if c == '\'' {
+			inString = !inString
+		} else if inString {
+			b[i] = ' '
+		}
+	}
+
+	return string(b)
+}
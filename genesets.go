@@ -0,0 +1,216 @@
+package gex
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-web"
+)
+
+type (
+	// A named list of genes such as an MSigDB hallmark set
+	GeneSet struct {
+		db.Entity
+		Collection  string   `json:"collection,omitempty"`
+		Description string   `json:"description,omitempty"`
+		Url         string   `json:"url,omitempty"`
+		Size        int      `json:"size"`
+		Genes       []string `json:"genes,omitempty"`
+		// the ids we search for, which is the hugo/mgi id if
+		// we could match the member or else the symbol as given
+		geneIds []string
+	}
+)
+
+const (
+	DefaultGeneSetSearchN = 20
+	MaxGeneSetSearchN     = 100
+
+	BaseGeneSetsSQL = `SELECT
+		gs.id,
+		gs.public_id,
+		gs.name,
+		gs.collection,
+		gs.description,
+		gs.url,
+		COUNT(gsm.ord) AS size
+		FROM gene_sets gs
+		JOIN genomes g ON g.id = gs.genome_id
+		LEFT JOIN gene_set_members gsm ON gsm.gene_set_id = gs.id
+		WHERE
+			LOWER(g.name) = :genome`
+
+	GeneSetsSQL = BaseGeneSetsSQL +
+		` GROUP BY gs.id
+		ORDER BY gs.collection, gs.name`
+
+	SearchGeneSetsSQL = BaseGeneSetsSQL +
+		` AND (LOWER(gs.name) LIKE :q OR LOWER(gs.collection) LIKE :q)
+		GROUP BY gs.id
+		ORDER BY gs.collection, gs.name
+		LIMIT :n`
+
+	GeneSetSQL = `SELECT
+		gs.id,
+		gs.public_id,
+		gs.name,
+		gs.collection,
+		gs.description,
+		gs.url
+		FROM gene_sets gs
+		WHERE
+			gs.genome_id = :genome
+			AND (gs.public_id = :id OR LOWER(gs.name) = :id)
+		LIMIT 1`
+
+	// members in the order they were listed in the original set
+	GeneSetMembersSQL = `SELECT
+		gsm.symbol,
+		COALESCE(ge.gene_id, '') AS gene_id
+		FROM gene_set_members gsm
+		LEFT JOIN genes ge ON ge.id = gsm.gene_id
+		WHERE
+			gsm.gene_set_id = :id
+		ORDER BY gsm.ord`
+)
+
+var ErrGeneSetNotFound = errors.New("gene set not found")
+
+func (gdb *GexDB) GeneSets(genome string) ([]*GeneSet, error) {
+	return gdb.queryGeneSets(GeneSetsSQL, sql.Named("genome", web.FormatParam(genome)))
+}
+
+// Search gene sets by name or collection within a genome returning at
+// most n sets, which is capped at MaxGeneSetSearchN
+func (gdb *GexDB) SearchGeneSets(genome string, q string, n int) ([]*GeneSet, error) {
+	if n <= 0 {
+		n = DefaultGeneSetSearchN
+	}
+
+	n = min(n, MaxGeneSetSearchN)
+
+	return gdb.queryGeneSets(SearchGeneSetsSQL,
+		sql.Named("genome", web.FormatParam(genome)),
+		sql.Named("q", "%"+web.FormatParam(q)+"%"),
+		sql.Named("n", n))
+}
+
+func (gdb *GexDB) queryGeneSets(query string, namedArgs ...any) ([]*GeneSet, error) {
+	rows, err := gdb.db.Query(query, namedArgs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	geneSets := make([]*GeneSet, 0, 50)
+
+	for rows.Next() {
+		var geneSet GeneSet
+
+		err := rows.Scan(
+			&geneSet.Id,
+			&geneSet.PublicId,
+			&geneSet.Name,
+			&geneSet.Collection,
+			&geneSet.Description,
+			&geneSet.Url,
+			&geneSet.Size)
+
+		if err != nil {
+			return nil, err
+		}
+
+		geneSets = append(geneSets, &geneSet)
+	}
+
+	return geneSets, nil
+}
+
+// Returns a gene set, including its member genes, using either its
+// public id or its name
+func (gdb *GexDB) GeneSet(genome *db.Entity, id string) (*GeneSet, error) {
+
+	var ret GeneSet
+
+	err := gdb.db.QueryRow(GeneSetSQL,
+		sql.Named("genome", genome.Id),
+		sql.Named("id", web.FormatParam(id))).Scan(
+		&ret.Id,
+		&ret.PublicId,
+		&ret.Name,
+		&ret.Collection,
+		&ret.Description,
+		&ret.Url)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrGeneSetNotFound, id)
+		}
+
+		return nil, err
+	}
+
+	rows, err := gdb.db.Query(GeneSetMembersSQL, sql.Named("id", ret.Id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret.Genes = make([]string, 0, 200)
+	ret.geneIds = make([]string, 0, 200)
+
+	for rows.Next() {
+		var symbol string
+		var geneId string
+
+		err := rows.Scan(&symbol, &geneId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Genes = append(ret.Genes, symbol)
+
+		// prefer the hugo/mgi id since symbols in sets can be
+		// out of date
+		if geneId != "" {
+			ret.geneIds = append(ret.geneIds, geneId)
+		} else {
+			ret.geneIds = append(ret.geneIds, symbol)
+		}
+	}
+
+	ret.Size = len(ret.Genes)
+
+	return &ret, nil
+}
+
+// The ids of the member genes to search for with FindProbes
+func (geneSet *GeneSet) GeneIds() []string {
+	return geneSet.geneIds
+}
+
+// Expands a list of gene set ids into the ids of their member genes so
+// they can be passed to FindProbes. Sets are expanded in the order
+// given and members keep the order of the original set.
+func (gdb *GexDB) GeneSetGenes(genome *db.Entity, ids []string) ([]string, error) {
+	ret := make([]string, 0, len(ids)*200)
+
+	for _, id := range ids {
+		geneSet, err := gdb.GeneSet(genome, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, geneSet.geneIds...)
+	}
+
+	return ret, nil
+}
@@ -2,19 +2,22 @@ package gex
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/antonybholmes/go-sys"
-	"github.com/antonybholmes/go-sys/collections"
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-gex/store"
 	"github.com/antonybholmes/go-sys/db"
 	"github.com/antonybholmes/go-sys/log"
 	"github.com/antonybholmes/go-web"
-	"github.com/antonybholmes/go-web/auth/sqlite"
 )
 
 type (
@@ -25,6 +28,15 @@ type (
 		Refseq     string `json:"refseq,omitempty"`
 		Ncbi       string `json:"ncbi,omitempty"`
 		Source     string `json:"source,omitempty"` // e.g. HUGO or MGI
+		// 1-based inclusive location if known
+		Chr    string `json:"chr,omitempty"`
+		Start  int    `json:"start,omitempty"`
+		End    int    `json:"end,omitempty"`
+		Strand string `json:"strand,omitempty"`
+		// e.g. protein_coding or lncRNA
+		Biotype string `json:"biotype,omitempty"`
+		// HGNC gene groups such as Immunoglobulin heavy locus at 14q32.33
+		Groups []string `json:"groups,omitempty"`
 		db.IdEntity
 	}
 
@@ -60,7 +72,17 @@ type (
 		Technology  *db.Entity `json:"technology"`
 		Platform    string     `json:"platform,omitempty"`
 		Institution string     `json:"institution"`
-		Samples     []*Sample  `json:"samples,omitempty"`
+		Description string     `json:"description,omitempty"`
+		// accessions so users can find the original publication
+		// and raw data
+		Pubmed      string    `json:"pubmed,omitempty"`
+		Geo         string    `json:"geo,omitempty"`
+		Ega         string    `json:"ega,omitempty"`
+		SampleCount int       `json:"sampleCount"`
+		ProbeCount  int       `json:"probeCount"`
+		CreatedAt   string    `json:"createdAt,omitempty"`
+		UpdatedAt   string    `json:"updatedAt,omitempty"`
+		Samples     []*Sample `json:"samples,omitempty"`
 		//Metadata    []string    `json:"metadata,omitempty"`
 		ExprTypes []*db.Entity `json:"exprTypes,omitempty"`
 	}
@@ -89,18 +111,82 @@ type (
 		//Platform     *ValueType       `json:"platform"`
 		//GexValue *GexValue    `json:"gexType"`
 		Values []float32 `json:"values"`
+		// if set, the row is a gene set score calculated using
+		// this method rather than a real probe
+		Score string `json:"score,omitempty"`
+	}
+
+	// a page of samples from a dataset
+	SamplesPage struct {
+		Samples []*Sample `json:"samples"`
+		Page    int       `json:"page"`
+		N       int       `json:"n"`
+		Total   int       `json:"total"`
+	}
+
+	// How to open a catalog
+	Options struct {
+		// where the expression files are, by default the directory
+		// of a sqlite catalog
+		Store store.ExpressionStore
+		// never write to the catalog, e.g. because it is on a read
+		// only volume, so admin changes return ErrReadOnly
+		ReadOnly bool
+		// upgrade an older catalog to SchemaVersion when it is opened
+		Migrate bool
+		// the sqlite journal mode the read/write connection sets, such
+		// as JournalModeWAL. Empty leaves the catalog's mode as it is
+		// since a mode such as WAL is kept by the file once written
+		JournalMode string
 	}
 
 	GexDB struct {
-		db  *sql.DB
-		dir string
+		db *catalog
+		// used for admin changes such as granting permissions, nil
+		// if the catalog is read only
+		rwdb *catalog
+		dir  string
+		// where the binary expression files are read from
+		store store.ExpressionStore
+		// how much each request can ask for
+		limits *Limits
+		// the sqlite file, used to tell when the catalog changes
+		dbpath string
+		// decoded expression values of recently read probes
+		blocks       *cache.LRU[blockKey, []float32]
+		catalogCache *catalogCache
+		// search results, which may be shared with other replicas
+		results    cache.Cache
+		resultsTTL time.Duration
+		// the name the catalog is registered under, which keeps its
+		// cached results apart from other catalogs sharing the cache
+		name string
+		// assigned when the catalog is opened so results cached by
+		// requests on a catalog that has been reloaded are never read
+		// from its replacement
+		generation string
+		// requests using the catalog, which must finish before it is
+		// closed when a new release replaces it
+		requests sync.WaitGroup
 	}
 )
 
 const (
-	DefaultNumSamples = 500
-	MaxDatasets       = 10
-	MaxProbes         = 200
+	DefaultNumSamples  = 500
+	MaxSamplesPageSize = 1000
+
+	// Read only access to the catalog. Unlike db.SqliteDSN the file is
+	// not marked immutable, so changes made through the read/write
+	// connection, such as granting permissions, are seen immediately
+	ReadOnlyDSN  = "?mode=ro&_foreign_keys=OFF&_cache_size=-32768&_mmap_size=134217728"
+	ReadWriteDSN = "?_foreign_keys=ON&_busy_timeout=5000"
+
+	JournalModeDelete   = "DELETE"
+	JournalModeTruncate = "TRUNCATE"
+	JournalModePersist  = "PERSIST"
+	JournalModeMemory   = "MEMORY"
+	JournalModeWAL      = "WAL"
+	JournalModeOff      = "OFF"
 
 	GexTypeCounts = "Counts"
 	GexTypeTPM    = "TPM"
@@ -143,12 +229,29 @@ const (
 		t.public_id AS technology_public_id,
 		t.name AS technology_name
 		FROM datasets d
-		JOIN dataset_permissions dp ON d.id = dp.dataset_id
-		JOIN permissions p ON dp.permission_id = p.id
 		JOIN genomes g ON d.genome_id = g.id
 		JOIN technologies t ON d.technology_id = t.id
 		WHERE
-			d.public_id = :id`
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
+			AND d.public_id = :id`
+
+	// samples without permissions of their own can be seen by anyone
+	// who can see their dataset, otherwise users need one of the
+	// sample's permissions
+	VisibleSamplesSQL = `(NOT EXISTS (SELECT 1 FROM sample_permissions sp WHERE sp.sample_id = s.id)
+		OR s.id IN (
+			SELECT sp.sample_id
+			FROM sample_permissions sp
+			JOIN permissions p ON sp.permission_id = p.id
+			WHERE <<PERMISSIONS>>))`
+
+	// the number of samples in a dataset the user can see
+	SampleCountSQL = `(SELECT COUNT(s.id) FROM samples s WHERE s.dataset_id = d.id AND ` +
+		VisibleSamplesSQL + `)`
 
 	BaseDatasetsSQL = `SELECT 
 		d.id,
@@ -156,6 +259,14 @@ const (
 		d.name,
 		d.platform,
 		d.institution,
+		d.description,
+		d.pubmed,
+		d.geo,
+		d.ega,
+		` + SampleCountSQL + ` AS sample_count,
+		d.probe_count,
+		d.created_at,
+		d.updated_at,
 		g.id AS genome_id,
 		g.public_id AS genome_public_id,
 		g.name AS genome_name,
@@ -167,17 +278,20 @@ const (
 		s.name AS sample_name,
 		m.name AS metadata_name,
 		smd.value AS metadata_value,
-		m.color AS metadata_color
+		COALESCE(mc.color, m.color) AS metadata_color
 		FROM datasets d
-		JOIN dataset_permissions dp ON d.id = dp.dataset_id
-		JOIN permissions p ON dp.permission_id = p.id
 		JOIN genomes g ON d.genome_id = g.id
 		JOIN technologies t ON d.technology_id = t.id
-		JOIN samples s ON s.dataset_id = d.id
-		JOIN sample_metadata smd ON smd.sample_id = s.id
-		JOIN metadata m ON smd.metadata_id = m.id
+		LEFT JOIN samples s ON s.dataset_id = d.id AND ` + VisibleSamplesSQL + `
+		LEFT JOIN sample_metadata smd ON smd.sample_id = s.id
+		LEFT JOIN metadata m ON smd.metadata_id = m.id
+		LEFT JOIN metadata_categories mc ON mc.dataset_id = d.id AND mc.metadata_id = m.id AND mc.name = smd.value
 		WHERE
-			<<PERMISSIONS>>`
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)`
 
 	// DatasetsSQL = BaseDatasetsSQL +
 	// 	` AND d.genome_id = :gid
@@ -190,17 +304,121 @@ const (
 		AND LOWER(t.name) = :technology
 		ORDER BY d.name, s.id, m.name`
 
-	DatasetFromIdSQL = BaseDatasetsSQL + ` AND d.public_id = :id`
+	DatasetFromIdSQL = BaseDatasetsSQL +
+		` AND d.public_id = :id
+		ORDER BY s.id, m.name`
+
+	// datasets without their samples for quickly listing what is available
+	DatasetSummariesSQL = `SELECT 
+		d.id,
+		d.public_id,
+		d.name,
+		d.platform,
+		d.institution,
+		d.description,
+		d.pubmed,
+		d.geo,
+		d.ega,
+		` + SampleCountSQL + ` AS sample_count,
+		d.probe_count,
+		d.created_at,
+		d.updated_at,
+		g.id AS genome_id,
+		g.public_id AS genome_public_id,
+		g.name AS genome_name,
+		t.id AS technology_id,
+		t.public_id AS technology_public_id,
+		t.name AS technology_name
+		FROM datasets d
+		JOIN genomes g ON d.genome_id = g.id
+		JOIN technologies t ON d.technology_id = t.id
+		WHERE
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
+			AND LOWER(g.name) = :genome 
+			AND LOWER(t.name) = :technology
+		ORDER BY d.name`
+
+	DatasetSampleCountSQL = `SELECT
+		COUNT(s.id)
+		FROM samples s
+		JOIN datasets d ON d.id = s.dataset_id
+		WHERE
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
+			AND d.public_id = :id
+			AND ` + VisibleSamplesSQL
+
+	// a page of samples in a dataset with their metadata. Samples
+	// are paged first so that the limit applies to samples rather
+	// than metadata rows
+	DatasetSamplesSQL = `SELECT
+		s.id,
+		s.public_id,
+		s.name,
+		m.id AS metadata_id,
+		m.public_id AS metadata_public_id,
+		m.name AS metadata_name,
+		smd.value AS metadata_value,
+		COALESCE(mc.color, m.color) AS metadata_color
+		FROM (
+			SELECT
+			s.id,
+			s.public_id,
+			s.name,
+			s.dataset_id
+			FROM samples s
+			JOIN datasets d ON d.id = s.dataset_id
+			WHERE
+				d.id IN (
+					SELECT dp.dataset_id
+					FROM dataset_permissions dp
+					JOIN permissions p ON dp.permission_id = p.id
+					WHERE <<PERMISSIONS>>)
+				AND d.public_id = :id
+				AND ` + VisibleSamplesSQL + `
+			ORDER BY s.id
+			LIMIT :limit
+			OFFSET :offset
+		) s
+		LEFT JOIN sample_metadata smd ON smd.sample_id = s.id
+		LEFT JOIN metadata m ON smd.metadata_id = m.id
+		LEFT JOIN metadata_categories mc ON mc.dataset_id = s.dataset_id AND mc.metadata_id = m.id AND mc.name = smd.value
+		ORDER BY s.id, m.name`
+
+	// the column of each sample of a dataset in its expression files
+	// and whether the user can see it, in the order samples are listed
+	SampleColumnsSQL = `SELECT
+		s.file_column,
+		` + VisibleSamplesSQL + ` AS visible
+		FROM samples s
+		JOIN datasets d ON d.id = s.dataset_id
+		WHERE
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
+			AND d.public_id = :id
+		ORDER BY s.id`
 
 	BasicDatasetSQL = `SELECT
 		d.id,
 		d.public_id,
 		d.name
 		FROM datasets d
-		JOIN dataset_permissions dp ON d.id = dp.dataset_id
-		JOIN permissions p ON dp.permission_id = p.id
 		WHERE
-			<<PERMISSIONS>>
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
 			AND d.public_id = :id`
 
 	SamplesSQL = `SELECT
@@ -217,7 +435,7 @@ const (
 		FROM metadata m
 		ORDER BY m.name`
 
-	// order by sample id and then sample_metadata.id to ensure consistent order of metadata for each sample
+	// order by sample id and then metadata id to ensure consistent order of metadata for each sample
 	// as it was read from its original source file
 	SampleMetadataSQL = `SELECT
 		s.id AS sample_id,
@@ -229,7 +447,7 @@ const (
 		FROM sample_metadata smd
 		JOIN metadata m ON smd.metadata_id = m.id
 		JOIN samples s ON smd.sample_id = s.id
-		ORDER by s.id, smd.id`
+		ORDER by s.id, m.id`
 
 	// ExprTypesSQL = `SELECT DISTINCT
 	// 	e.id,
@@ -252,10 +470,13 @@ const (
 		FROM expression_types e
 		JOIN expression ex ON e.id = ex.expression_type_id
 		JOIN datasets d ON ex.dataset_id = d.id
-		JOIN dataset_permissions dp ON d.id = dp.dataset_id
-		JOIN permissions p ON dp.permission_id = p.id
 		WHERE
-			d.id = :id
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
+			AND d.id = :id
 		ORDER BY e.name`
 
 	ExprTypeSQL = `SELECT
@@ -282,16 +503,17 @@ const (
 	// 		LOWER(g.symbol) LIKE :id
 	// 	LIMIT 1`
 
-	CreateIdTableSQL = `CREATE TEMP TABLE IF NOT EXISTS ids (
-        id TEXT NOT NULL UNIQUE,
-        ord INTEGER NOT NULL
-    )`
+	IdsPlaceholder = "<<IDS>>"
 
 	// Ids we want to seach for e.g. either probe ids or gene symbols or gene ids
-	// are inserted into a temp table with an order column to maintain the order of the input genes
-	InsertIdSQL = `INSERT INTO ids (id, ord) VALUES (LOWER(:id), :ord) ON CONFLICT(id) DO NOTHING`
-
-	ProbesSQL = `SELECT DISTINCT
+	// are passed as a list of values with an order column to maintain the order
+	// of the input genes. Being part of the query, rather than a temp table,
+	// means concurrent searches cannot see each other's ids.
+	//
+	// probes matched by more than one id are grouped and placed
+	// by the first id that matched them
+	ProbesSQL = `WITH ids(id, ord) AS (<<IDS>>)
+		SELECT
 		p.probe_id,
 		p.probe_public_id,
 		p.probe_name,
@@ -303,7 +525,13 @@ const (
 		p.symbol,
 		p.ensembl,
 		p.refseq,
-		p.ncbi
+		p.ncbi,
+		p.chr,
+		p.start,
+		p."end",
+		p.strand,
+		p.biotype,
+		p.gene_group
 		FROM (
 			SELECT DISTINCT
 			p.id AS probe_id,
@@ -321,7 +549,13 @@ const (
 			COALESCE(ge.symbol, '') AS symbol,
 			COALESCE(ge.ensembl, '') AS ensembl,
 			COALESCE(ge.refseq, '') AS refseq,
-			COALESCE(ge.ncbi, '') AS ncbi,
+			COALESCE(CAST(NULLIF(ge.ncbi, 0) AS TEXT), '') AS ncbi,
+			COALESCE(ge.chr, '') AS chr,
+			COALESCE(ge.start, 0) AS start,
+			COALESCE(ge."end", 0) AS "end",
+			COALESCE(ge.strand, '') AS strand,
+			COALESCE(ge.biotype, '') AS biotype,
+			COALESCE(ge.gene_group, '') AS gene_group,
 			ids.ord
 			FROM probes p
 			JOIN genomes g ON g.id = p.genome_id
@@ -343,7 +577,26 @@ const (
 				g.id = :genome
 				AND t.id = :technology
 		) p
-		ORDER BY p.ord`
+		GROUP BY
+			p.probe_id,
+			p.probe_public_id,
+			p.probe_name,
+			p.probe_symbol,
+			p.gid,
+			p.gene_public_id,
+			p.source,
+			p.gene_id,
+			p.symbol,
+			p.ensembl,
+			p.refseq,
+			p.ncbi,
+			p.chr,
+			p.start,
+			p."end",
+			p.strand,
+			p.biotype,
+			p.gene_group
+		ORDER BY MIN(p.ord)`
 
 	// ProbeIdsSQL = `SELECT DISTINCT
 	// 	p.id AS probe_id,
@@ -385,42 +638,166 @@ const (
 		e.length
 		FROM expression e
 		JOIN datasets d ON d.id = e.dataset_id
-		JOIN dataset_permissions dp ON d.id = dp.dataset_id
-		JOIN permissions p ON dp.permission_id = p.id
 		JOIN files f ON e.file_id = f.id
 		WHERE 
-			<<PERMISSIONS>>
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
 			AND e.probe_id = :probe
 			AND e.expression_type_id = :type
 			AND d.public_id = :dataset`
 )
 
-func NewGexDB(dbpath string) *GexDB {
+// Opens a catalog whose expression files are in the same directory
+func NewGexDB(dbpath string) (*GexDB, error) {
+	return OpenGexDB(dbpath, nil)
+}
+
+// Opens a catalog whose expression files are in a store such as an
+// S3 bucket. The urls in the files table are relative to the root of
+// the store.
+func NewGexDBWithStore(dbpath string, expressionStore store.ExpressionStore) (*GexDB, error) {
+	return OpenGexDB(dbpath, &Options{Store: expressionStore})
+}
+
+// Options.JournalMode is not one sqlite supports
+var ErrInvalidJournalMode = errors.New("invalid journal mode")
+
+// Opens a sqlite catalog, checking it exists and has the tables and
+// columns this version needs so problems are found at startup rather
+// than by the first search. Nil options use the defaults.
+func OpenGexDB(dbpath string, options *Options) (*GexDB, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	log.Debug().Msgf("Initializing GexDB with path: %s", dbpath)
+
+	dsn := ReadWriteDSN
+
+	if options.JournalMode != "" {
+		mode := strings.ToUpper(options.JournalMode)
+
+		switch mode {
+		case JournalModeDelete, JournalModeTruncate, JournalModePersist, JournalModeMemory, JournalModeWAL, JournalModeOff:
+			dsn += "&_journal_mode=" + mode
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrInvalidJournalMode, options.JournalMode)
+		}
+	}
+
+	// sqlite would otherwise create an empty catalog
+	_, err := os.Stat(dbpath)
+
+	if err != nil {
+		return nil, fmt.Errorf("gex catalog %s: %w", dbpath, err)
+	}
 
 	dir := filepath.Dir(dbpath)
 
-	// db, err := sql.Open("sqlite3", path)
+	expressionStore := options.Store
 
-	// if err != nil {
-	// 	log.Fatal().Msgf("%s", err)
-	// }
+	if expressionStore == nil {
+		expressionStore = store.NewLocalStore(dir)
+	}
 
-	// defer db.Close()
+	rodb, err := sql.Open(db.Sqlite3DB, dbpath+ReadOnlyDSN)
 
-	log.Debug().Msgf("Initializing GexDB with path: %s", dbpath)
+	if err != nil {
+		return nil, err
+	}
+
+	gdb := &GexDB{dir: dir,
+		db:           &catalog{db: rodb, dialect: SqliteDialect},
+		store:        expressionStore,
+		limits:       DefaultLimits(),
+		dbpath:       dbpath,
+		blocks:       newBlockCache(DefaultBlockCacheSize),
+		catalogCache: newCatalogCache()}
+
+	if !options.ReadOnly {
+		rwdb, err := sql.Open(db.Sqlite3DB, dbpath+dsn)
+
+		if err != nil {
+			rodb.Close()
+			return nil, err
+		}
+
+		// writes are rare admin tasks so one connection is enough and
+		// avoids writers waiting on each other
+		rwdb.SetMaxOpenConns(1)
+
+		gdb.rwdb = &catalog{db: rwdb, dialect: SqliteDialect}
+	}
+
+	err = gdb.open(options)
+
+	if err != nil {
+		gdb.Close()
+		return nil, err
+	}
+
+	return gdb, nil
+}
+
+// Migrates the catalog if asked to and checks it can be used
+func (gdb *GexDB) open(options *Options) error {
+	gdb.generation = rand.Text()
+
+	if options.Migrate {
+		_, err := gdb.Migrate()
+
+		if err != nil {
+			return err
+		}
+	}
 
-	return &GexDB{dir: dir, db: sys.Must(sql.Open(db.Sqlite3DB, dbpath+db.SqliteDSN))}
+	return gdb.checkSchema()
+}
+
+func (gdb *GexDB) Dialect() Dialect {
+	return gdb.db.dialect
 }
 
 func (gdb *GexDB) Close() error {
-	return gdb.db.Close()
+	// postgres uses the same pool for reads and writes
+	if gdb.rwdb == nil || gdb.rwdb == gdb.db {
+		return gdb.db.Close()
+	}
+
+	return errors.Join(gdb.db.Close(), gdb.rwdb.Close())
+}
+
+// The connections to the catalog used for reads
+func (gdb *GexDB) DBStats() sql.DBStats {
+	return gdb.db.db.Stats()
 }
 
 func (gdb *GexDB) Dir() string {
 	return gdb.dir
 }
 
+func (gdb *GexDB) Store() store.ExpressionStore {
+	return gdb.store
+}
+
+func (gdb *GexDB) Limits() *Limits {
+	return gdb.limits
+}
+
+// Sets how much each request can ask for. This should be done
+// before the catalog is used.
+func (gdb *GexDB) SetLimits(limits *Limits) {
+	gdb.limits = limits
+}
+
 func (gdb *GexDB) Genomes() ([]*db.Entity, error) {
+	return cachedCatalog(gdb, "genomes", gdb.genomes)
+}
+
+func (gdb *GexDB) genomes() ([]*db.Entity, error) {
 
 	genomes := make([]*db.Entity, 0, 10)
 
@@ -451,6 +828,10 @@ func (gdb *GexDB) Genomes() ([]*db.Entity, error) {
 }
 
 func (gdb *GexDB) Technologies() ([]*db.Entity, error) {
+	return cachedCatalog(gdb, "technologies", gdb.technologies)
+}
+
+func (gdb *GexDB) technologies() ([]*db.Entity, error) {
 
 	technologies := make([]*db.Entity, 0, 10)
 
@@ -554,14 +935,51 @@ func (gdb *GexDB) Datasets(genome string,
 	permissions []string,
 	isAdmin bool) ([]*Dataset, error) {
 
+	key := datasetsCacheKey("datasets", web.FormatParam(genome), web.FormatParam(technology), isAdmin, permissions)
+
+	return cachedCatalog(gdb, key, func() ([]*Dataset, error) {
+		return gdb.datasets(genome, technology, permissions, isAdmin)
+	})
+}
+
+func (gdb *GexDB) datasets(genome string,
+	technology string,
+	permissions []string,
+	isAdmin bool) ([]*Dataset, error) {
+
 	namedArgs := []any{sql.Named("genome", web.FormatParam(genome)),
 		sql.Named("technology", web.FormatParam(technology))}
 
 	//log.Debug().Msgf("Query: %s, Args: %v", DatasetsSQL, namedArgs)
 
-	query := sqlite.MakePermissionsSql(DatasetsSQL, isAdmin, permissions, &namedArgs)
+	rows, err := gdb.queryWithPermissions(DatasetsSQL, isAdmin, permissions, namedArgs...)
 
-	rows, err := gdb.db.Query(query, namedArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	datasets, err := scanDatasets(rows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = gdb.addExprTypes(datasets, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datasets, nil
+}
+
+// Returns a single dataset with its samples and expression types so
+// users can see what it contains before selecting it
+func (gdb *GexDB) Dataset(datasetId string, isAdmin bool, permissions []string) (*Dataset, error) {
+
+	rows, err := gdb.queryWithPermissions(DatasetFromIdSQL, isAdmin, permissions, sql.Named("id", datasetId))
 
 	if err != nil {
 		return nil, err
@@ -569,6 +987,28 @@ func (gdb *GexDB) Datasets(genome string,
 
 	defer rows.Close()
 
+	datasets, err := scanDatasets(rows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(datasets) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	err = gdb.addExprTypes(datasets, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datasets[0], nil
+}
+
+// Reads datasets with one row per sample metadata value, grouping
+// rows into their datasets and samples
+func scanDatasets(rows *sql.Rows) ([]*Dataset, error) {
 	datasets := make([]*Dataset, 0, 10)
 
 	var currentDataset *Dataset
@@ -585,24 +1025,21 @@ func (gdb *GexDB) Datasets(genome string,
 		//dataset.Technology = &db.Entity{}
 		//dataset.Samples = make([]*Sample, 0, 10)
 
-		err := rows.Scan(
-			&dataset.Id,
-			&dataset.PublicId,
-			&dataset.Name,
-			&dataset.Platform,
-			&dataset.Institution,
-			&genome.Id,
-			&genome.PublicId,
-			&genome.Name,
-			&technology.Id,
-			&technology.PublicId,
-			&technology.Name,
-			&sample.Id,
-			&sample.PublicId,
-			&sample.Name,
-			&metadata.Name,
-			&metadata.Value,
-			&metadata.Color)
+		// samples and metadata are left joined so may be missing
+		var sampleId sql.NullInt64
+		var samplePublicId sql.NullString
+		var sampleName sql.NullString
+		var metadataName sql.NullString
+		var metadataValue sql.NullString
+		var metadataColor sql.NullString
+
+		err := rows.Scan(append(datasetScanArgs(&dataset, &genome, &technology),
+			&sampleId,
+			&samplePublicId,
+			&sampleName,
+			&metadataName,
+			&metadataValue,
+			&metadataColor)...)
 
 		if err != nil {
 			return nil, err
@@ -617,50 +1054,238 @@ func (gdb *GexDB) Datasets(genome string,
 			datasets = append(datasets, &dataset)
 		}
 
-		if currentSample == nil || currentSample.Id != sample.Id {
+		if !sampleId.Valid {
+			continue
+		}
+
+		if currentSample == nil || currentSample.Id != int(sampleId.Int64) {
+			sample.Id = int(sampleId.Int64)
+			sample.PublicId = samplePublicId.String
+			sample.Name = sampleName.String
+
 			currentSample = &sample
 			currentSample.Metadata = make([]*NamedValue, 0, 20)
 			currentDataset.Samples = append(currentDataset.Samples, currentSample)
 		}
 
+		if !metadataName.Valid {
+			continue
+		}
+
+		metadata.Name = metadataName.String
+		metadata.Value = metadataValue.String
+		metadata.Color = metadataColor.String
+
 		currentSample.Metadata = append(currentSample.Metadata, &metadata)
 	}
 
-	// Add expr types
+	return datasets, nil
+}
 
-	for _, dataset := range datasets {
-		dataset.ExprTypes = make([]*db.Entity, 0, 5)
+// the scan destinations for the dataset, genome and technology columns
+// that every dataset query begins with
+func datasetScanArgs(dataset *Dataset, genome *db.Entity, technology *db.Entity) []any {
+	return []any{
+		&dataset.Id,
+		&dataset.PublicId,
+		&dataset.Name,
+		&dataset.Platform,
+		&dataset.Institution,
+		&dataset.Description,
+		&dataset.Pubmed,
+		&dataset.Geo,
+		&dataset.Ega,
+		&dataset.SampleCount,
+		&dataset.ProbeCount,
+		&dataset.CreatedAt,
+		&dataset.UpdatedAt,
+		&genome.Id,
+		&genome.PublicId,
+		&genome.Name,
+		&technology.Id,
+		&technology.PublicId,
+		&technology.Name}
+}
 
-		query = sqlite.MakePermissionsSql(ExprTypesSQL, isAdmin, permissions, &namedArgs)
+// Lists datasets without their samples, which is much smaller than
+// Datasets for when users just need to pick a dataset
+func (gdb *GexDB) DatasetSummaries(genome string,
+	technology string,
+	permissions []string,
+	isAdmin bool) ([]*Dataset, error) {
+
+	namedArgs := []any{sql.Named("genome", web.FormatParam(genome)),
+		sql.Named("technology", web.FormatParam(technology))}
 
-		rows, err = gdb.db.Query(ExprTypesSQL, sql.Named("id", dataset.Id))
+	rows, err := gdb.queryWithPermissions(DatasetSummariesSQL, isAdmin, permissions, namedArgs...)
 
-		for rows.Next() {
-			var exprType db.Entity
+	if err != nil {
+		return nil, err
+	}
 
-			err := rows.Scan(&exprType.Id, &exprType.PublicId, &exprType.Name)
+	defer rows.Close()
 
-			if err != nil {
-				return nil, err
-			}
+	datasets := make([]*Dataset, 0, 10)
 
-			dataset.ExprTypes = append(dataset.ExprTypes, &exprType)
+	for rows.Next() {
+		var dataset Dataset
+		var genome db.Entity
+		var technology db.Entity
+
+		err := rows.Scan(datasetScanArgs(&dataset, &genome, &technology)...)
+
+		if err != nil {
+			return nil, err
 		}
+
+		dataset.Genome = &genome
+		dataset.Technology = &technology
+
+		datasets = append(datasets, &dataset)
+	}
+
+	err = gdb.addExprTypes(datasets, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return datasets, nil
 }
 
-// used for search results where only basic dataset info is needed
-func (gdb *GexDB) BasicDataset(datasetId string, permissions []string, isAdmin bool) (*db.Entity, error) {
+// Returns a page of samples, with their metadata, from a dataset. Pages
+// start at 1.
+func (gdb *GexDB) DatasetSamples(datasetId string,
+	page int,
+	n int,
+	isAdmin bool,
+	permissions []string) (*SamplesPage, error) {
+
+	page = max(1, page)
+	n = max(1, min(n, MaxSamplesPageSize))
+
+	ret := SamplesPage{Page: page, N: n}
+
+	err := gdb.queryRowWithPermissions(DatasetSampleCountSQL,
+		isAdmin,
+		permissions,
+		[]any{sql.Named("id", datasetId)},
+		&ret.Total)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := gdb.queryWithPermissions(DatasetSamplesSQL,
+		isAdmin,
+		permissions,
+		sql.Named("id", datasetId),
+		sql.Named("limit", n),
+		sql.Named("offset", (page-1)*n))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret.Samples = make([]*Sample, 0, n)
+
+	var currentSample *Sample
+
+	for rows.Next() {
+		var sample Sample
+		var metadataId sql.NullInt64
+		var metadataPublicId sql.NullString
+		var metadataName sql.NullString
+		var metadataValue sql.NullString
+		var metadataColor sql.NullString
+
+		err := rows.Scan(&sample.Id,
+			&sample.PublicId,
+			&sample.Name,
+			&metadataId,
+			&metadataPublicId,
+			&metadataName,
+			&metadataValue,
+			&metadataColor)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if currentSample == nil || currentSample.Id != sample.Id {
+			currentSample = &sample
+			currentSample.Metadata = make([]*NamedValue, 0, 20)
+			ret.Samples = append(ret.Samples, currentSample)
+		}
+
+		if !metadataId.Valid {
+			continue
+		}
+
+		var metadata NamedValue
+
+		metadata.Id = int(metadataId.Int64)
+		metadata.PublicId = metadataPublicId.String
+		metadata.Name = metadataName.String
+		metadata.Value = metadataValue.String
+		metadata.Color = metadataColor.String
+
+		currentSample.Metadata = append(currentSample.Metadata, &metadata)
+	}
+
+	return &ret, nil
+}
+
+// Add expr types
+func (gdb *GexDB) addExprTypes(datasets []*Dataset, isAdmin bool, permissions []string) error {
+	for _, dataset := range datasets {
+		err := gdb.addDatasetExprTypes(dataset, isAdmin, permissions)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (gdb *GexDB) addDatasetExprTypes(dataset *Dataset, isAdmin bool, permissions []string) error {
+	dataset.ExprTypes = make([]*db.Entity, 0, 5)
+
+	rows, err := gdb.queryWithPermissions(ExprTypesSQL, isAdmin, permissions, sql.Named("id", dataset.Id))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var exprType db.Entity
+
+		err := rows.Scan(&exprType.Id, &exprType.PublicId, &exprType.Name)
 
-	namedArgs := []any{sql.Named("id", datasetId)}
+		if err != nil {
+			return err
+		}
 
-	query := sqlite.MakePermissionsSql(BasicDatasetSQL, isAdmin, permissions, &namedArgs)
+		dataset.ExprTypes = append(dataset.ExprTypes, &exprType)
+	}
+
+	return nil
+}
+
+// used for search results where only basic dataset info is needed
+func (gdb *GexDB) BasicDataset(datasetId string, permissions []string, isAdmin bool) (*db.Entity, error) {
 
 	var ret db.Entity
 
-	err := gdb.db.QueryRow(query, namedArgs...).Scan(
+	err := gdb.queryRowWithPermissions(BasicDatasetSQL,
+		isAdmin,
+		permissions,
+		[]any{sql.Named("id", datasetId)},
 		&ret.Id,
 		&ret.PublicId,
 		&ret.Name)
@@ -691,7 +1316,6 @@ func (gdb *GexDB) Metadata() ([]*NamedValue, error) {
 			&m.Id,
 			&m.PublicId,
 			&m.Name,
-			&m.Value,
 			&m.Color)
 
 		if err != nil {
@@ -875,12 +1499,16 @@ func (gdb *GexDB) ExprType(id string) (*db.Entity, error) {
 // 	return ret, nil
 // }
 
-func (gdb *GexDB) GenomeTechnology(datasetId string) (*db.Entity, *db.Entity, error) {
+// Returns the genome and technology of a dataset the user can view
+func (gdb *GexDB) GenomeTechnology(datasetId string, isAdmin bool, permissions []string) (*db.Entity, *db.Entity, error) {
 
 	var genome db.Entity
 	var technology db.Entity
 
-	err := gdb.db.QueryRow(GenomeTechnologySQL, sql.Named("id", datasetId)).Scan(
+	err := gdb.queryRowWithPermissions(GenomeTechnologySQL,
+		isAdmin,
+		permissions,
+		[]any{sql.Named("id", datasetId)},
 		&genome.Id,
 		&genome.PublicId,
 		&genome.Name,
@@ -895,48 +1523,31 @@ func (gdb *GexDB) GenomeTechnology(datasetId string) (*db.Entity, *db.Entity, er
 	return &genome, &technology, nil
 }
 
-func (gdb *GexDB) FindProbes(genome, technology *db.Entity, genes []string) ([]*Probe, error) {
+// Finds the probes matching a list of probe or gene ids. Loci such as
+// chr8:127,700,000-129,000,000 and selectors such as biotype:lncRNA
+// are expanded into the genes they match (see expandIds). A LimitError
+// is returned if they expand to more genes, or match more probes, than
+// the limits allow. If limits is nil there is no limit, which should
+// only be used for lists from the catalog such as gene sets.
+func (gdb *GexDB) FindProbes(genome, technology *db.Entity, genes []string, limits *RequestLimits) ([]*Probe, error) {
 
-	// use a transaction to insert gene ids into a temp table
+	defer findProbesSeconds.ObserveSince(time.Now())
 
-	tx, err := gdb.db.BeginTx(context.Background(), &sql.TxOptions{
-		ReadOnly: false,
-	})
+	genes, err := gdb.expandIds(genome, genes, limits)
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer tx.Rollback()
-
-	_, err = tx.Exec(CreateIdTableSQL)
-
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = tx.Exec(`DELETE FROM ids`)
-	if err != nil {
-		return nil, err
-	}
-
-	stmt, err := tx.Prepare(InsertIdSQL)
-
-	if err != nil {
-		return nil, err
-	}
+	ret := make([]*Probe, 0, len(genes))
 
-	defer stmt.Close()
+	namedArgs := []any{sql.Named("genome", genome.Id), sql.Named("technology", technology.Id)}
 
-	for i, gene := range genes {
-		//log.Debug().Msgf("inserting gene id: %s with ord: %d", gene, i+1)
-		if _, err := stmt.Exec(sql.Named("id", web.FormatParam(gene)), sql.Named("ord", i+1)); err != nil {
-			return nil, err
-		}
-	}
+	query := MakeIdsSql(ProbesSQL, genes, &namedArgs)
 
-	if err := tx.Commit(); err != nil {
-		return nil, err
+	// nothing to search for
+	if query == "" {
+		return ret, nil
 	}
 
 	//
@@ -945,10 +1556,7 @@ func (gdb *GexDB) FindProbes(genome, technology *db.Entity, genes []string) ([]*
 	// order
 	//
 
-	ret := make([]*Probe, 0, len(genes))
-
-	rows, err := gdb.db.Query(ProbesSQL,
-		sql.Named("genome", genome.Id), sql.Named("technology", technology.Id))
+	rows, err := gdb.db.Query(query, namedArgs...)
 
 	if err != nil {
 		return nil, err
@@ -963,6 +1571,7 @@ func (gdb *GexDB) FindProbes(genome, technology *db.Entity, genes []string) ([]*
 
 		// init the gene
 		gene := GexGene{}
+		var groups string
 
 		err := rows.Scan(
 			&probe.Id,
@@ -977,12 +1586,20 @@ func (gdb *GexDB) FindProbes(genome, technology *db.Entity, genes []string) ([]*
 			&gene.Ensembl,
 			&gene.Refseq,
 			&gene.Ncbi,
+			&gene.Chr,
+			&gene.Start,
+			&gene.End,
+			&gene.Strand,
+			&gene.Biotype,
+			&groups,
 		)
 
 		if err != nil {
 			return nil, err
 		}
 
+		gene.Groups = splitGroups(groups)
+
 		if gene.Id != -1 {
 			probe.Gene = &gene
 		}
@@ -990,6 +1607,14 @@ func (gdb *GexDB) FindProbes(genome, technology *db.Entity, genes []string) ([]*
 		ret = append(ret, &probe)
 	}
 
+	if limits != nil {
+		err = CheckLimit("probes", len(ret), limits.Probes)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// for _, g := range ret {
 	// 	log.Debug().Msgf("probe %v", *g)
 	// }
@@ -1075,6 +1700,16 @@ func (gdb *GexDB) Expression(datasetId string,
 	probes []*Probe,
 	isAdmin bool,
 	permissions []string) (*SearchResults, error) {
+	return gdb.timedExpression(datasetId, exprType, probes, isAdmin, permissions, nil)
+}
+
+// Expression recording where its time went in timings
+func (gdb *GexDB) timedExpression(datasetId string,
+	exprType *db.Entity,
+	probes []*Probe,
+	isAdmin bool,
+	permissions []string,
+	timings *Timings) (*SearchResults, error) {
 
 	//exprType, err := gdb.ExprType(exprTypeId)
 
@@ -1090,45 +1725,32 @@ func (gdb *GexDB) Expression(datasetId string,
 		return nil, err
 	}
 
+	columns, err := gdb.sampleColumns(datasetId, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
+	}
+
 	ret := SearchResults{
 		Dataset:  dataset,
 		ExprType: exprType,
 		Probes:   make([]*ExpressionProbe, 0, len(probes))}
 
-	var url string
-	var offset int64
-	var length int
-
 	for _, probe := range probes {
-		namedArgs := []any{
-			sql.Named("dataset", datasetId),
-			sql.Named("probe", probe.Id),
-			sql.Named("type", exprType.Id)}
-
-		query := sqlite.MakePermissionsSql(ExprSQL, isAdmin, permissions, &namedArgs)
-
-		err := gdb.db.QueryRow(query, namedArgs...).Scan(
-			&url,
-			&offset,
-			&length)
+		values, err := gdb.probeValues(datasetId, exprType, probe, isAdmin, permissions, timings)
 
 		if err != nil {
 			return nil, err
 		}
 
-		path := filepath.Join(gdb.dir, url)
+		//log.Debug().Msgf("v %v  ", values)
 
-		// the offset is the start of a row block which consists
-		// of a 4 byte unsigned int of the probe id, which can be
-		// matched to the database and then the data
-		_, values, err := readGeneBlock(path, offset, length)
+		values, err = sliceSamples(values, columns)
 
 		if err != nil {
 			return nil, err
 		}
 
-		//log.Debug().Msgf("v %v  ", values)
-
 		feature := ExpressionProbe{Probe: probe, Values: values}
 
 		ret.Probes = append(ret.Probes, &feature)
@@ -1137,61 +1759,163 @@ func (gdb *GexDB) Expression(datasetId string,
 	return &ret, nil
 }
 
-// func (gdb *GexDB) FindSeqValues(dataset string,
-// 	exprTypeId string,
-// 	genes []string,
-// 	isAdmin bool,
-// 	permissions []string) (*SearchResults, error) {
+// Returned when the catalog gives a sample a column its files do not
+// have
+var ErrInvalidSampleColumn = errors.New("invalid sample column")
 
-// 	probes, err := gdb.FindProbes(genes)
+// The columns in a dataset's expression files of the samples the user
+// can see, in the order samples are listed, or nil if they can see
+// every sample and the files list them in that order
+func (gdb *GexDB) sampleColumns(datasetId string, isAdmin bool, permissions []string) ([]int, error) {
 
-// 	if err != nil {
-// 		return nil, err
-// 	}
+	rows, err := gdb.queryWithPermissions(SampleColumnsSQL, isAdmin, permissions, sql.Named("id", datasetId))
 
-// 	return gdb.Probes(dataset, exprTypeId, probes, isAdmin, permissions)
-// }
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
 
-// read a binary file containing float32 values with a given offset and count
-// and return the probe id and a float array of the sample values
-func readGeneBlock(path string, offset int64, l int) (uint32, []float32, error) {
-	// Open the file for reading
-	f, err := os.Open(path)
+	columns := make([]int, 0, DefaultNumSamples)
+	all := true
+	i := 0
 
-	if err != nil {
-		return 0, nil, err
+	for rows.Next() {
+		var column int
+		var visible bool
+
+		err := rows.Scan(&column, &visible)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if visible {
+			columns = append(columns, column)
+		}
+
+		if !visible || column != i {
+			all = false
+		}
+
+		i++
 	}
 
-	defer f.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if all {
+		return nil, nil
+	}
 
-	// Seek to the specified offset
-	_, err = f.Seek(offset, 0) // 0 means "from the beginning of the file"
+	return columns, nil
+}
 
-	if err != nil {
-		return 0, nil, err
+// keep only the values of the given sample columns. Nil columns
+// means keep everything. An error is returned if a column is not in
+// values since the catalog does not match its files. The values are
+// always a copy since they may be shared by the block cache.
+func sliceSamples(values []float32, columns []int) ([]float32, error) {
+	if columns == nil {
+		return slices.Clone(values), nil
 	}
 
-	var probeId uint32
+	ret := make([]float32, len(columns))
 
-	err = binary.Read(f, binary.LittleEndian, &probeId)
+	for i, c := range columns {
+		if c < 0 || c >= len(values) {
+			return nil, fmt.Errorf("%w: sample column %d of %d", ErrInvalidSampleColumn, c, len(values))
+		}
+
+		ret[i] = values[c]
+	}
+
+	return ret, nil
+}
+
+// read the values of a single probe in a dataset. The values are for
+// every sample so should be sliced to the samples the user can see.
+func (gdb *GexDB) probeValues(datasetId string,
+	exprType *db.Entity,
+	probe *Probe,
+	isAdmin bool,
+	permissions []string,
+	timings *Timings) ([]float32, error) {
+
+	var url string
+	var offset int64
+	var length int
+
+	namedArgs := []any{
+		sql.Named("dataset", datasetId),
+		sql.Named("probe", probe.Id),
+		sql.Named("type", exprType.Id)}
+
+	start := time.Now()
+
+	err := gdb.queryRowWithPermissions(ExprSQL,
+		isAdmin,
+		permissions,
+		namedArgs,
+		&url,
+		&offset,
+		&length)
+
+	timings.addSQL(expressionSQLSeconds.ObserveSince(start))
 
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
 
-	// Prepare a slice to hold the read values
-	data := make([]float32, l)
+	// the block is only cached after the permission check above so
+	// users cannot read values they are not allowed to see. Cached
+	// values are shared so must not be modified.
+	key := blockKey{url: url, probe: probe.Id, offset: offset}
 
-	// Read the data into the slice
-	err = binary.Read(f, binary.LittleEndian, &data)
+	if values, ok := gdb.blocks.Get(key); ok {
+		timings.addCachedBlock()
+		return values, nil
+	}
+
+	start = time.Now()
+
+	// the offset is the start of a row block which consists
+	// of a 4 byte unsigned int of the probe id, which can be
+	// matched to the database and then the data
+	openFiles.Inc()
+	_, values, err := gdb.store.ReadBlock(context.Background(), url, offset, length)
+	openFiles.Dec()
+
+	bytes := store.BlockSize(length)
+	timings.addRead(blockReadSeconds.ObserveSince(start), bytes)
 
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
 
-	return probeId, data, nil
+	blockReadBytes.Add(float64(bytes))
+
+	gdb.blocks.Add(key, values)
+
+	return values, nil
 }
 
+// func (gdb *GexDB) FindSeqValues(dataset string,
+// 	exprTypeId string,
+// 	genes []string,
+// 	isAdmin bool,
+// 	permissions []string) (*SearchResults, error) {
+
+// 	probes, err := gdb.FindProbes(genes)
+
+// 	if err != nil {
+// 		return nil, err
+// 	}
+
+// 	return gdb.Probes(dataset, exprTypeId, probes, isAdmin, permissions)
+// }
+
 // func read(f *os.File, offset int, length int) ([]byte, error) {
 // 	buf := make([]byte, length)
 // 	_, err := f.ReadAt(buf, int64(offset))
@@ -1218,8 +1942,6 @@ func readGeneBlock(path string, offset int64, l int) (uint32, []float32, error)
 
 func MakeInDatasetsSql(query string, datasets []string, namedArgs *[]any) string {
 
-	datasets = collections.TruncateSlice(datasets, MaxDatasets)
-
 	inPlaceholders := make([]string, len(datasets))
 
 	for i, dataset := range datasets {
@@ -1234,9 +1956,37 @@ func MakeInDatasetsSql(query string, datasets []string, namedArgs *[]any) string
 
 }
 
-func MakeInProbesSql(query string, probes []int, namedArgs *[]any) string {
+// Replaces <<IDS>> with a list of (id, ord) values of the lowercase
+// ids in the order given. Repeated ids are only searched for once, in
+// the position they first appear. Returns an empty query if there are
+// no ids.
+func MakeIdsSql(query string, ids []string, namedArgs *[]any) string {
 
-	probes = collections.TruncateSlice(probes, MaxProbes)
+	values := make([]string, 0, len(ids))
+	seen := make(map[string]struct{}, len(ids))
+
+	for _, id := range ids {
+		id = web.FormatParam(id)
+
+		if _, ok := seen[id]; ok || id == "" {
+			continue
+		}
+
+		seen[id] = struct{}{}
+
+		ph := fmt.Sprintf("id%d", len(values)+1)
+		values = append(values, fmt.Sprintf("(:%s, %d)", ph, len(values)+1))
+		*namedArgs = append(*namedArgs, sql.Named(ph, id))
+	}
+
+	if len(values) == 0 {
+		return ""
+	}
+
+	return strings.Replace(query, IdsPlaceholder, "VALUES "+strings.Join(values, ", "), 1)
+}
+
+func MakeInProbesSql(query string, probes []int, namedArgs *[]any) string {
 
 	inPlaceholders := make([]string, len(probes))
 
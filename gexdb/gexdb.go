@@ -1,35 +1,354 @@
 package gexdb
 
 import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-gex/store"
 	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-web"
 )
 
+// The name of the catalog the package level functions use
+const DefaultName = "default"
+
 var (
-	instance *gex.GexDB
-	once     sync.Once
+	// catalogs by name, e.g. public and private
+	instances = make(map[string]*gex.GexDB)
+	// how to open each catalog again when it is reloaded
+	openers = make(map[string]func() (*gex.GexDB, error))
+	lock    sync.RWMutex
+	// one reload at a time so each replaces the last
+	reloadLock sync.Mutex
+
+	ErrNotInitialized  = errors.New("gexdb has not been initialized")
+	ErrCatalogExists   = errors.New("catalog already exists")
+	ErrCatalogNotFound = errors.New("catalog not found")
+	ErrCannotReload    = errors.New("catalog cannot be reloaded")
 )
 
+// Opens the default catalog used by the other functions. Once one has
+// opened the others return it rather than opening another, but if
+// opening fails the error is returned and it can be tried again.
 func InitGexDB(path string) (*gex.GexDB, error) {
-	once.Do(func() {
-		instance = gex.NewGexDB(path)
+	return initInstance(func() (*gex.GexDB, error) {
+		return gex.NewGexDB(path)
+	})
+}
+
+// Initializes the catalog with its expression files in a store such
+// as an S3 bucket rather than next to the database
+func InitGexDBWithStore(path string, expressionStore store.ExpressionStore) (*gex.GexDB, error) {
+	return initInstance(func() (*gex.GexDB, error) {
+		return gex.NewGexDBWithStore(path, expressionStore)
+	})
+}
+
+// Initializes the catalog with options such as opening it read only
+func InitGexDBWithOptions(path string, options *gex.Options) (*gex.GexDB, error) {
+	return initInstance(func() (*gex.GexDB, error) {
+		return gex.OpenGexDB(path, options)
+	})
+}
+
+// Initializes the catalog from Postgres so it can be shared by several
+// instances of the service
+func InitPostgresGexDB(dsn string, expressionStore store.ExpressionStore) (*gex.GexDB, error) {
+	return initInstance(func() (*gex.GexDB, error) {
+		return gex.NewPostgresGexDB(dsn, expressionStore)
 	})
+}
+
+func initInstance(open func() (*gex.GexDB, error)) (*gex.GexDB, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if gdb, ok := instances[DefaultName]; ok {
+		return gdb, nil
+	}
+
+	gdb, err := open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	gdb.SetName(DefaultName)
+
+	instances[DefaultName] = gdb
+	openers[DefaultName] = open
+
+	return gdb, nil
+}
+
+// Opens a catalog and adds it under a name. Unlike Register the
+// catalog can be reloaded by opening it again.
+func Open(name string, open func() (*gex.GexDB, error)) (*gex.GexDB, error) {
+	gdb, err := open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = Register(name, gdb)
+
+	if err != nil {
+		gdb.Close()
+		return nil, err
+	}
+
+	lock.Lock()
+	openers[web.FormatParam(name)] = open
+	lock.Unlock()
+
+	return gdb, nil
+}
+
+// Adds an open catalog under a name, such as private or staging, so
+// routes can select it. Registering DefaultName sets the catalog the
+// package level functions use.
+func Register(name string, gdb *gex.GexDB) error {
+	name = web.FormatParam(name)
+
+	if name == "" {
+		return errors.New("catalog name is required")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := instances[name]; ok {
+		return fmt.Errorf("%w: %s", ErrCatalogExists, name)
+	}
+
+	gdb.SetName(name)
+
+	instances[name] = gdb
+
+	return nil
+}
+
+// Removes a catalog so it can no longer be selected, returning it so
+// it can be closed once any requests using it have finished
+func Remove(name string) (*gex.GexDB, error) {
+	name = web.FormatParam(name)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	gdb, ok := instances[name]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCatalogNotFound, name)
+	}
+
+	delete(instances, name)
+	delete(openers, name)
+
+	return gdb, nil
+}
+
+// Returns a catalog by name. An empty name is the default catalog.
+func Get(name string) (*gex.GexDB, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return get(name)
+}
+
+// Returns a catalog by name marked as in use so that if it is reloaded
+// it is not closed until Release is called on it
+func Acquire(name string) (*gex.GexDB, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	gdb, err := get(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// under the lock so a reload cannot start draining first
+	gdb.Acquire()
+
+	return gdb, nil
+}
+
+func get(name string) (*gex.GexDB, error) {
+	name = web.FormatParam(name)
+
+	if name == "" {
+		name = DefaultName
+	}
+
+	gdb, ok := instances[name]
+
+	if !ok {
+		if name == DefaultName {
+			return nil, ErrNotInitialized
+		}
+
+		return nil, fmt.Errorf("%w: %s", ErrCatalogNotFound, name)
+	}
+
+	return gdb, nil
+}
+
+// The names of the catalogs in alphabetical order
+func Names() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	names := make([]string, 0, len(instances))
+
+	for name := range instances {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// The catalogs by name, copied so they can be read without the lock
+func catalogs() map[string]*gex.GexDB {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return maps.Clone(instances)
+}
+
+// Returns the default catalog or ErrNotInitialized if it has not been
+// opened
+func GetInstance() (*gex.GexDB, error) {
+	return Get(DefaultName)
+}
+
+func Dir() (string, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return "", err
+	}
+
+	return gdb.Dir(), nil
+}
+
+func Limits() (*gex.Limits, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Limits(), nil
+}
+
+// Sets how much each request can ask for, e.g. to give admins
+// higher limits than other users
+func SetLimits(limits *gex.Limits) error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	gdb.SetLimits(limits)
+
+	return nil
+}
+
+// Sets the most memory in bytes that cached expression values can use
+func SetBlockCacheSize(maxSize int64) error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	gdb.SetBlockCacheSize(maxSize)
+
+	return nil
+}
+
+func BlockCacheStats() (*cache.Stats, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.BlockCacheStats(), nil
+}
+
+func CatalogCacheStats() (*cache.Stats, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.CatalogCacheStats(), nil
+}
+
+// Caches search results, e.g. in Redis so replicas can share them
+func SetResultsCache(results cache.Cache, ttl time.Duration) error {
+	gdb, err := GetInstance()
 
-	return instance, nil
+	if err != nil {
+		return err
+	}
+
+	gdb.SetResultsCache(results, ttl)
+
+	return nil
+}
+
+func InvalidateDataset(datasetId string) error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.InvalidateDataset(datasetId)
 }
 
-func GetInstance() *gex.GexDB {
-	return instance
+func Ping() error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.Ping()
 }
 
-func Dir() string {
-	return instance.Dir()
+// Checks the catalog and every expression file it refers to
+func Check() (*gex.CheckReport, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Check()
 }
 
 func Genomes() ([]*db.Entity, error) {
-	return instance.Genomes()
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Genomes()
 }
 
 // func Platforms(species string) ([]string, error) {
@@ -41,27 +360,163 @@ func Genomes() ([]*db.Entity, error) {
 // }
 
 func Datasets(genome string, technology string, isAdmin bool, permissions []string) ([]*gex.Dataset, error) {
-	return instance.Datasets(genome, technology, permissions, isAdmin)
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Datasets(genome, technology, permissions, isAdmin)
+}
+
+func DatasetSummaries(genome string, technology string, isAdmin bool, permissions []string) ([]*gex.Dataset, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.DatasetSummaries(genome, technology, permissions, isAdmin)
+}
+
+func DatasetSamples(datasetId string, page int, n int, isAdmin bool, permissions []string) (*gex.SamplesPage, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.DatasetSamples(datasetId, page, n, isAdmin, permissions)
+}
+
+func Dataset(datasetId string, isAdmin bool, permissions []string) (*gex.Dataset, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Dataset(datasetId, isAdmin, permissions)
+}
+
+func MetadataSchema(datasetId string, isAdmin bool, permissions []string) ([]*gex.MetadataField, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.MetadataSchema(datasetId, isAdmin, permissions)
 }
 
 func Technologies() ([]*db.Entity, error) {
-	return instance.Technologies()
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Technologies()
 }
 
 func Expression(datasetId string, exprTypeId *db.Entity, probes []*gex.Probe, isAdmin bool, permissions []string) (*gex.SearchResults, error) {
-	return instance.Expression(datasetId, exprTypeId, probes, isAdmin, permissions)
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Expression(datasetId, exprTypeId, probes, isAdmin, permissions)
+}
+
+func CachedExpression(genes []string, datasetId string, exprType *db.Entity, probes []*gex.Probe, isAdmin bool, permissions []string, timings *gex.Timings) (*gex.SearchResults, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.CachedExpression(genes, datasetId, exprType, probes, isAdmin, permissions, timings)
 }
 
 func ExprType(id string) (*db.Entity, error) {
-	return instance.ExprType(id)
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.ExprType(id)
+}
+
+func FindProbes(genome, technology *db.Entity, genes []string, limits *gex.RequestLimits) ([]*gex.Probe, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.FindProbes(genome, technology, genes, limits)
+}
+
+func GenomeTechnology(datasetId string, isAdmin bool, permissions []string) (*db.Entity, *db.Entity, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return gdb.GenomeTechnology(datasetId, isAdmin, permissions)
 }
 
-func FindProbes(genome, technology *db.Entity, genes []string) ([]*gex.Probe, error) {
-	return instance.FindProbes(genome, technology, genes)
+func GeneSets(genome string) ([]*gex.GeneSet, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.GeneSets(genome)
+}
+
+func SearchGeneSets(genome string, q string, n int) ([]*gex.GeneSet, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.SearchGeneSets(genome, q, n)
+}
+
+func GeneSet(genome *db.Entity, id string) (*gex.GeneSet, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.GeneSet(genome, id)
+}
+
+func ScoreGeneSet(datasetId string, exprType *db.Entity, geneSet *gex.GeneSet, probes []*gex.Probe, method string, isAdmin bool, permissions []string, limits *gex.RequestLimits) (*gex.ExpressionProbe, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.ScoreGeneSet(datasetId, exprType, geneSet, probes, method, isAdmin, permissions, limits)
 }
 
-func GenomeTechnology(datasetId string) (*db.Entity, *db.Entity, error) {
-	return instance.GenomeTechnology(datasetId)
+func GeneSetGenes(genome *db.Entity, ids []string) ([]string, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.GeneSetGenes(genome, ids)
 }
 
 // func FindSeqValues(datasetId string, exprTypeId string, genes []string, isAdmin bool, permissions []string) (*gex.SearchResults, error) {
@@ -79,3 +534,83 @@ func GenomeTechnology(datasetId string) (*db.Entity, *db.Entity, error) {
 // func Search(location *dna.Location, uuids []string) (*gex.SearchResults, error) {
 // 	return instance.Search(location, uuids)
 // }
+
+func Permissions() ([]*db.Entity, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.Permissions()
+}
+
+func CreatePermission(name string) (*db.Entity, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.CreatePermission(name)
+}
+
+func DatasetPermissions(datasetId string) ([]*db.Entity, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.DatasetPermissions(datasetId)
+}
+
+func GrantDatasetPermission(datasetId string, permission string) error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.GrantDatasetPermission(datasetId, permission)
+}
+
+func RevokeDatasetPermission(datasetId string, permission string) error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.RevokeDatasetPermission(datasetId, permission)
+}
+
+func SamplePermissions(sampleId string) ([]*db.Entity, error) {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.SamplePermissions(sampleId)
+}
+
+func GrantSamplePermission(sampleId string, permission string) error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.GrantSamplePermission(sampleId, permission)
+}
+
+func RevokeSamplePermission(sampleId string, permission string) error {
+	gdb, err := GetInstance()
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.RevokeSamplePermission(sampleId, permission)
+}
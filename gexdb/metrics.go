@@ -0,0 +1,54 @@
+package gexdb
+
+import (
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-gex/metrics"
+)
+
+// The label giving the catalog a value is for
+const catalogLabel = "catalog"
+
+// The caches and connections of each catalog are read when metrics are
+// scraped rather than counted as they change
+func init() {
+	cacheCounter("gex_block_cache_hits_total", "Expression blocks found in the block cache.", (*gex.GexDB).BlockCacheStats, hits)
+	cacheCounter("gex_block_cache_misses_total", "Expression blocks not in the block cache.", (*gex.GexDB).BlockCacheStats, misses)
+	cacheGauge("gex_block_cache_bytes", "Bytes of expression values in the block cache.", (*gex.GexDB).BlockCacheStats, size)
+	cacheCounter("gex_catalog_cache_hits_total", "Catalog lists found in the catalog cache.", (*gex.GexDB).CatalogCacheStats, hits)
+	cacheCounter("gex_catalog_cache_misses_total", "Catalog lists not in the catalog cache.", (*gex.GexDB).CatalogCacheStats, misses)
+
+	metrics.NewGaugeFuncVec("gex_db_open_connections", "Open connections to the catalog database.", func(observe func(float64, ...string)) {
+		for name, gdb := range catalogs() {
+			observe(float64(gdb.DBStats().OpenConnections), name)
+		}
+	}, catalogLabel)
+}
+
+func cacheCounter(name string, help string, stats func(*gex.GexDB) *cache.Stats, value func(*cache.Stats) int64) {
+	metrics.NewCounterFuncVec(name, help, collectCache(stats, value), catalogLabel)
+}
+
+func cacheGauge(name string, help string, stats func(*gex.GexDB) *cache.Stats, value func(*cache.Stats) int64) {
+	metrics.NewGaugeFuncVec(name, help, collectCache(stats, value), catalogLabel)
+}
+
+func collectCache(stats func(*gex.GexDB) *cache.Stats, value func(*cache.Stats) int64) func(observe func(float64, ...string)) {
+	return func(observe func(float64, ...string)) {
+		for name, gdb := range catalogs() {
+			observe(float64(value(stats(gdb))), name)
+		}
+	}
+}
+
+func hits(stats *cache.Stats) int64 {
+	return stats.Hits
+}
+
+func misses(stats *cache.Stats) int64 {
+	return stats.Misses
+}
+
+func size(stats *cache.Stats) int64 {
+	return stats.Size
+}
@@ -0,0 +1,58 @@
+package gexdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-gex/metrics"
+)
+
+// Every catalog's caches and connections are reported, not just the
+// default one
+func TestMetricsByCatalog(t *testing.T) {
+	for _, name := range []string{"metrics-public", "metrics-private"} {
+		err := Register(name, gextest.Open(t))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { Remove(name) })
+	}
+
+	// one catalog is used so their values differ
+	gdb, err := Get("metrics-public")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		_, err = gdb.Genomes()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b strings.Builder
+
+	err = metrics.Default.Write(&b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exposition := b.String()
+
+	for _, line := range []string{`gex_catalog_cache_misses_total{catalog="metrics-public"} 1`,
+		`gex_catalog_cache_hits_total{catalog="metrics-public"} 1`,
+		`gex_catalog_cache_hits_total{catalog="metrics-private"} 0`,
+		`gex_block_cache_bytes{catalog="metrics-private"} 0`,
+		`gex_db_open_connections{catalog="metrics-private"} `} {
+		if !strings.Contains(exposition, line) {
+			t.Errorf("%s is missing from\n%s", line, exposition)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package gexdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-sys/log"
+	"github.com/antonybholmes/go-web"
+)
+
+// Opens a catalog again, e.g. after a new release of gex.db and its
+// expression files, and switches to it. Requests already using the
+// old catalog carry on with it and it is closed once they finish, so
+// no request fails. If the new catalog cannot be opened the old one
+// is kept.
+func Reload(name string) error {
+	name = web.FormatParam(name)
+
+	if name == "" {
+		name = DefaultName
+	}
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	lock.RLock()
+	open, ok := openers[name]
+	lock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrCannotReload, name)
+	}
+
+	gdb, err := open()
+
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+
+	old, ok := instances[name]
+
+	if !ok {
+		// removed while the new catalog was opening
+		lock.Unlock()
+		gdb.Close()
+		return fmt.Errorf("%w: %s", ErrCatalogNotFound, name)
+	}
+
+	gdb.CopySettings(old)
+
+	instances[name] = gdb
+
+	lock.Unlock()
+
+	log.Info().Msgf("reloaded catalog %s", name)
+
+	go closeWhenDrained(name, old)
+
+	return nil
+}
+
+// Reloads every catalog that can be, returning the errors of those
+// that could not be
+func ReloadAll() error {
+	lock.RLock()
+
+	names := make([]string, 0, len(openers))
+
+	for name := range openers {
+		names = append(names, name)
+	}
+
+	lock.RUnlock()
+
+	var errs []error
+
+	for _, name := range names {
+		err := Reload(name)
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Reloads every catalog when the process receives SIGHUP, so a data
+// release can be deployed with e.g. kill -HUP. Call the returned
+// function to stop.
+func ReloadOnSignal() func() {
+	signals := make(chan os.Signal, 1)
+
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			err := ReloadAll()
+
+			if err != nil {
+				log.Error().Msgf("could not reload catalogs: %s", err)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+func closeWhenDrained(name string, gdb *gex.GexDB) {
+	ctx, cancel := context.WithTimeout(context.Background(), gex.DefaultDrainTimeout)
+	defer cancel()
+
+	err := gdb.Drain(ctx)
+
+	if err != nil {
+		log.Warn().Msgf("closing catalog %s before its requests finished: %s", name, err)
+	}
+
+	// the new catalog cannot read the old results but they take up
+	// space until they expire, so they are dropped once nothing can
+	// add to them. Those cached since the reload go too.
+	err = gdb.InvalidateResults()
+
+	if err != nil {
+		log.Warn().Msgf("could not drop cached results of %s: %s", name, err)
+	}
+
+	err = gdb.Close()
+
+	if err != nil {
+		log.Warn().Msgf("could not close catalog %s: %s", name, err)
+	}
+}
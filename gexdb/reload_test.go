@@ -0,0 +1,122 @@
+package gexdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+// A request still using a reloaded catalog can cache results from the
+// old data after the reload, which the new catalog must not read
+func TestReloadIgnoresOldResults(t *testing.T) {
+	const name = "reload-results"
+
+	results := cache.NewMemory(1 << 20)
+
+	old, err := Open(name, func() (*gex.GexDB, error) { return gextest.Open(t), nil })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { Remove(name) })
+
+	old.SetResultsCache(results, gex.DefaultResultsTTL)
+
+	// the request that outlives the reload
+	_, err = Acquire(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Reload(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := Get(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current == old || current.Generation() == old.Generation() {
+		t.Fatal("the catalog was not replaced")
+	}
+
+	key := search(t, old)
+	oldKey := key.String()
+
+	_, err = results.Get(context.Background(), oldKey)
+
+	if err != nil {
+		t.Fatalf("the old catalog's results were not cached: %s", err)
+	}
+
+	key.Generation = current.Generation()
+
+	_, err = results.Get(context.Background(), key.String())
+
+	if !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("got %v, want the new catalog to miss the old results", err)
+	}
+
+	old.Release()
+
+	// dropped once the old catalog has drained
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		_, err = results.Get(context.Background(), oldKey)
+
+		if errors.Is(err, cache.ErrMiss) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v, want the old results to be dropped", err)
+		}
+	}
+}
+
+// Searches the open dataset for MYC as an admin, returning the key the
+// results are cached under
+func search(t *testing.T, gdb *gex.GexDB) *gex.ResultsKey {
+	t.Helper()
+
+	exprType, err := gdb.ExprType("tpm")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gdb.CachedExpression([]string{"MYC"}, gextest.OpenDataset, exprType, probes, true, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &gex.ResultsKey{Catalog: gdb.Name(),
+		Generation: gdb.Generation(),
+		Dataset:    gextest.OpenDataset,
+		ExprType:   exprType.PublicId,
+		Genes:      []string{"MYC"},
+		IsAdmin:    true}
+}
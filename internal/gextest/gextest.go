@@ -0,0 +1,296 @@
+// Package gextest builds small catalogs for tests: a baseline sqlite
+// catalog as the first version of step2_make_gex_sql_bin.py made them,
+// the same catalog migrated to the current schema, and, when a DSN is
+// given, the same rows in Postgres, so that tests can check every
+// backend gives the same answers.
+package gextest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/store"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed testdata/*.sql
+var testdata embed.FS
+
+const (
+	// Postgres is only tested when this is set to the DSN of a
+	// database the tests can create schemas in
+	PostgresDSNEnv = "GEX_TEST_POSTGRES_DSN"
+
+	// public ids of the catalog rows
+	OpenDataset   = "dataset-open"
+	SecretDataset = "dataset-secret"
+
+	// rdf:view users can see O1 to O3 of Open. O4 also needs the
+	// consortium permission and Secret needs the secret permission.
+	ViewPermission       = "rdf:view"
+	SecretPermission     = "secret"
+	ConsortiumPermission = "consortium"
+
+	HiddenSample = "sample-o4"
+
+	TPM = "type-tpm"
+
+	GeneSet = "geneset-hallmark"
+)
+
+// The samples of each file in the order their values are stored
+var FileSamples = map[string]int{"open/tpm.bin": 4, "secret/tpm.bin": 3}
+
+// The probes of each file in the order their blocks are stored
+var FileProbes = []uint32{1, 2, 3, 4}
+
+// The value the expression files hold for a probe and the column of a
+// sample
+func Value(probe uint32, column int) float32 {
+	return float32(probe*10) + float32(column)
+}
+
+// Returns the statements of one of the testdata files
+func Statements(tb testing.TB, name string) []string {
+	tb.Helper()
+
+	data, err := testdata.ReadFile("testdata/" + name)
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return splitStatements(string(data))
+}
+
+func splitStatements(script string) []string {
+	lines := []string{}
+
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	statements := []string{}
+
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		statement = strings.TrimSpace(statement)
+
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements
+}
+
+// Writes the expression files of the catalog to dir
+func WriteExpressionFiles(tb testing.TB, dir string) {
+	tb.Helper()
+
+	for url, samples := range FileSamples {
+		path := filepath.Join(dir, filepath.FromSlash(url))
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		data := make([]byte, 0, gex.BinHeaderSize+int64(len(FileProbes))*store.BlockSize(samples))
+
+		for _, v := range []uint32{gex.BinMagic,
+			gex.BinVersion,
+			uint32(len(FileProbes)),
+			uint32(samples),
+			uint32(store.BlockSize(samples))} {
+			data = binary.LittleEndian.AppendUint32(data, v)
+		}
+
+		for _, probe := range FileProbes {
+			data = binary.LittleEndian.AppendUint32(data, probe)
+
+			for column := range samples {
+				data = binary.LittleEndian.AppendUint32(data, math.Float32bits(Value(probe, column)))
+			}
+		}
+
+		err = os.WriteFile(path, data, 0644)
+
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// Creates a sqlite catalog with the baseline schema and its expression
+// files in a temporary directory, returning the path of the catalog
+func NewBaseline(tb testing.TB) string {
+	tb.Helper()
+
+	dir := tb.TempDir()
+	path := filepath.Join(dir, "gex.db")
+
+	exec(tb, "sqlite3", path, Statements(tb, "baseline.sql"), Statements(tb, "catalog.sql"))
+
+	WriteExpressionFiles(tb, dir)
+
+	return path
+}
+
+// Creates a sqlite catalog at the current schema by migrating the
+// baseline catalog and adding rows for what was added since. The
+// catalog is closed when the test ends.
+func Open(tb testing.TB) *gex.GexDB {
+	tb.Helper()
+
+	path := NewBaseline(tb)
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	gdb.Close()
+
+	exec(tb, "sqlite3", path, Statements(tb, "annotations.sql"))
+
+	gdb, err = gex.OpenGexDB(path, nil)
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { gdb.Close() })
+
+	return gdb
+}
+
+// Creates the catalog in a new schema of the Postgres database given
+// by PostgresDSNEnv, skipping the test if it is not set. The schema is
+// dropped when the test ends.
+func OpenPostgres(tb testing.TB) *gex.GexDB {
+	tb.Helper()
+
+	dsn := os.Getenv(PostgresDSNEnv)
+
+	if dsn == "" {
+		tb.Skipf("%s is not set", PostgresDSNEnv)
+	}
+
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	schema := "gextest_" + hex.EncodeToString(suffix)
+
+	exec(tb, "pgx", dsn, []string{"CREATE SCHEMA " + schema})
+
+	tb.Cleanup(func() {
+		exec(tb, "pgx", dsn, []string{"DROP SCHEMA " + schema + " CASCADE"})
+	})
+
+	dsn, err := withSearchPath(dsn, schema)
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	_, file, _, _ := runtime.Caller(0)
+
+	script, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "scripts", "gex_postgres.sql"))
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	exec(tb, "pgx", dsn,
+		splitStatements(string(script)),
+		Statements(tb, "catalog.sql"),
+		[]string{"SELECT setval(pg_get_serial_sequence('permissions', 'id'), (SELECT MAX(id) FROM permissions))",
+			"INSERT INTO catalog_version (id, version) VALUES (1, 1)",
+			"INSERT INTO schema_version (id, version) VALUES (1, " + strconv.Itoa(gex.SchemaVersion) + ")"},
+		Statements(tb, "annotations.sql"))
+
+	dir := tb.TempDir()
+
+	WriteExpressionFiles(tb, dir)
+
+	gdb, err := gex.OpenPostgresGexDB(dsn, &gex.Options{Store: store.NewLocalStore(dir)})
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { gdb.Close() })
+
+	return gdb
+}
+
+// Runs a test against the catalog in each backend
+func ForEachBackend(t *testing.T, test func(t *testing.T, gdb *gex.GexDB)) {
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, Open(t))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		test(t, OpenPostgres(t))
+	})
+}
+
+func exec(tb testing.TB, driver string, dsn string, scripts ...[]string) {
+	tb.Helper()
+
+	conn, err := sql.Open(driver, dsn)
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	for _, statements := range scripts {
+		for _, statement := range statements {
+			_, err := conn.Exec(statement)
+
+			if err != nil {
+				tb.Fatalf("%s: %s", statement, err)
+			}
+		}
+	}
+}
+
+// Adds the schema to the search path of a url or key/value DSN
+func withSearchPath(dsn string, schema string) (string, error) {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema, nil
+	}
+
+	u, err := url.Parse(dsn)
+
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
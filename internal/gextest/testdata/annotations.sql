@@ -0,0 +1,46 @@
+-- Rows for what later versions of the schema added, loaded once a
+-- catalog is at the current version. The metadata schema made by
+-- migrating is replaced so every backend has the same one.
+
+UPDATE datasets SET pubmed = '12345678', geo = 'GSE1000' WHERE id = 1;
+UPDATE datasets SET ega = 'EGAS0001' WHERE id = 2;
+
+UPDATE datasets SET
+    sample_count = (SELECT COUNT(s.id) FROM samples s WHERE s.dataset_id = datasets.id),
+    probe_count = (SELECT COUNT(DISTINCT e.probe_id) FROM expression e WHERE e.dataset_id = datasets.id),
+    created_at = '2026-01-01 00:00:00',
+    updated_at = '2026-01-01 00:00:00';
+
+INSERT INTO gene_sets (id, public_id, genome_id, collection, name, description, url) VALUES (1, 'geneset-hallmark', 1, 'H', 'HALLMARK_LYMPHOMA', 'Lymphoma genes', '');
+INSERT INTO gene_sets (id, public_id, genome_id, collection, name, description, url) VALUES (2, 'geneset-myc', 1, 'C2', 'MYC_TARGETS', '', 'https://example.com/MYC_TARGETS');
+
+INSERT INTO gene_set_members (gene_set_id, ord, symbol, gene_id) VALUES (1, 1, 'BCL6', 1);
+INSERT INTO gene_set_members (gene_set_id, ord, symbol, gene_id) VALUES (1, 2, 'MYC', 2);
+INSERT INTO gene_set_members (gene_set_id, ord, symbol, gene_id) VALUES (1, 3, 'TP53', 3);
+INSERT INTO gene_set_members (gene_set_id, ord, symbol, gene_id) VALUES (1, 4, 'NOTAGENE', NULL);
+INSERT INTO gene_set_members (gene_set_id, ord, symbol, gene_id) VALUES (2, 1, 'MYC', 2);
+
+DELETE FROM metadata_categories;
+DELETE FROM dataset_metadata;
+
+INSERT INTO dataset_metadata (dataset_id, metadata_id, type, units, ord) VALUES (1, 1, 'categorical', '', 1);
+INSERT INTO dataset_metadata (dataset_id, metadata_id, type, units, ord) VALUES (1, 2, 'categorical', '', 2);
+INSERT INTO dataset_metadata (dataset_id, metadata_id, type, units, ord) VALUES (1, 3, 'numeric', 'years', 3);
+INSERT INTO dataset_metadata (dataset_id, metadata_id, type, units, ord) VALUES (2, 1, 'categorical', '', 1);
+INSERT INTO dataset_metadata (dataset_id, metadata_id, type, units, ord) VALUES (2, 2, 'categorical', '', 2);
+
+INSERT INTO metadata_categories (id, public_id, dataset_id, metadata_id, name, color, ord) VALUES (1, 'category-open-abc', 1, 2, 'ABC', '#ff0000', 1);
+INSERT INTO metadata_categories (id, public_id, dataset_id, metadata_id, name, color, ord) VALUES (2, 'category-open-gcb', 1, 2, 'GCB', '#0000ff', 2);
+INSERT INTO metadata_categories (id, public_id, dataset_id, metadata_id, name, color, ord) VALUES (3, 'category-secret-abc', 2, 2, 'ABC', '#ff0000', 1);
+INSERT INTO metadata_categories (id, public_id, dataset_id, metadata_id, name, color, ord) VALUES (4, 'category-secret-gcb', 2, 2, 'GCB', '#0000ff', 2);
+
+-- the secret dataset's files list its samples in reverse
+UPDATE samples SET file_column = id - 1 WHERE dataset_id = 1;
+UPDATE samples SET file_column = 7 - id WHERE dataset_id = 2;
+
+-- O4 is only shared with the consortium
+INSERT INTO sample_permissions (sample_id, permission_id) VALUES (4, 3);
+
+UPDATE genes SET chr = 'chr3', start = 187721377, "end" = 187745725, strand = '-', biotype = 'protein_coding', gene_group = 'BTB domain containing' WHERE id = 1;
+UPDATE genes SET chr = 'chr8', start = 127735434, "end" = 127742951, strand = '+', biotype = 'protein_coding', gene_group = 'Basic helix-loop-helix proteins' WHERE id = 2;
+UPDATE genes SET chr = 'chr17', start = 7661779, "end" = 7687538, strand = '-', biotype = 'protein_coding' WHERE id = 3;
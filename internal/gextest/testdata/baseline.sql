@@ -0,0 +1,177 @@
+-- The schema of catalogs built by the first version of
+-- step2_make_gex_sql_bin.py, which gex-migrate must be able to upgrade
+
+CREATE TABLE genomes (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    scientific_name TEXT NOT NULL,
+    UNIQUE(name, scientific_name));
+
+CREATE INDEX idx_genomes_name ON genomes (LOWER(name));
+
+CREATE TABLE sources (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    genome_id INTEGER NOT NULL,
+    name TEXT NOT NULL UNIQUE,
+    FOREIGN KEY(genome_id) REFERENCES genomes(id));
+
+CREATE INDEX idx_sources_name ON sources (LOWER(name));
+
+CREATE TABLE genes (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    source_id INTEGER NOT NULL,
+    gene_id TEXT NOT NULL,
+    ensembl TEXT NOT NULL DEFAULT '',
+    refseq TEXT NOT NULL DEFAULT '',
+    ncbi INTEGER NOT NULL DEFAULT 0,
+    symbol TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY(source_id) REFERENCES sources(id));
+
+CREATE INDEX idx_genes_gene_id ON genes (LOWER(gene_id));
+CREATE INDEX idx_genes_ensembl ON genes (LOWER(ensembl));
+CREATE INDEX idx_genes_refseq ON genes (LOWER(refseq));
+CREATE INDEX idx_genes_symbol ON genes (LOWER(symbol));
+CREATE INDEX idx_genes_source_id ON genes(source_id);
+
+CREATE TABLE alt_gene_names (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    source_id INTEGER NOT NULL,
+    gene_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    FOREIGN KEY(source_id) REFERENCES sources(id),
+    FOREIGN KEY(gene_id) REFERENCES genes(id));
+
+CREATE INDEX idx_alt_gene_names_name ON alt_gene_names (LOWER(name));
+CREATE INDEX idx_alt_gene_names_source_id ON alt_gene_names(source_id);
+
+CREATE TABLE technologies (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '');
+
+CREATE INDEX idx_technologies_name ON technologies (LOWER(name));
+
+CREATE TABLE probes (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    genome_id INTEGER NOT NULL,
+    technology_id INTEGER NOT NULL,
+    gene_id INTEGER,
+    name TEXT NOT NULL,
+    symbol TEXT NOT NULL,
+    UNIQUE(genome_id, name, symbol),
+    FOREIGN KEY(genome_id) REFERENCES genomes(id),
+    FOREIGN KEY(technology_id) REFERENCES technologies(id),
+    FOREIGN KEY(gene_id) REFERENCES genes(id));
+
+CREATE INDEX idx_probes_name ON probes (LOWER(name));
+CREATE INDEX idx_probes_symbol ON probes (LOWER(symbol));
+CREATE INDEX idx_probes_genome_id ON probes(genome_id);
+CREATE INDEX idx_probes_technology_id ON probes(technology_id);
+CREATE INDEX idx_probes_gene_id ON probes(gene_id);
+
+CREATE TABLE datasets (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    genome_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    technology_id INTEGER NOT NULL,
+    platform TEXT NOT NULL,
+    institution TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY(genome_id) REFERENCES genomes(id),
+    FOREIGN KEY(technology_id) REFERENCES technologies(id));
+
+CREATE INDEX idx_datasets_genome_id ON datasets(genome_id);
+CREATE INDEX idx_datasets_technology_id ON datasets(technology_id);
+
+CREATE TABLE permissions (
+    id INTEGER PRIMARY KEY ASC,
+    public_id TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL);
+
+CREATE TABLE dataset_permissions (
+    dataset_id INTEGER,
+    permission_id INTEGER,
+    PRIMARY KEY(dataset_id, permission_id),
+    FOREIGN KEY (dataset_id) REFERENCES datasets(id),
+    FOREIGN KEY (permission_id) REFERENCES permissions(id));
+
+CREATE INDEX idx_dataset_permissions_dataset_id ON dataset_permissions(dataset_id);
+CREATE INDEX idx_dataset_permissions_permission_id ON dataset_permissions(permission_id);
+
+CREATE TABLE samples (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    dataset_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY(dataset_id) REFERENCES datasets(id));
+
+CREATE INDEX idx_samples_dataset_id ON samples(dataset_id);
+CREATE INDEX idx_samples_name ON samples(LOWER(name));
+
+CREATE TABLE metadata (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL UNIQUE,
+    color TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '');
+
+CREATE TABLE sample_metadata (
+    sample_id INTEGER NOT NULL,
+    metadata_id INTEGER NOT NULL,
+    value TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY(sample_id, metadata_id),
+    FOREIGN KEY(sample_id) REFERENCES samples(id),
+    FOREIGN KEY(metadata_id) REFERENCES metadata(id));
+
+CREATE INDEX idx_sample_metadata_sample_id ON sample_metadata(sample_id);
+CREATE INDEX idx_sample_metadata_metadata_id ON sample_metadata(metadata_id);
+
+CREATE TABLE expression_types (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL UNIQUE);
+
+CREATE INDEX idx_expression_types_name ON expression_types (LOWER(name));
+
+CREATE TABLE files (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    url TEXT NOT NULL UNIQUE);
+
+CREATE TABLE data_types (
+    id INTEGER PRIMARY KEY,
+    public_id TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL UNIQUE);
+
+CREATE INDEX idx_data_types_name ON data_types (LOWER(name));
+
+CREATE TABLE expression (
+    id INTEGER PRIMARY KEY,
+    dataset_id INTEGER NOT NULL,
+    probe_id TEXT NOT NULL,
+    expression_type_id INTEGER NOT NULL,
+    data_type_id INTEGER NOT NULL DEFAULT 1,
+    offset INTEGER NOT NULL,
+    length INTEGER NOT NULL,
+    file_id INTEGER NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    FOREIGN KEY(dataset_id) REFERENCES datasets(id),
+    FOREIGN KEY(expression_type_id) REFERENCES expression_types(id),
+    FOREIGN KEY(probe_id) REFERENCES probes(id),
+    FOREIGN KEY(data_type_id) REFERENCES data_types(id),
+    FOREIGN KEY(file_id) REFERENCES files(id));
+
+CREATE INDEX idx_expression_dataset_id ON expression(dataset_id);
+CREATE INDEX idx_expression_expression_type_id ON expression(expression_type_id);
+CREATE INDEX idx_expression_probe_id ON expression(probe_id);
+CREATE INDEX idx_expression_data_type_id ON expression(data_type_id);
+CREATE INDEX idx_expression_file_id ON expression(file_id);
@@ -0,0 +1,81 @@
+-- Rows for the tables every catalog has. Two datasets of a human
+-- RNA-seq platform: Open, which rdf:view users can see, and Secret,
+-- which needs the secret permission. The blocks of the expression
+-- files, which gextest writes, hold probe * 10 + column for each
+-- sample.
+
+INSERT INTO genomes (id, public_id, name, scientific_name) VALUES (1, 'genome-human', 'Human', 'Homo sapiens');
+INSERT INTO genomes (id, public_id, name, scientific_name) VALUES (2, 'genome-mouse', 'Mouse', 'Mus musculus');
+
+INSERT INTO sources (id, public_id, genome_id, name) VALUES (1, 'source-hgnc', 1, 'HGNC');
+
+INSERT INTO genes (id, public_id, source_id, gene_id, ensembl, refseq, ncbi, symbol) VALUES (1, 'gene-bcl6', 1, 'HGNC:1001', 'ENSG00000113916', 'NM_001706', 604, 'BCL6');
+INSERT INTO genes (id, public_id, source_id, gene_id, ensembl, refseq, ncbi, symbol) VALUES (2, 'gene-myc', 1, 'HGNC:7553', 'ENSG00000136997', 'NM_002467', 4609, 'MYC');
+INSERT INTO genes (id, public_id, source_id, gene_id, ensembl, refseq, ncbi, symbol) VALUES (3, 'gene-tp53', 1, 'HGNC:11998', 'ENSG00000141510', 'NM_000546', 7157, 'TP53');
+
+INSERT INTO alt_gene_names (id, public_id, source_id, gene_id, name) VALUES (1, 'alt-bcl5', 1, 1, 'BCL5');
+
+INSERT INTO technologies (id, public_id, name, description) VALUES (1, 'technology-rnaseq', 'RNA-seq', 'RNA sequencing');
+INSERT INTO technologies (id, public_id, name, description) VALUES (2, 'technology-microarray', 'Microarray', 'Microarray sequencing');
+
+INSERT INTO probes (id, public_id, genome_id, technology_id, gene_id, name, symbol) VALUES (1, 'probe-bcl6', 1, 1, 1, 'BCL6', 'BCL6');
+INSERT INTO probes (id, public_id, genome_id, technology_id, gene_id, name, symbol) VALUES (2, 'probe-myc', 1, 1, 2, 'MYC', 'MYC');
+INSERT INTO probes (id, public_id, genome_id, technology_id, gene_id, name, symbol) VALUES (3, 'probe-tp53', 1, 1, 3, 'TP53', 'TP53');
+INSERT INTO probes (id, public_id, genome_id, technology_id, gene_id, name, symbol) VALUES (4, 'probe-orphan', 1, 1, NULL, 'ORPHAN', 'ORPHAN');
+
+INSERT INTO datasets (id, public_id, genome_id, name, technology_id, platform, institution, description) VALUES (1, 'dataset-open', 1, 'Open', 1, 'Illumina', 'RDF', 'Samples anyone can see');
+INSERT INTO datasets (id, public_id, genome_id, name, technology_id, platform, institution, description) VALUES (2, 'dataset-secret', 1, 'Secret', 1, 'Illumina', 'RDF', 'Samples few can see');
+
+INSERT INTO permissions (id, public_id, name) VALUES (1, 'permission-view', 'rdf:view');
+INSERT INTO permissions (id, public_id, name) VALUES (2, 'permission-secret', 'secret');
+INSERT INTO permissions (id, public_id, name) VALUES (3, 'permission-consortium', 'consortium');
+
+INSERT INTO dataset_permissions (dataset_id, permission_id) VALUES (1, 1);
+INSERT INTO dataset_permissions (dataset_id, permission_id) VALUES (2, 2);
+
+INSERT INTO samples (id, public_id, dataset_id, name) VALUES (1, 'sample-o1', 1, 'O1');
+INSERT INTO samples (id, public_id, dataset_id, name) VALUES (2, 'sample-o2', 1, 'O2');
+INSERT INTO samples (id, public_id, dataset_id, name) VALUES (3, 'sample-o3', 1, 'O3');
+INSERT INTO samples (id, public_id, dataset_id, name) VALUES (4, 'sample-o4', 1, 'O4');
+INSERT INTO samples (id, public_id, dataset_id, name) VALUES (5, 'sample-s1', 2, 'S1');
+INSERT INTO samples (id, public_id, dataset_id, name) VALUES (6, 'sample-s2', 2, 'S2');
+INSERT INTO samples (id, public_id, dataset_id, name) VALUES (7, 'sample-s3', 2, 'S3');
+
+INSERT INTO metadata (id, public_id, name, color) VALUES (1, 'metadata-sample', 'Sample', '');
+INSERT INTO metadata (id, public_id, name, color) VALUES (2, 'metadata-coo', 'COO', '');
+INSERT INTO metadata (id, public_id, name, color) VALUES (3, 'metadata-age', 'Age', '');
+
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (1, 1, 'O1');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (1, 2, 'ABC');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (1, 3, '50');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (2, 1, 'O2');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (2, 2, 'GCB');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (2, 3, '60');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (3, 1, 'O3');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (3, 2, 'ABC');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (3, 3, '70');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (4, 1, 'O4');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (4, 2, 'GCB');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (4, 3, '80');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (5, 1, 'S1');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (5, 2, 'ABC');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (6, 1, 'S2');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (6, 2, 'ABC');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (7, 1, 'S3');
+INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (7, 2, 'GCB');
+
+INSERT INTO expression_types (id, public_id, name) VALUES (1, 'type-tpm', 'TPM');
+
+INSERT INTO files (id, public_id, url) VALUES (1, 'file-open', 'open/tpm.bin');
+INSERT INTO files (id, public_id, url) VALUES (2, 'file-secret', 'secret/tpm.bin');
+
+INSERT INTO data_types (id, public_id, name) VALUES (1, 'type-float32', 'float32');
+
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (1, 1, 1, 1, 1, 20, 4, 1);
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (2, 1, 2, 1, 1, 40, 4, 1);
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (3, 1, 3, 1, 1, 60, 4, 1);
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (4, 1, 4, 1, 1, 80, 4, 1);
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (5, 2, 1, 1, 1, 20, 3, 2);
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (6, 2, 2, 1, 1, 36, 3, 2);
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (7, 2, 3, 1, 1, 52, 3, 2);
+INSERT INTO expression (id, dataset_id, probe_id, expression_type_id, data_type_id, "offset", length, file_id) VALUES (8, 2, 4, 1, 1, 68, 3, 2);
@@ -0,0 +1,66 @@
+package gex_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-sys/db"
+)
+
+// Writing to a catalog keeps its journal mode unless the options ask
+// for another, since a mode such as WAL stays with the file
+func TestJournalMode(t *testing.T) {
+	for _, test := range []struct {
+		mode string
+		want string
+	}{{want: "delete"}, {mode: "wal", want: "wal"}} {
+		path := gextest.NewBaseline(t)
+
+		gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true, JournalMode: test.mode})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = gdb.CreatePermission("reviewers")
+		check(t, "CreatePermission", err)
+
+		gdb.Close()
+
+		want(t, test.mode+" journal mode", journalMode(t, path), test.want)
+	}
+}
+
+func TestInvalidJournalMode(t *testing.T) {
+	_, err := gex.OpenGexDB(gextest.NewBaseline(t), &gex.Options{JournalMode: "fast"})
+
+	if !errors.Is(err, gex.ErrInvalidJournalMode) {
+		t.Errorf("got %v, want %v", err, gex.ErrInvalidJournalMode)
+	}
+}
+
+// Returns the journal mode of a sqlite file without changing it
+func journalMode(t *testing.T, path string) string {
+	t.Helper()
+
+	conn, err := sql.Open(db.Sqlite3DB, path+"?mode=ro")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	var mode string
+
+	err = conn.QueryRow("PRAGMA journal_mode").Scan(&mode)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mode
+}
@@ -0,0 +1,99 @@
+package gex
+
+import (
+	"errors"
+	"fmt"
+)
+
+type (
+	// The most a single request can ask for
+	RequestLimits struct {
+		// datasets searched at once
+		Datasets int `json:"datasets"`
+		// ids, loci and selectors listed in a search
+		Genes int `json:"genes"`
+		// gene sets to expand or score
+		GeneSets int `json:"geneSets"`
+		// genes that loci and selectors such as group:... can
+		// expand to in one search
+		ExpandedGenes int `json:"expandedGenes"`
+		// probes found in one search, which is the number of rows
+		// returned for each dataset
+		Probes int `json:"probes"`
+		// expression values ranked to score a gene set with ssGSEA,
+		// which is every probe of a dataset times its visible samples
+		ScoredValues int `json:"scoredValues"`
+		// probes of the genes of a gene set that is scored, since a
+		// z-score reads the values of each
+		ScoredProbes int `json:"scoredProbes"`
+	}
+
+	// Limits for each role. Admins usually get more.
+	Limits struct {
+		User  RequestLimits `json:"user"`
+		Admin RequestLimits `json:"admin"`
+	}
+
+	// Returned when a request asks for more than it is allowed so the
+	// user can be told what the limit is
+	LimitError struct {
+		Name  string
+		Limit int
+	}
+)
+
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+func DefaultLimits() *Limits {
+	return &Limits{
+		User: RequestLimits{Datasets: 10,
+			Genes:         200,
+			GeneSets:      10,
+			ExpandedGenes: 500,
+			Probes:        500,
+			ScoredValues:  10000000,
+			ScoredProbes:  2000},
+		Admin: RequestLimits{Datasets: 50,
+			Genes:         1000,
+			GeneSets:      50,
+			ExpandedGenes: 5000,
+			Probes:        5000,
+			ScoredValues:  100000000,
+			ScoredProbes:  10000},
+	}
+}
+
+// The limits of a request made by an admin or other user
+func (limits *Limits) For(isAdmin bool) *RequestLimits {
+	if isAdmin {
+		return &limits.Admin
+	}
+
+	return &limits.User
+}
+
+// The limits of finding the probes of a gene set to score, which can
+// have more probes than a search but no more than ScoredProbes
+func (limits *RequestLimits) ForScoring() *RequestLimits {
+	ret := *limits
+	ret.Probes = limits.ScoredProbes
+
+	return &ret
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("too many %s, the limit is %d", e.Name, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Returns a LimitError if n is more than limit
+func CheckLimit(name string, n int, limit int) error {
+	if n > limit {
+		return &LimitError{Name: name, Limit: limit}
+	}
+
+	return nil
+}
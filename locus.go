@@ -0,0 +1,426 @@
+package gex
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+type (
+	// A region of a chromosome using 1-based inclusive coordinates,
+	// e.g. chr8:127,700,000-129,000,000
+	Locus struct {
+		Chr   string `json:"chr"`
+		Start int    `json:"start"`
+		End   int    `json:"end"`
+	}
+
+	// The location of a gene from a GTF or BED file. Ids are the names
+	// the file gives the gene, such as its Ensembl id and symbol, in
+	// the order they should be matched.
+	GeneLocation struct {
+		Ids    []string
+		Strand string
+		// only GTF files have biotypes
+		Biotype string
+		Locus
+	}
+
+	LocationReport struct {
+		Source    string `json:"source"`
+		DryRun    bool   `json:"dryRun"`
+		Matched   int    `json:"matched"`
+		Unmatched int    `json:"unmatched"`
+	}
+)
+
+const (
+	// Genes overlapping a locus ordered by position
+	LocusGenesSQL = `SELECT
+		g.gene_id
+		FROM genes g
+		JOIN sources s ON s.id = g.source_id
+		WHERE
+			s.genome_id = :genome
+			AND g.chr = :chr
+			AND g.start <= :end
+			AND g."end" >= :start
+		ORDER BY g.start, g."end", g.symbol
+		LIMIT :limit`
+
+	LocationGenesSQL = `SELECT
+		g.id,
+		g.gene_id,
+		g.ensembl,
+		g.symbol
+		FROM genes g
+		WHERE g.source_id = :source`
+
+	// keep the current biotype if the file does not have one
+	UpdateGeneLocationSQL = `UPDATE genes
+		SET chr = :chr,
+			start = :start,
+			"end" = :end,
+			strand = :strand,
+			biotype = COALESCE(NULLIF(:biotype, ''), biotype)
+		WHERE id = :id`
+)
+
+var (
+	ErrInvalidLocus = errors.New("invalid locus")
+
+	// loci must have a chr prefix so they cannot be confused with
+	// probe names
+	locusRegex = regexp.MustCompile(`(?i)^chr([0-9a-z_]+):([0-9,]+)-([0-9,]+)$`)
+)
+
+// Parses a locus such as chr8:127,700,000-129,000,000
+func ParseLocus(s string) (*Locus, error) {
+	matches := locusRegex.FindStringSubmatch(strings.TrimSpace(s))
+
+	if matches == nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLocus, s)
+	}
+
+	start, err := strconv.Atoi(strings.ReplaceAll(matches[2], ",", ""))
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLocus, s)
+	}
+
+	end, err := strconv.Atoi(strings.ReplaceAll(matches[3], ",", ""))
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLocus, s)
+	}
+
+	if start < 1 || end < start {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLocus, s)
+	}
+
+	return &Locus{Chr: FormatChr(matches[1]), Start: start, End: end}, nil
+}
+
+func IsLocus(s string) bool {
+	return locusRegex.MatchString(strings.TrimSpace(s))
+}
+
+func (locus *Locus) String() string {
+	return fmt.Sprintf("%s:%d-%d", locus.Chr, locus.Start, locus.End)
+}
+
+// Formats chromosome names the UCSC way, e.g. 8, Chr8 and chr8 all
+// become chr8 and MT becomes chrM, so files from Ensembl and GENCODE
+// can be used interchangeably
+func FormatChr(chr string) string {
+	chr = strings.TrimSpace(chr)
+
+	if len(chr) > 3 && strings.EqualFold(chr[:3], "chr") {
+		chr = chr[3:]
+	}
+
+	chr = strings.ToUpper(chr)
+
+	if chr == "MT" {
+		chr = "M"
+	}
+
+	return "chr" + chr
+}
+
+// The ids of the genes overlapping a locus ordered by position. A
+// LimitError is returned if there are more than limit genes.
+func (gdb *GexDB) LocusGenes(genome *db.Entity, locus *Locus, limit int) ([]string, error) {
+	return gdb.limitedGeneIds(LocusGenesSQL, limit, locusArgs(genome, locus)...)
+}
+
+func locusArgs(genome *db.Entity, locus *Locus) []any {
+	return []any{sql.Named("genome", genome.Id),
+		sql.Named("chr", locus.Chr),
+		sql.Named("start", locus.Start),
+		sql.Named("end", locus.End)}
+}
+
+// Reads the gene records of a GTF file, such as one from GENCODE,
+// matching on the gene id without its version and then the gene name.
+// Biotypes are read from gene_type (GENCODE) or gene_biotype (Ensembl).
+func ReadGtfLocations(r io.Reader) ([]*GeneLocation, error) {
+	ret := make([]*GeneLocation, 0, 60000)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("gtf line has %d fields: %s", len(fields), line)
+		}
+
+		if fields[2] != "gene" {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[3])
+
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := strconv.Atoi(fields[4])
+
+		if err != nil {
+			return nil, err
+		}
+
+		attributes := gtfAttributes(fields[8])
+
+		biotype := attributes["gene_type"]
+
+		if biotype == "" {
+			biotype = attributes["gene_biotype"]
+		}
+
+		ret = append(ret, &GeneLocation{Ids: []string{stripVersion(attributes["gene_id"]), attributes["gene_name"]},
+			Strand:  fields[6],
+			Biotype: biotype,
+			Locus:   Locus{Chr: FormatChr(fields[0]), Start: start, End: end}})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// Reads a BED file whose name column is a gene id or symbol. BED
+// starts are 0-based so are converted to 1-based.
+func ReadBedLocations(r io.Reader) ([]*GeneLocation, error) {
+	ret := make([]*GeneLocation, 0, 60000)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" ||
+			strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, "track") ||
+			strings.HasPrefix(line, "browser") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("bed line must have a name: %s", line)
+		}
+
+		start, err := strconv.Atoi(fields[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := strconv.Atoi(fields[2])
+
+		if err != nil {
+			return nil, err
+		}
+
+		strand := ""
+
+		if len(fields) > 5 && fields[5] != "." {
+			strand = fields[5]
+		}
+
+		ret = append(ret, &GeneLocation{Ids: []string{stripVersion(fields[3]), fields[3]},
+			Strand: strand,
+			Locus:  Locus{Chr: FormatChr(fields[0]), Start: start + 1, End: end}})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// parses attributes such as gene_id "ENSG00000141510.17"; gene_name "TP53";
+func gtfAttributes(s string) map[string]string {
+	ret := make(map[string]string, 10)
+
+	for _, attribute := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(attribute), " ")
+
+		if !ok {
+			continue
+		}
+
+		// keep the first value of repeated attributes such as tag
+		if _, ok := ret[name]; !ok {
+			ret[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+
+	return ret
+}
+
+// Sets the locations and biotypes of the genes of a source such as
+// HGNC. Genes are matched on their Ensembl id, gene id or symbol. Genes
+// not in the file keep their current location. If dryRun is set the
+// report is returned but nothing is changed.
+func (gdb *GexDB) LoadGeneLocations(source string, locations []*GeneLocation, dryRun bool) (*LocationReport, error) {
+	if err := gdb.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	var sourceId int
+	var genomeId int
+
+	report := LocationReport{DryRun: dryRun}
+
+	err := gdb.rwdb.QueryRow(AnnotationSourceSQL, sql.Named("name", strings.ToLower(source))).Scan(
+		&sourceId,
+		&genomeId,
+		&report.Source)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, source)
+		}
+
+		return nil, err
+	}
+
+	tx, err := gdb.rwdb.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// lowercase ids, in order of preference, to gene
+	ensembl := make(map[string]int, 50000)
+	geneIds := make(map[string]int, 50000)
+	symbols := make(map[string]int, 50000)
+
+	rows, err := tx.Query(LocationGenesSQL, sql.Named("source", sourceId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var id int
+		var geneId string
+		var ensemblId string
+		var symbol string
+
+		err := rows.Scan(&id, &geneId, &ensemblId, &symbol)
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if ensemblId != "" {
+			ensembl[strings.ToLower(ensemblId)] = id
+		}
+
+		geneIds[strings.ToLower(geneId)] = id
+		symbols[strings.ToLower(symbol)] = id
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// a gene may appear more than once, e.g. on chrX and chrY, in
+	// which case the first location is used
+	seen := make(map[int]struct{}, len(locations))
+
+	for _, location := range locations {
+		id := findLocationGene(location, ensembl, geneIds, symbols)
+
+		if id == -1 {
+			report.Unmatched++
+			continue
+		}
+
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		seen[id] = struct{}{}
+
+		_, err = tx.Exec(UpdateGeneLocationSQL,
+			sql.Named("id", id),
+			sql.Named("chr", location.Chr),
+			sql.Named("start", location.Start),
+			sql.Named("end", location.End),
+			sql.Named("strand", location.Strand),
+			sql.Named("biotype", location.Biotype))
+
+		if err != nil {
+			return nil, err
+		}
+
+		report.Matched++
+	}
+
+	if dryRun {
+		return &report, nil
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// locations are in cached search results
+	gdb.catalogChanged()
+
+	err = gdb.InvalidateResults()
+
+	if err != nil {
+		log.Warn().Msgf("could not drop cached results: %s", err)
+	}
+
+	return &report, nil
+}
+
+func findLocationGene(location *GeneLocation, lookups ...map[string]int) int {
+	for _, lookup := range lookups {
+		for _, id := range location.Ids {
+			if id == "" {
+				continue
+			}
+
+			if gene, ok := lookup[strings.ToLower(id)]; ok {
+				return gene
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,33 @@
+package gex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+func TestLoadGeneLocationsInvalidatesResults(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	results := cache.NewMemory(1 << 20)
+
+	gdb.SetResultsCache(results, gex.DefaultResultsTTL)
+
+	key := (&gex.ResultsKey{Catalog: gdb.Name(), Generation: gdb.Generation(), Dataset: gextest.OpenDataset, IsAdmin: true}).String()
+
+	err := results.Set(context.Background(), key, []byte("{}"), gex.DefaultResultsTTL)
+	check(t, "Set", err)
+
+	_, err = gdb.LoadGeneLocations("HGNC", []*gex.GeneLocation{{Ids: []string{"MYC"}, Locus: gex.Locus{Chr: "chr8", Start: 1, End: 2}}}, false)
+	check(t, "LoadGeneLocations", err)
+
+	_, err = results.Get(context.Background(), key)
+
+	if !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("got %v, want the cached results to be dropped", err)
+	}
+}
@@ -0,0 +1,161 @@
+package gex
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/antonybholmes/go-sys/db"
+)
+
+type (
+	// A value a categorical field can take
+	MetadataCategory struct {
+		db.Entity
+		Color string `json:"color,omitempty"`
+	}
+
+	// Describes a sample metadata field in a dataset so that it can
+	// be displayed appropriately, e.g. numeric fields as a scale
+	MetadataField struct {
+		db.Entity
+		Type       string              `json:"type"`
+		Units      string              `json:"units,omitempty"`
+		Categories []*MetadataCategory `json:"categories,omitempty"`
+	}
+)
+
+const (
+	MetadataTypeCategorical = "categorical"
+	MetadataTypeNumeric     = "numeric"
+	MetadataTypeDate        = "date"
+	MetadataTypeBoolean     = "boolean"
+
+	// fields and categories in the order they were defined
+	MetadataSchemaSQL = `SELECT
+		m.id,
+		m.public_id,
+		m.name,
+		dm.type,
+		dm.units,
+		mc.id AS category_id,
+		mc.public_id AS category_public_id,
+		mc.name AS category_name,
+		mc.color AS category_color
+		FROM dataset_metadata dm
+		JOIN datasets d ON d.id = dm.dataset_id
+		JOIN metadata m ON m.id = dm.metadata_id
+		LEFT JOIN metadata_categories mc ON mc.dataset_id = dm.dataset_id AND mc.metadata_id = dm.metadata_id
+		WHERE
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
+			AND d.public_id = :id
+		ORDER BY dm.ord, mc.ord`
+)
+
+var (
+	numericValueRegex = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][-+]?\d+)?$`)
+	dateValueRegex    = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// Returns the type of a field from its non empty values, the same way
+// step2_make_gex_sql_bin.py does
+func inferMetadataType(values []string) string {
+	if len(values) == 0 {
+		return MetadataTypeCategorical
+	}
+
+	if allMatch(values, numericValueRegex.MatchString) {
+		return MetadataTypeNumeric
+	}
+
+	if allMatch(values, func(v string) bool {
+		switch strings.ToLower(v) {
+		case "true", "false", "yes", "no":
+			return true
+		default:
+			return false
+		}
+	}) {
+		return MetadataTypeBoolean
+	}
+
+	if allMatch(values, dateValueRegex.MatchString) {
+		return MetadataTypeDate
+	}
+
+	return MetadataTypeCategorical
+}
+
+func allMatch(values []string, match func(string) bool) bool {
+	for _, v := range values {
+		if !match(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the metadata fields used by the samples in a dataset
+func (gdb *GexDB) MetadataSchema(datasetId string, isAdmin bool, permissions []string) ([]*MetadataField, error) {
+
+	rows, err := gdb.queryWithPermissions(MetadataSchemaSQL, isAdmin, permissions, sql.Named("id", datasetId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	fields := make([]*MetadataField, 0, 20)
+
+	var currentField *MetadataField
+
+	for rows.Next() {
+		var field MetadataField
+		var categoryId sql.NullInt64
+		var categoryPublicId sql.NullString
+		var categoryName sql.NullString
+		var categoryColor sql.NullString
+
+		err := rows.Scan(
+			&field.Id,
+			&field.PublicId,
+			&field.Name,
+			&field.Type,
+			&field.Units,
+			&categoryId,
+			&categoryPublicId,
+			&categoryName,
+			&categoryColor)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if currentField == nil || currentField.Id != field.Id {
+			currentField = &field
+			currentField.Categories = make([]*MetadataCategory, 0, 10)
+			fields = append(fields, currentField)
+		}
+
+		if !categoryId.Valid {
+			continue
+		}
+
+		var category MetadataCategory
+
+		category.Id = int(categoryId.Int64)
+		category.PublicId = categoryPublicId.String
+		category.Name = categoryName.String
+		category.Color = categoryColor.String
+
+		currentField.Categories = append(currentField.Categories, &category)
+	}
+
+	return fields, nil
+}
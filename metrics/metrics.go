@@ -0,0 +1,402 @@
+// Package metrics keeps counters, gauges and histograms and writes them
+// in the Prometheus text format so they can be scraped from /metrics.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	Metric interface {
+		Name() string
+		write(w *bufio.Writer)
+	}
+
+	// A set of metrics written together, sorted by name
+	Registry struct {
+		lock    sync.Mutex
+		metrics map[string]Metric
+	}
+
+	// the fields every metric has
+	desc struct {
+		name   string
+		help   string
+		labels []string
+	}
+
+	// A value that only goes up, such as the number of requests,
+	// optionally split by labels
+	Counter struct {
+		desc
+		lock   sync.Mutex
+		series map[string]*counterSeries
+	}
+
+	counterSeries struct {
+		labels []string
+		value  float64
+	}
+
+	// A value that can go up and down, such as the number of open files
+	Gauge struct {
+		*Counter
+	}
+
+	// A counter or gauge whose value is read when the metrics are
+	// written, e.g. from a cache's own stats
+	Func struct {
+		desc
+		kind  string
+		value func() float64
+	}
+
+	// Counters or gauges read when the metrics are written, with one
+	// value for each set of labels, e.g. from the stats of each of
+	// several caches
+	FuncVec struct {
+		desc
+		kind    string
+		collect func(observe func(v float64, labels ...string))
+	}
+
+	// Counts observations, such as latencies, in buckets so
+	// percentiles can be estimated
+	Histogram struct {
+		desc
+		buckets []float64
+		lock    sync.Mutex
+		series  map[string]*histogramSeries
+	}
+
+	histogramSeries struct {
+		labels []string
+		// observations in each bucket, not cumulative, with the
+		// last for those bigger than every bucket
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Latency buckets in seconds from 1ms to 10s
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The registry metrics created by the New functions are added to
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]Metric)}
+}
+
+// Adds a metric, replacing any with the same name
+func (r *Registry) Register(m Metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.metrics[m.Name()] = m
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.metrics))
+
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	metrics := make([]Metric, 0, len(names))
+
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+
+	r.lock.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// Serves the metrics for Prometheus to scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := newCounter(name, help, labels)
+	Default.Register(c)
+	return c
+}
+
+func newCounter(name string, help string, labels []string) *Counter {
+	c := &Counter{desc: desc{name: name, help: help, labels: labels},
+		series: make(map[string]*counterSeries)}
+
+	// a metric without labels is written as 0 before it changes
+	if len(labels) == 0 {
+		c.Add(0)
+	}
+
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (c *Counter) Add(v float64, labels ...string) {
+	key := seriesKey(labels)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s, ok := c.series[key]
+
+	if !ok {
+		s = &counterSeries{labels: slices.Clone(labels)}
+		c.series[key] = s
+	}
+
+	s.value += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeAs(w, "counter")
+}
+
+func (c *Counter) writeAs(w *bufio.Writer, kind string) {
+	c.writeHeader(w, kind)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		writeSample(w, c.name, c.labels, s.labels, "", s.value)
+	}
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{Counter: newCounter(name, help, labels)}
+	Default.Register(g)
+	return g
+}
+
+func (g *Gauge) Dec(labels ...string) {
+	g.Add(-1, labels...)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeAs(w, "gauge")
+}
+
+func NewCounterFunc(name string, help string, value func() float64) *Func {
+	f := &Func{desc: desc{name: name, help: help}, kind: "counter", value: value}
+	Default.Register(f)
+	return f
+}
+
+func NewGaugeFunc(name string, help string, value func() float64) *Func {
+	f := &Func{desc: desc{name: name, help: help}, kind: "gauge", value: value}
+	Default.Register(f)
+	return f
+}
+
+func (f *Func) write(w *bufio.Writer) {
+	f.writeHeader(w, f.kind)
+	writeSample(w, f.name, nil, nil, "", f.value())
+}
+
+// Creates a counter whose values are read by calling collect when the
+// metrics are written. Collect calls observe with each value and its
+// labels.
+func NewCounterFuncVec(name string, help string, collect func(observe func(v float64, labels ...string)), labels ...string) *FuncVec {
+	f := &FuncVec{desc: desc{name: name, help: help, labels: labels}, kind: "counter", collect: collect}
+	Default.Register(f)
+	return f
+}
+
+func NewGaugeFuncVec(name string, help string, collect func(observe func(v float64, labels ...string)), labels ...string) *FuncVec {
+	f := &FuncVec{desc: desc{name: name, help: help, labels: labels}, kind: "gauge", collect: collect}
+	Default.Register(f)
+	return f
+}
+
+func (f *FuncVec) write(w *bufio.Writer) {
+	f.writeHeader(w, f.kind)
+
+	series := make(map[string]*counterSeries)
+
+	f.collect(func(v float64, labels ...string) {
+		series[seriesKey(labels)] = &counterSeries{labels: slices.Clone(labels), value: v}
+	})
+
+	for _, key := range sortedKeys(series) {
+		s := series[key]
+		writeSample(w, f.name, f.labels, s.labels, "", s.value)
+	}
+}
+
+// Creates a histogram using buckets in ascending order, or
+// DefaultBuckets if there are none
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	h := &Histogram{desc: desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries)}
+
+	if len(labels) == 0 {
+		h.series[""] = &histogramSeries{counts: make([]uint64, len(buckets)+1)}
+	}
+
+	Default.Register(h)
+
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := seriesKey(labels)
+
+	// the first bucket v fits in, or the overflow bucket
+	i, _ := slices.BinarySearch(h.buckets, v)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	s, ok := h.series[key]
+
+	if !ok {
+		s = &histogramSeries{labels: slices.Clone(labels), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// Observes the seconds since start and returns them so callers can
+// also log them
+func (h *Histogram) ObserveSince(start time.Time, labels ...string) time.Duration {
+	d := time.Since(start)
+	h.Observe(d.Seconds(), labels...)
+	return d
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w, "histogram")
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	labels := append(slices.Clone(h.labels), "le")
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+
+		for i, bucket := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", labels, append(slices.Clone(s.labels), formatFloat(bucket)), "", float64(cumulative))
+		}
+
+		writeSample(w, h.name+"_bucket", labels, append(slices.Clone(s.labels), "+Inf"), "", float64(s.count))
+		writeSample(w, h.name, h.labels, s.labels, "_sum", s.sum)
+		writeSample(w, h.name, h.labels, s.labels, "_count", float64(s.count))
+	}
+}
+
+func (d *desc) Name() string {
+	return d.name
+}
+
+func (d *desc) writeHeader(w *bufio.Writer, kind string) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, kind)
+}
+
+func writeSample(w *bufio.Writer, name string, names []string, values []string, suffix string, v float64) {
+	w.WriteString(name)
+	w.WriteString(suffix)
+
+	if len(names) > 0 {
+		w.WriteByte('{')
+
+		for i, label := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+
+			value := ""
+
+			if i < len(values) {
+				value = values[i]
+			}
+
+			fmt.Fprintf(w, `%s="%s"`, label, escapeLabel(value))
+		}
+
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// label values cannot contain a zero byte so it safely separates them
+func seriesKey(labels []string) string {
+	return strings.Join(labels, "\x00")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
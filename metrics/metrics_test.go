@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Writes a single metric as Prometheus would scrape it
+func exposition(t *testing.T, m Metric) string {
+	t.Helper()
+
+	r := NewRegistry()
+	r.Register(m)
+
+	var b strings.Builder
+
+	err := r.Write(&b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+func wantExposition(t *testing.T, m Metric, want string) {
+	t.Helper()
+
+	if got := exposition(t, m); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := newCounter("requests_total", "Requests by route.", []string{"route", "status"})
+
+	c.Inc("/genes", "200")
+	c.Add(2, "/genes", "200")
+	c.Inc("/datasets", "500")
+
+	wantExposition(t, c, `# HELP requests_total Requests by route.
+# TYPE requests_total counter
+requests_total{route="/datasets",status="500"} 1
+requests_total{route="/genes",status="200"} 3
+`)
+}
+
+// Metrics without labels are written before they change so a scrape
+// sees 0 rather than nothing
+func TestCounterWithoutLabels(t *testing.T) {
+	wantExposition(t, newCounter("bytes_total", "Bytes read.", nil), `# HELP bytes_total Bytes read.
+# TYPE bytes_total counter
+bytes_total 0
+`)
+}
+
+func TestGauge(t *testing.T) {
+	g := &Gauge{Counter: newCounter("open_files", "Open files.", nil)}
+
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	wantExposition(t, g, `# HELP open_files Open files.
+# TYPE open_files gauge
+open_files 1
+`)
+}
+
+func TestEscaping(t *testing.T) {
+	c := newCounter("escaped_total", "A help\nwith a \\ backslash.", []string{"value"})
+
+	c.Inc("a \"quoted\"\nvalue \\")
+
+	wantExposition(t, c, `# HELP escaped_total A help\nwith a \\ backslash.
+# TYPE escaped_total counter
+escaped_total{value="a \"quoted\"\nvalue \\"} 1
+`)
+}
+
+func TestFunc(t *testing.T) {
+	f := &Func{desc: desc{name: "cache_bytes", help: "Bytes cached."}, kind: "gauge", value: func() float64 { return 1.5e9 }}
+
+	wantExposition(t, f, `# HELP cache_bytes Bytes cached.
+# TYPE cache_bytes gauge
+cache_bytes 1.5e+09
+`)
+}
+
+// Labelled values are sorted whatever order they are collected in
+func TestFuncVec(t *testing.T) {
+	f := &FuncVec{desc: desc{name: "cache_hits_total", help: "Cache hits.", labels: []string{"catalog"}},
+		kind: "counter",
+		collect: func(observe func(float64, ...string)) {
+			observe(2, "public")
+			observe(5, "private")
+		}}
+
+	wantExposition(t, f, `# HELP cache_hits_total Cache hits.
+# TYPE cache_hits_total counter
+cache_hits_total{catalog="private"} 5
+cache_hits_total{catalog="public"} 2
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	h := &Histogram{desc: desc{name: "request_seconds", help: "Request latency.", labels: []string{"route"}},
+		buckets: []float64{0.1, 1},
+		series:  make(map[string]*histogramSeries)}
+
+	h.Observe(0.05, "/genes")
+	h.Observe(0.1, "/genes")
+	h.Observe(0.5, "/genes")
+	h.Observe(2, "/genes")
+
+	wantExposition(t, h, `# HELP request_seconds Request latency.
+# TYPE request_seconds histogram
+request_seconds_bucket{route="/genes",le="0.1"} 2
+request_seconds_bucket{route="/genes",le="1"} 3
+request_seconds_bucket{route="/genes",le="+Inf"} 4
+request_seconds_sum{route="/genes"} 2.65
+request_seconds_count{route="/genes"} 4
+`)
+}
+
+// Metrics are written in order of name with the content type
+// Prometheus expects
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Register(newCounter("b_total", "B.", nil))
+	r.Register(newCounter("a_total", "A.", nil))
+
+	w := httptest.NewRecorder()
+
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("got content type %s, want %s", got, ContentType)
+	}
+
+	names := []string{}
+
+	scanner := bufio.NewScanner(w.Body)
+
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "# TYPE "); ok {
+			names = append(names, strings.Fields(name)[0])
+		}
+	}
+
+	if strings.Join(names, ",") != "a_total,b_total" {
+		t.Errorf("got metrics %v, want a_total,b_total", names)
+	}
+}
@@ -0,0 +1,346 @@
+package gex
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/antonybholmes/go-sys"
+	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-web"
+)
+
+const (
+	PermissionsSQL = `SELECT
+		p.id,
+		p.public_id,
+		p.name
+		FROM permissions p
+		ORDER BY p.name`
+
+	PermissionSQL = `SELECT
+		p.id,
+		p.public_id,
+		p.name
+		FROM permissions p
+		WHERE
+			p.public_id = :id
+			OR p.name = :id
+		LIMIT 1`
+
+	DatasetPermissionsSQL = `SELECT
+		p.id,
+		p.public_id,
+		p.name
+		FROM permissions p
+		JOIN dataset_permissions dp ON dp.permission_id = p.id
+		JOIN datasets d ON d.id = dp.dataset_id
+		WHERE
+			d.public_id = :id
+		ORDER BY p.name`
+
+	SamplePermissionsSQL = `SELECT
+		p.id,
+		p.public_id,
+		p.name
+		FROM permissions p
+		JOIN sample_permissions sp ON sp.permission_id = p.id
+		JOIN samples s ON s.id = sp.sample_id
+		WHERE
+			s.public_id = :id
+		ORDER BY p.name`
+
+	DatasetIdSQL = `SELECT
+		d.id
+		FROM datasets d
+		WHERE
+			d.public_id = :id`
+
+	SampleIdSQL = `SELECT
+		s.id
+		FROM samples s
+		WHERE
+			s.public_id = :id`
+
+	SampleDatasetSQL = `SELECT
+		d.public_id
+		FROM samples s
+		JOIN datasets d ON d.id = s.dataset_id
+		WHERE
+			s.id = :id`
+
+	InsertPermissionSQL = `INSERT INTO permissions (public_id, name) VALUES (:public_id, :name) RETURNING id`
+
+	GrantDatasetPermissionSQL = `INSERT INTO dataset_permissions (dataset_id, permission_id) 
+		VALUES (:dataset, :permission)
+		ON CONFLICT DO NOTHING`
+
+	RevokeDatasetPermissionSQL = `DELETE FROM dataset_permissions 
+		WHERE dataset_id = :dataset AND permission_id = :permission`
+
+	GrantSamplePermissionSQL = `INSERT INTO sample_permissions (sample_id, permission_id) 
+		VALUES (:sample, :permission)
+		ON CONFLICT DO NOTHING`
+
+	RevokeSamplePermissionSQL = `DELETE FROM sample_permissions 
+		WHERE sample_id = :sample AND permission_id = :permission`
+)
+
+var (
+	ErrPermissionNotFound = errors.New("permission not found")
+	ErrPermissionExists   = errors.New("permission already exists")
+	ErrDatasetNotFound    = errors.New("dataset not found")
+	ErrSampleNotFound     = errors.New("sample not found")
+)
+
+func (gdb *GexDB) Permissions() ([]*db.Entity, error) {
+	return gdb.queryPermissions(PermissionsSQL)
+}
+
+// The permissions that give access to a dataset. A user needs any one
+// of them to see it.
+func (gdb *GexDB) DatasetPermissions(datasetId string) ([]*db.Entity, error) {
+	_, err := gdb.datasetId(datasetId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.queryPermissions(DatasetPermissionsSQL, sql.Named("id", datasetId))
+}
+
+// The permissions that restrict who can see a sample within its
+// dataset. If there are none, anyone who can see the dataset can
+// see the sample.
+func (gdb *GexDB) SamplePermissions(sampleId string) ([]*db.Entity, error) {
+	_, err := gdb.sampleId(sampleId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.queryPermissions(SamplePermissionsSQL, sql.Named("id", sampleId))
+}
+
+func (gdb *GexDB) queryPermissions(query string, namedArgs ...any) ([]*db.Entity, error) {
+	rows, err := gdb.db.Query(query, namedArgs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := make([]*db.Entity, 0, 10)
+
+	for rows.Next() {
+		var permission db.Entity
+
+		err := rows.Scan(
+			&permission.Id,
+			&permission.PublicId,
+			&permission.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, &permission)
+	}
+
+	return permissions, nil
+}
+
+// Returns a permission using either its public id or name
+func (gdb *GexDB) Permission(id string) (*db.Entity, error) {
+	var ret db.Entity
+
+	// permission names are matched in lowercase by
+	// sqlite.MakePermissionsSql so we store them that way
+	err := gdb.db.QueryRow(PermissionSQL, sql.Named("id", web.FormatParam(id))).Scan(
+		&ret.Id,
+		&ret.PublicId,
+		&ret.Name)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrPermissionNotFound, id)
+		}
+
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// Creates a new permission, e.g. "collab:view", which can then be
+// granted on datasets and given to users
+func (gdb *GexDB) CreatePermission(name string) (*db.Entity, error) {
+	if err := gdb.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	name = web.FormatParam(name)
+
+	if name == "" {
+		return nil, errors.New("permission name is required")
+	}
+
+	_, err := gdb.Permission(name)
+
+	if err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPermissionExists, name)
+	}
+
+	if !errors.Is(err, ErrPermissionNotFound) {
+		return nil, err
+	}
+
+	publicId, err := sys.Uuidv7()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := db.Entity{Name: name}
+	ret.PublicId = publicId
+
+	// postgres does not support LastInsertId so return the id instead
+	err = gdb.rwdb.QueryRow(InsertPermissionSQL,
+		sql.Named("public_id", publicId),
+		sql.Named("name", name)).Scan(&ret.Id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// Allows users with a permission to see a dataset
+func (gdb *GexDB) GrantDatasetPermission(datasetId string, permission string) error {
+	return gdb.execDatasetPermission(GrantDatasetPermissionSQL, datasetId, permission)
+}
+
+// Stops a permission giving access to a dataset. Users may still be
+// able to see the dataset through its other permissions.
+func (gdb *GexDB) RevokeDatasetPermission(datasetId string, permission string) error {
+	return gdb.execDatasetPermission(RevokeDatasetPermissionSQL, datasetId, permission)
+}
+
+func (gdb *GexDB) execDatasetPermission(query string, datasetId string, permission string) error {
+	if err := gdb.checkWritable(); err != nil {
+		return err
+	}
+
+	id, err := gdb.datasetId(datasetId)
+
+	if err != nil {
+		return err
+	}
+
+	p, err := gdb.Permission(permission)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = gdb.rwdb.Exec(query,
+		sql.Named("dataset", id),
+		sql.Named("permission", p.Id))
+
+	if err != nil {
+		return err
+	}
+
+	// who can see what has changed
+	gdb.catalogChanged()
+
+	return gdb.InvalidateDataset(datasetId)
+}
+
+// Maps a dataset public id to its database id
+func (gdb *GexDB) datasetId(datasetId string) (int, error) {
+	var id int
+
+	err := gdb.db.QueryRow(DatasetIdSQL, sql.Named("id", datasetId)).Scan(&id)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, fmt.Errorf("%w: %s", ErrDatasetNotFound, datasetId)
+		}
+
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// Restricts a sample to users with a permission. Once a sample has a
+// permission, users who can see the dataset but have none of the
+// sample's permissions will no longer see it.
+func (gdb *GexDB) GrantSamplePermission(sampleId string, permission string) error {
+	return gdb.execSamplePermission(GrantSamplePermissionSQL, sampleId, permission)
+}
+
+// Removes a restriction from a sample. If it was the last one, the
+// sample can be seen by anyone who can see its dataset.
+func (gdb *GexDB) RevokeSamplePermission(sampleId string, permission string) error {
+	return gdb.execSamplePermission(RevokeSamplePermissionSQL, sampleId, permission)
+}
+
+func (gdb *GexDB) execSamplePermission(query string, sampleId string, permission string) error {
+	if err := gdb.checkWritable(); err != nil {
+		return err
+	}
+
+	id, err := gdb.sampleId(sampleId)
+
+	if err != nil {
+		return err
+	}
+
+	p, err := gdb.Permission(permission)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = gdb.rwdb.Exec(query,
+		sql.Named("sample", id),
+		sql.Named("permission", p.Id))
+
+	if err != nil {
+		return err
+	}
+
+	// who can see what has changed
+	gdb.catalogChanged()
+
+	var datasetId string
+
+	err = gdb.db.QueryRow(SampleDatasetSQL, sql.Named("id", id)).Scan(&datasetId)
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.InvalidateDataset(datasetId)
+}
+
+// Maps a sample public id to its database id
+func (gdb *GexDB) sampleId(sampleId string) (int, error) {
+	var id int
+
+	err := gdb.db.QueryRow(SampleIdSQL, sql.Named("id", sampleId)).Scan(&id)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, fmt.Errorf("%w: %s", ErrSampleNotFound, sampleId)
+		}
+
+		return -1, err
+	}
+
+	return id, nil
+}
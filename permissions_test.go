@@ -0,0 +1,140 @@
+package gex_test
+
+import (
+	"strings"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+// Users without a permission a dataset has, including users with no
+// permissions at all, must not learn that it or any of its samples
+// exist
+func TestUnprivilegedUserSeesNothing(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+	check(t, "FindProbes", err)
+
+	geneSet, err := gdb.GeneSet(genome, gextest.GeneSet)
+	check(t, "GeneSet", err)
+
+	for name, permissions := range map[string][]string{"none": nil,
+		"empty":     {},
+		"unrelated": {"other"},
+		// sample permissions do not give access to the dataset
+		"consortium": {gextest.ConsortiumPermission}} {
+
+		t.Run(name, func(t *testing.T) {
+			datasets, err := gdb.Datasets("human", "rna-seq", permissions, false)
+			check(t, "Datasets", err)
+			want(t, "datasets", len(datasets), 0)
+
+			datasets, err = gdb.DatasetSummaries("human", "rna-seq", permissions, false)
+			check(t, "DatasetSummaries", err)
+			want(t, "summaries", len(datasets), 0)
+
+			for _, datasetId := range []string{gextest.OpenDataset, gextest.SecretDataset} {
+				_, err = gdb.Dataset(datasetId, false, permissions)
+				refused(t, "Dataset", err)
+
+				page, err := gdb.DatasetSamples(datasetId, 1, 10, false, permissions)
+				check(t, "DatasetSamples", err)
+				want(t, "page samples", len(page.Samples), 0)
+				want(t, "page total", page.Total, 0)
+
+				schema, err := gdb.MetadataSchema(datasetId, false, permissions)
+				check(t, "MetadataSchema", err)
+				want(t, "fields", len(schema), 0)
+
+				_, err = gdb.BasicDataset(datasetId, permissions, false)
+				refused(t, "BasicDataset", err)
+
+				_, _, err = gdb.GenomeTechnology(datasetId, false, permissions)
+				refused(t, "GenomeTechnology", err)
+
+				_, err = gdb.Expression(datasetId, exprType, probes, false, permissions)
+				refused(t, "Expression", err)
+
+				_, err = gdb.ScoreGeneSet(datasetId, exprType, geneSet, probes, gex.ScoreMethodZScore, false, permissions, nil)
+				refused(t, "ScoreGeneSet", err)
+			}
+		})
+	}
+}
+
+// Users who can see a dataset only see the samples they have a
+// permission for, whether listed, counted or in expression values
+func TestHiddenSamples(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+	check(t, "FindProbes", err)
+
+	for _, test := range []struct {
+		name        string
+		permissions []string
+		samples     string
+		values      []float32
+	}{{name: "viewer",
+		permissions: []string{gextest.ViewPermission},
+		samples:     "O1,O2,O3",
+		values:      []float32{20, 21, 22}},
+		{name: "consortium",
+			permissions: []string{gextest.ViewPermission, gextest.ConsortiumPermission},
+			samples:     "O1,O2,O3,O4",
+			values:      []float32{20, 21, 22, 23}}} {
+
+		t.Run(test.name, func(t *testing.T) {
+			count := len(strings.Split(test.samples, ","))
+
+			datasets, err := gdb.Datasets("human", "rna-seq", test.permissions, false)
+			check(t, "Datasets", err)
+			want(t, "datasets", len(datasets), 1)
+			want(t, "dataset samples", sampleNames(datasets[0].Samples), test.samples)
+			want(t, "dataset sample count", datasets[0].SampleCount, count)
+
+			datasets, err = gdb.DatasetSummaries("human", "rna-seq", test.permissions, false)
+			check(t, "DatasetSummaries", err)
+			want(t, "summary sample count", datasets[0].SampleCount, count)
+
+			dataset, err := gdb.Dataset(gextest.OpenDataset, false, test.permissions)
+			check(t, "Dataset", err)
+			want(t, "samples", sampleNames(dataset.Samples), test.samples)
+			want(t, "sample count", dataset.SampleCount, count)
+
+			page, err := gdb.DatasetSamples(gextest.OpenDataset, 1, 10, false, test.permissions)
+			check(t, "DatasetSamples", err)
+			want(t, "page samples", sampleNames(page.Samples), test.samples)
+			want(t, "page total", page.Total, count)
+
+			results, err := gdb.Expression(gextest.OpenDataset, exprType, probes, false, test.permissions)
+			check(t, "Expression", err)
+			want(t, "values", values(results.Probes[0].Values), values(test.values))
+
+			_, err = gdb.Dataset(gextest.SecretDataset, false, test.permissions)
+			refused(t, "Dataset", err)
+		})
+	}
+}
+
+func refused(t *testing.T, name string, err error) {
+	t.Helper()
+
+	if err == nil {
+		t.Errorf("%s: an unprivileged user was not refused", name)
+	}
+}
@@ -0,0 +1,66 @@
+package gex_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+// Searches share the connection pool, so each must only see its own
+// ids. Run with -race.
+func TestFindProbesParallel(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	searches := map[string]string{"BCL6": "BCL6",
+		"MYC":          "MYC",
+		"TP53,MYC":     "TP53,MYC",
+		"BCL5,ORPHAN":  "BCL6,ORPHAN",
+		"NOTAGENE,MYC": "MYC"}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 50*len(searches))
+
+	for i := range 50 {
+		for search, expected := range searches {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				probes, err := gdb.FindProbes(genome, technology, strings.Split(search, ","), nil)
+
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				names := make([]string, 0, len(probes))
+
+				for _, probe := range probes {
+					names = append(names, probe.Name)
+				}
+
+				if got := strings.Join(names, ","); got != expected {
+					errs <- fmt.Errorf("search %d for %s: got %s, want %s", i, search, got, expected)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,75 @@
+package gex
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/antonybholmes/go-web/auth/sqlite"
+)
+
+const (
+	PermissionsPlaceholder = "<<PERMISSIONS>>"
+	NoPermissionsSQL       = "1 = 0"
+)
+
+// returned if a dataset scoped query does not restrict its rows to the
+// datasets a user can see, which would leak restricted datasets
+var ErrQueryNotPermissionChecked = errors.New("query is not permission checked")
+
+// Every query that returns information about a dataset, such as its
+// samples, genome, expression types or the files its values are in,
+// must go through one of these so that users can only learn about the
+// datasets they have permission to view
+
+// Adds the permission clause to a dataset scoped query and returns the
+// query with its args ready to run
+func permissionsSql(query string, isAdmin bool, permissions []string, namedArgs []any) (string, []any, error) {
+	if !strings.Contains(query, PermissionsPlaceholder) {
+		return "", nil, ErrQueryNotPermissionChecked
+	}
+
+	// queries can check permissions more than once, e.g. for the dataset
+	// and its samples, so build the clause once and use it everywhere
+	clause := NoPermissionsSQL
+
+	// an empty IN list is not valid in every database so users without
+	// permissions get a clause that matches nothing
+	if isAdmin || len(permissions) > 0 {
+		clause = sqlite.MakePermissionsSql(PermissionsPlaceholder, isAdmin, permissions, &namedArgs)
+	}
+
+	return strings.ReplaceAll(query, PermissionsPlaceholder, clause), namedArgs, nil
+}
+
+func (gdb *GexDB) queryWithPermissions(query string,
+	isAdmin bool,
+	permissions []string,
+	namedArgs ...any) (*sql.Rows, error) {
+
+	query, namedArgs, err := permissionsSql(query, isAdmin, permissions, namedArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gdb.db.Query(query, namedArgs...)
+}
+
+// Runs a dataset scoped query expected to return at most one row and
+// scans it into dest. Returns sql.ErrNoRows if the dataset does not
+// exist or the user cannot see it so the two cases look the same.
+func (gdb *GexDB) queryRowWithPermissions(query string,
+	isAdmin bool,
+	permissions []string,
+	namedArgs []any,
+	dest ...any) error {
+
+	query, namedArgs, err := permissionsSql(query, isAdmin, permissions, namedArgs)
+
+	if err != nil {
+		return err
+	}
+
+	return gdb.db.QueryRow(query, namedArgs...).Scan(dest...)
+}
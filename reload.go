@@ -0,0 +1,61 @@
+package gex
+
+import (
+	"context"
+	"time"
+)
+
+// How long a replaced catalog waits for the requests using it to
+// finish before it is closed anyway
+const DefaultDrainTimeout = 30 * time.Second
+
+// Marks the catalog as in use by a request so it is not closed until
+// Release is called, even if a new release replaces it
+func (gdb *GexDB) Acquire() {
+	gdb.requests.Add(1)
+}
+
+func (gdb *GexDB) Release() {
+	gdb.requests.Done()
+}
+
+// Waits for every request using the catalog to finish. Nothing should
+// be able to acquire the catalog once draining starts. An error is
+// returned if ctx ends first, but the catalog is still in use.
+func (gdb *GexDB) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		gdb.requests.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Gives the catalog the limits and caches of one it replaces so a new
+// release behaves like the old one. Results cached from the old data
+// are under the old catalog's generation so are never read by this
+// one.
+func (gdb *GexDB) CopySettings(from *GexDB) {
+	gdb.limits = from.limits
+	gdb.blocks.SetMaxSize(from.blocks.Stats().MaxSize)
+	gdb.results = from.results
+	gdb.resultsTTL = from.resultsTTL
+	gdb.name = from.name
+}
+
+// Drops every cached search result of the catalog, e.g. after a new
+// release of it. Other catalogs sharing the cache keep theirs.
+func (gdb *GexDB) InvalidateResults() error {
+	if gdb.results == nil {
+		return nil
+	}
+
+	return gdb.results.DeletePrefix(context.Background(), catalogResultsPrefix(gdb.name))
+}
@@ -0,0 +1,186 @@
+package gex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+// Identifies the results of an expression search. Users with the same
+// permissions see the same samples so can share results.
+type ResultsKey struct {
+	// the name of the catalog, since catalogs sharing a cache can
+	// have datasets with the same ids
+	Catalog string
+	// which opening of the catalog cached the results, see
+	// GexDB.Generation
+	Generation  string
+	Dataset     string
+	ExprType    string
+	Genes       []string
+	IsAdmin     bool
+	Permissions []string
+}
+
+const (
+	DefaultResultsTTL = 10 * time.Minute
+
+	resultsPrefix = "results:"
+)
+
+// Caches search results, e.g. in Redis so they are kept out of the
+// service's memory. Results are kept for ttl or until their dataset
+// changes. A nil cache turns caching off, which is the default.
+func (gdb *GexDB) SetResultsCache(results cache.Cache, ttl time.Duration) {
+	gdb.results = results
+	gdb.resultsTTL = ttl
+}
+
+// The name the catalog is registered under
+func (gdb *GexDB) Name() string {
+	return gdb.name
+}
+
+// Identifies this opening of the catalog. Results are cached under it
+// so a catalog that replaces this one in a reload never reads results
+// from the old data, even ones cached after the reload by requests
+// still using this catalog.
+func (gdb *GexDB) Generation() string {
+	return gdb.generation
+}
+
+// Names the catalog so its cached results are kept apart from those of
+// other catalogs using the same cache. This should be done before the
+// catalog is used.
+func (gdb *GexDB) SetName(name string) {
+	gdb.name = name
+}
+
+// Works like Expression but returns cached results if the same genes
+// were recently searched for by a user with the same permissions. The
+// results are a copy so can be modified, e.g. to add gene set scores.
+// Where the time went is added to timings, which can be nil.
+func (gdb *GexDB) CachedExpression(genes []string,
+	datasetId string,
+	exprType *db.Entity,
+	probes []*Probe,
+	isAdmin bool,
+	permissions []string,
+	timings *Timings) (*SearchResults, error) {
+
+	if gdb.results == nil {
+		return gdb.timedExpression(datasetId, exprType, probes, isAdmin, permissions, timings)
+	}
+
+	ctx := context.Background()
+
+	key := (&ResultsKey{Catalog: gdb.name,
+		Generation:  gdb.generation,
+		Dataset:     datasetId,
+		ExprType:    exprType.PublicId,
+		Genes:       genes,
+		IsAdmin:     isAdmin,
+		Permissions: permissions}).String()
+
+	data, err := gdb.results.Get(ctx, key)
+
+	if err == nil {
+		var ret SearchResults
+
+		err = json.Unmarshal(data, &ret)
+
+		if err == nil {
+			resultsCacheRequests.Inc("hit")
+			return &ret, nil
+		}
+	}
+
+	resultsCacheRequests.Inc("miss")
+
+	// a cache that is down should only make searches slower
+	if !errors.Is(err, cache.ErrMiss) {
+		log.Warn().Msgf("could not read cached results: %s", err)
+	}
+
+	ret, err := gdb.timedExpression(datasetId, exprType, probes, isAdmin, permissions, timings)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(ret)
+
+	// results that cannot be encoded, e.g. because of NaN values,
+	// are returned without being cached
+	if err != nil {
+		log.Warn().Msgf("could not cache results: %s", err)
+		return ret, nil
+	}
+
+	err = gdb.results.Set(ctx, key, data, gdb.resultsTTL)
+
+	if err != nil {
+		log.Warn().Msgf("could not cache results: %s", err)
+	}
+
+	return ret, nil
+}
+
+// Drops the cached results of a dataset so searches see its changes
+func (gdb *GexDB) InvalidateDataset(datasetId string) error {
+	if gdb.results == nil {
+		return nil
+	}
+
+	return gdb.results.DeletePrefix(context.Background(), datasetResultsPrefix(gdb.name, datasetId))
+}
+
+// The key starts with the catalog and dataset so their results can be
+// dropped together, whichever generation of the catalog cached them.
+// The rest is hashed to keep keys short however many genes were
+// searched for.
+func (key *ResultsKey) String() string {
+	// genes are matched ignoring case and whitespace but their order
+	// is the order of the results so is kept
+	genes := make([]string, 0, len(key.Genes))
+
+	for _, gene := range key.Genes {
+		genes = append(genes, strings.ToLower(strings.TrimSpace(gene)))
+	}
+
+	// admins see everything whatever their permissions
+	permissions := []string{"admin"}
+
+	if !key.IsAdmin {
+		permissions = slices.Clone(key.Permissions)
+		slices.Sort(permissions)
+		permissions = slices.Compact(permissions)
+	}
+
+	h := sha256.New()
+
+	for _, part := range [][]string{{key.ExprType}, genes, permissions} {
+		// separate the lists so items cannot move between them
+		h.Write([]byte(strings.Join(part, "\x00")))
+		h.Write([]byte{0xff})
+	}
+
+	return datasetResultsPrefix(key.Catalog, key.Dataset) + key.Generation + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+func catalogResultsPrefix(name string) string {
+	return resultsPrefix + name + ":"
+}
+
+func datasetResultsPrefix(name string, datasetId string) string {
+	return catalogResultsPrefix(name) + datasetId + ":"
+}
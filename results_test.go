@@ -0,0 +1,168 @@
+package gex_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/cache"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-gex/store"
+)
+
+// Results json cannot encode are returned but not cached
+func TestCachedExpressionNaN(t *testing.T) {
+	path := gextest.NewBaseline(t)
+
+	// the first value of MYC, probe 2, which is the second block
+	file, err := os.OpenFile(filepath.Join(filepath.Dir(path), "open", "tpm.bin"), os.O_WRONLY, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = file.WriteAt(binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(math.NaN()))), gex.BinHeaderSize+store.BlockSize(gextest.FileSamples["open/tpm.bin"])+4)
+
+	file.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer gdb.Close()
+
+	results := cache.NewMemory(1 << 20)
+
+	gdb.SetResultsCache(results, gex.DefaultResultsTTL)
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+	check(t, "FindProbes", err)
+
+	ret, err := gdb.CachedExpression([]string{"MYC"}, gextest.OpenDataset, exprType, probes, true, nil, nil)
+	check(t, "CachedExpression", err)
+
+	if value := ret.Probes[0].Values[0]; !math.IsNaN(float64(value)) {
+		t.Errorf("got %v, want NaN", value)
+	}
+
+	key := (&gex.ResultsKey{Generation: gdb.Generation(),
+		Dataset:  gextest.OpenDataset,
+		ExprType: exprType.PublicId,
+		Genes:    []string{"MYC"},
+		IsAdmin:  true}).String()
+
+	_, err = results.Get(context.Background(), key)
+
+	if !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("got %v, want the results not to be cached", err)
+	}
+}
+
+// Catalogs sharing a cache can have datasets with the same ids so keep
+// their results apart, and dropping one's results keeps the other's
+func TestResultsByCatalog(t *testing.T) {
+	results := cache.NewMemory(1 << 20)
+
+	keys := map[string]string{}
+	catalogs := map[string]*gex.GexDB{}
+
+	for _, name := range []string{"public", "private"} {
+		gdb := gextest.Open(t)
+
+		gdb.SetName(name)
+		gdb.SetResultsCache(results, gex.DefaultResultsTTL)
+
+		catalogs[name] = gdb
+
+		exprType, err := gdb.ExprType("tpm")
+		check(t, "ExprType", err)
+
+		key := (&gex.ResultsKey{Catalog: name,
+			Generation: gdb.Generation(),
+			Dataset:    gextest.OpenDataset,
+			ExprType:   exprType.PublicId,
+			Genes:      []string{"MYC"},
+			IsAdmin:    true}).String()
+
+		_, err = results.Get(context.Background(), key)
+
+		if !errors.Is(err, cache.ErrMiss) {
+			t.Fatalf("%s: got %v before searching, want a miss", name, err)
+		}
+
+		genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+		check(t, "GenomeTechnology", err)
+
+		probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+		check(t, "FindProbes", err)
+
+		_, err = gdb.CachedExpression([]string{"MYC"}, gextest.OpenDataset, exprType, probes, true, nil, nil)
+		check(t, "CachedExpression", err)
+
+		keys[name] = key
+	}
+
+	check(t, "InvalidateResults", catalogs["public"].InvalidateResults())
+
+	_, err := results.Get(context.Background(), keys["public"])
+
+	if !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("public: got %v, want the cached results to be dropped", err)
+	}
+
+	_, err = results.Get(context.Background(), keys["private"])
+	check(t, "private cached results", err)
+
+	check(t, "InvalidateDataset", catalogs["private"].InvalidateDataset(gextest.OpenDataset))
+
+	_, err = results.Get(context.Background(), keys["private"])
+
+	if !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("private: got %v, want the cached results to be dropped", err)
+	}
+}
+
+// Results can be changed, e.g. to log transform them, without changing
+// the cached blocks they were read from
+func TestExpressionResultsAreCopies(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"MYC"}, nil)
+	check(t, "FindProbes", err)
+
+	// admins see every sample so no columns are left out
+	for range 2 {
+		ret, err := gdb.CachedExpression([]string{"MYC"}, gextest.OpenDataset, exprType, probes, true, nil, nil)
+		check(t, "CachedExpression", err)
+
+		got := ret.Probes[0].Values
+		want(t, "values", values(got), values([]float32{20, 21, 22, 23}))
+
+		for i := range got {
+			got[i] = -1
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"errors"
+	"time"
+
+	"github.com/antonybholmes/go-gex/audit"
+	"github.com/antonybholmes/go-web"
+	"github.com/antonybholmes/go-web/auth/token"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrInvalidDate = errors.New("dates must be YYYY-MM-DD or RFC3339")
+
+// Lists audit records for admins, optionally filtered by user, dataset
+// and a date range, e.g. ?user=...&dataset=...&from=2026-01-01&to=2026-02-01.
+// The to date is exclusive.
+func AuditRoute(c *gin.Context) {
+	adminRoute(c, func(c *gin.Context, user *token.AuthUserJwtClaims) {
+		from, err := parseDate(c.Query("from"))
+
+		if err != nil {
+			web.BadReqResp(c, err)
+			return
+		}
+
+		to, err := parseDate(c.Query("to"))
+
+		if err != nil {
+			web.BadReqResp(c, err)
+			return
+		}
+
+		records, err := audit.Find(&audit.Query{User: c.Query("user"),
+			Dataset: c.Query("dataset"),
+			From:    from,
+			To:      to,
+			N:       web.ParseN(c, audit.DefaultQueryN)})
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		web.MakeDataResp(c, "", records)
+	})
+}
+
+// empty dates are the zero time which matches everything
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse(time.RFC3339, s)
+
+	if err != nil {
+		return time.Time{}, ErrInvalidDate
+	}
+
+	return t, nil
+}
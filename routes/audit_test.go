@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/antonybholmes/go-gex/audit"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-web/auth"
+)
+
+// Keeps records in memory or fails to write them
+type testLogger struct {
+	records []*audit.Record
+	err     error
+}
+
+func (l *testLogger) Log(record *audit.Record) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	l.records = append(l.records, record)
+
+	return nil
+}
+
+func (l *testLogger) Query(query *audit.Query) ([]*audit.Record, error) {
+	return l.records, nil
+}
+
+func (l *testLogger) Close() error {
+	return nil
+}
+
+// Expression values are only returned once the access is recorded
+func TestExpressionAudited(t *testing.T) {
+	r, name := newTestRouter(t, gextest.Open(t))
+
+	t.Cleanup(func() { audit.InitAudit(nil) })
+
+	admin := []string{auth.AdminPermission}
+	params := GexParams{Genes: []string{"MYC"}, Datasets: []string{gextest.OpenDataset}}
+
+	logger := &testLogger{}
+	audit.InitAudit(logger)
+
+	w := request(t, r, name, http.MethodPost, "/expression/tpm", admin, params)
+
+	if results := decode[[]any](t, w); len(results) != 1 || !strings.Contains(w.Body.String(), `"values"`) {
+		t.Errorf("got %s, want the values of one dataset", w.Body.String())
+	}
+
+	if len(logger.records) != 1 || len(logger.records[0].Datasets) != 1 || logger.records[0].Datasets[0] != gextest.OpenDataset {
+		t.Errorf("got records %+v, want one of %s", logger.records, gextest.OpenDataset)
+	}
+
+	audit.InitAudit(&testLogger{err: errors.New("audit log is full")})
+
+	w = request(t, r, name, http.MethodPost, "/expression/tpm", admin, params)
+
+	if w.Code == http.StatusOK {
+		t.Errorf("got %d, want an error", w.Code)
+	}
+
+	if strings.Contains(w.Body.String(), `"values"`) {
+		t.Errorf("got values without an audit record: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/gexdb"
+	"github.com/antonybholmes/go-web"
+	"github.com/antonybholmes/go-web/auth/token"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// the route param that selects a catalog when routes are grouped
+	// under a prefix such as /gex/:catalog
+	CatalogParam = "catalog"
+
+	// selects a catalog when there is no prefix
+	CatalogHeader = "X-Gex-Catalog"
+
+	// where CatalogMiddleware keeps the catalog a request uses
+	catalogKey = "gex.catalog"
+)
+
+// Holds the catalog a request selects until the request finishes so
+// that if the catalog is reloaded meanwhile the request can carry on
+// with the old one. Routes acquire the catalog themselves without it,
+// but only while their handler runs, so middleware after the route,
+// e.g. to log its results, should not use the catalog.
+func CatalogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		gdb, err := gexdb.Acquire(catalogName(c))
+
+		// the route sends the error if it needs a catalog
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		defer gdb.Release()
+
+		c.Set(catalogKey, gdb)
+
+		c.Next()
+	}
+}
+
+// Returns the catalog a request asks for by route param or header,
+// or the default catalog if it names none, with a function to call
+// when the route is done with it. Unless CatalogMiddleware already
+// holds the catalog it is acquired so a reload cannot close it until
+// release is called. If the catalog cannot be found an error response
+// is sent and false returned.
+func catalog(c *gin.Context) (*gex.GexDB, func(), bool) {
+	if gdb, ok := c.Get(catalogKey); ok {
+		return gdb.(*gex.GexDB), func() {}, true
+	}
+
+	gdb, err := gexdb.Acquire(catalogName(c))
+
+	if err != nil {
+		if errors.Is(err, gexdb.ErrCatalogNotFound) {
+			web.ErrorResp(c, http.StatusNotFound, err)
+		} else {
+			web.ErrorResp(c, http.StatusServiceUnavailable, err)
+		}
+
+		return nil, nil, false
+	}
+
+	return gdb, gdb.Release, true
+}
+
+// Lists the catalogs that can be selected
+func CatalogsRoute(c *gin.Context) {
+	web.MakeDataResp(c, "", gexdb.Names())
+}
+
+// Opens the catalog a request selects again, e.g. after a new release
+// has been copied into place, and switches to it without dropping
+// requests
+func ReloadCatalogRoute(c *gin.Context) {
+	adminRoute(c, func(c *gin.Context, user *token.AuthUserJwtClaims) {
+		name := catalogName(c)
+
+		err := gexdb.Reload(name)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, gexdb.ErrCatalogNotFound),
+				errors.Is(err, gexdb.ErrNotInitialized):
+				web.ErrorResp(c, http.StatusNotFound, err)
+			case errors.Is(err, gexdb.ErrCannotReload):
+				web.ErrorResp(c, http.StatusConflict, err)
+			default:
+				c.Error(err)
+			}
+
+			return
+		}
+
+		web.MakeOkResp(c, "catalog reloaded")
+	})
+}
+
+func catalogName(c *gin.Context) string {
+	name := c.Param(CatalogParam)
+
+	if name == "" {
+		name = c.GetHeader(CatalogHeader)
+	}
+
+	return name
+}
@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/gin-gonic/gin"
+)
+
+// A route holds the catalog while it runs even without
+// CatalogMiddleware so a reload waits for it
+func TestCatalogAcquiredWithoutMiddleware(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	_, name := newTestRouter(t, gdb)
+
+	r := gin.New()
+
+	inUse := false
+
+	r.GET("/", func(c *gin.Context) {
+		_, release, ok := catalog(c)
+
+		if !ok {
+			return
+		}
+
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		inUse = gdb.Drain(ctx) != nil
+
+		c.Status(http.StatusOK)
+	})
+
+	w := request(t, r, name, http.MethodGet, "/", nil, nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	if !inUse {
+		t.Error("the catalog could be drained while a route was using it")
+	}
+
+	err := gdb.Drain(context.Background())
+
+	if err != nil {
+		t.Errorf("the catalog was not released: %s", err)
+	}
+}
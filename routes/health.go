@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-web"
+	"github.com/gin-gonic/gin"
+)
+
+// How long a readiness check is reused for, since it reads the header
+// of every expression file
+const ReadyCheckInterval = 30 * time.Second
+
+type readyCheck struct {
+	report  *gex.CheckReport
+	checked time.Time
+}
+
+var (
+	readyLock sync.Mutex
+	// the last check of each catalog
+	readyChecks = make(map[*gex.GexDB]*readyCheck)
+)
+
+// Responds ok if the catalog database can be reached, for use as a
+// liveness probe
+func HealthzRoute(c *gin.Context) {
+	gdb, release, ok := catalog(c)
+
+	if !ok {
+		return
+	}
+
+	defer release()
+
+	err := gdb.Ping()
+
+	if err != nil {
+		web.ErrorResp(c, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	web.MakeOkResp(c, "ok")
+}
+
+// Responds with a report of any problems with the catalog and its
+// expression files, using status 503 if there are any so the service
+// is not sent traffic it cannot serve
+func ReadyzRoute(c *gin.Context) {
+	gdb, release, ok := catalog(c)
+
+	if !ok {
+		return
+	}
+
+	defer release()
+
+	report, err := checkReady(gdb)
+
+	if err != nil {
+		web.ErrorResp(c, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	if !report.Ok {
+		c.JSON(http.StatusServiceUnavailable, web.DataResp{
+			StatusMessageResp: web.StatusMessageResp{
+				Status:  http.StatusServiceUnavailable,
+				Message: "not ready"},
+			Data: report})
+		return
+	}
+
+	web.MakeDataResp(c, "", report)
+}
+
+func checkReady(gdb *gex.GexDB) (*gex.CheckReport, error) {
+	readyLock.Lock()
+	defer readyLock.Unlock()
+
+	if check, ok := readyChecks[gdb]; ok && time.Since(check.checked) < ReadyCheckInterval {
+		return check.report, nil
+	}
+
+	report, err := gdb.Check()
+
+	if err != nil {
+		return nil, errors.Join(errors.New("catalog could not be checked"), err)
+	}
+
+	// drop the checks of catalogs that have been reloaded
+	for g, check := range readyChecks {
+		if time.Since(check.checked) >= ReadyCheckInterval {
+			delete(readyChecks, g)
+		}
+	}
+
+	readyChecks[gdb] = &readyCheck{report: report, checked: time.Now()}
+
+	return report, nil
+}
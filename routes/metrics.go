@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/antonybholmes/go-gex/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	httpRequests = metrics.NewCounter("gex_http_requests_total",
+		"Requests by route, method and status.",
+		"route", "method", "status")
+
+	httpRequestSeconds = metrics.NewHistogram("gex_http_request_seconds",
+		"Time to respond to requests by route.",
+		nil,
+		"route", "method")
+)
+
+// Counts and times every request. Requests are labelled by their route
+// pattern, e.g. /datasets/:id, so ids do not create new series.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+
+		if route == "" {
+			route = "unmatched"
+		}
+
+		httpRequests.Inc(route, c.Request.Method, strconv.Itoa(c.Writer.Status()))
+		httpRequestSeconds.ObserveSince(start, route, c.Request.Method)
+	}
+}
+
+// Serves the metrics in the Prometheus text format, usually on /metrics
+func MetricsRoute(c *gin.Context) {
+	metrics.Default.Handler().ServeHTTP(c.Writer, c.Request)
+}
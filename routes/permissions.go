@@ -0,0 +1,192 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-web"
+	"github.com/antonybholmes/go-web/auth"
+	"github.com/antonybholmes/go-web/auth/token"
+	"github.com/antonybholmes/go-web/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type PermissionParams struct {
+	// either the name or public id of a permission
+	Permission string `json:"permission"`
+}
+
+// Routes for managing which permissions guard which datasets are only
+// for admins
+func adminRoute(c *gin.Context, r func(c *gin.Context, user *token.AuthUserJwtClaims)) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		if !isAdmin {
+			web.ForbiddenResp(c, auth.ErrUserIsNotAdmin)
+			return
+		}
+
+		r(c, user)
+	})
+}
+
+// An admin route that changes the catalog the request selects
+func adminCatalogRoute(c *gin.Context, r func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims)) {
+	adminRoute(c, func(c *gin.Context, user *token.AuthUserJwtClaims) {
+		gdb, release, ok := catalog(c)
+
+		if !ok {
+			return
+		}
+
+		defer release()
+
+		r(c, gdb, user)
+	})
+}
+
+// Maps errors from permission changes to suitable responses
+func permissionErrorResp(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gex.ErrDatasetNotFound),
+		errors.Is(err, gex.ErrSampleNotFound),
+		errors.Is(err, gex.ErrPermissionNotFound):
+		web.ErrorResp(c, http.StatusNotFound, err)
+	case errors.Is(err, gex.ErrPermissionExists),
+		errors.Is(err, gex.ErrReadOnly):
+		web.ErrorResp(c, http.StatusConflict, err)
+	default:
+		c.Error(err)
+	}
+}
+
+func PermissionsRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		permissions, err := gdb.Permissions()
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		web.MakeDataResp(c, "", permissions)
+	})
+}
+
+func CreatePermissionRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		var params PermissionParams
+
+		err := c.Bind(&params)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		permission, err := gdb.CreatePermission(params.Permission)
+
+		if err != nil {
+			permissionErrorResp(c, err)
+			return
+		}
+
+		web.MakeDataResp(c, "", permission)
+	})
+}
+
+func DatasetPermissionsRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		permissions, err := gdb.DatasetPermissions(c.Param("id"))
+
+		if err != nil {
+			permissionErrorResp(c, err)
+			return
+		}
+
+		web.MakeDataResp(c, "", permissions)
+	})
+}
+
+func GrantDatasetPermissionRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		var params PermissionParams
+
+		err := c.Bind(&params)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		err = gdb.GrantDatasetPermission(c.Param("id"), params.Permission)
+
+		if err != nil {
+			permissionErrorResp(c, err)
+			return
+		}
+
+		web.MakeOkResp(c, "")
+	})
+}
+
+func RevokeDatasetPermissionRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		err := gdb.RevokeDatasetPermission(c.Param("id"), c.Param("permission"))
+
+		if err != nil {
+			permissionErrorResp(c, err)
+			return
+		}
+
+		web.MakeOkResp(c, "")
+	})
+}
+
+func SamplePermissionsRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		permissions, err := gdb.SamplePermissions(c.Param("id"))
+
+		if err != nil {
+			permissionErrorResp(c, err)
+			return
+		}
+
+		web.MakeDataResp(c, "", permissions)
+	})
+}
+
+func GrantSamplePermissionRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		var params PermissionParams
+
+		err := c.Bind(&params)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		err = gdb.GrantSamplePermission(c.Param("id"), params.Permission)
+
+		if err != nil {
+			permissionErrorResp(c, err)
+			return
+		}
+
+		web.MakeOkResp(c, "")
+	})
+}
+
+func RevokeSamplePermissionRoute(c *gin.Context) {
+	adminCatalogRoute(c, func(c *gin.Context, gdb *gex.GexDB, user *token.AuthUserJwtClaims) {
+		err := gdb.RevokeSamplePermission(c.Param("id"), c.Param("permission"))
+
+		if err != nil {
+			permissionErrorResp(c, err)
+			return
+		}
+
+		web.MakeOkResp(c, "")
+	})
+}
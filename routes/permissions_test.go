@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-web/auth"
+)
+
+// Changing the permissions of a read only catalog is a conflict with
+// how it was opened rather than a server error
+func TestReadOnlyPermissions(t *testing.T) {
+	path := gextest.NewBaseline(t)
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gdb.Close()
+
+	gdb, err = gex.OpenGexDB(path, &gex.Options{ReadOnly: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer gdb.Close()
+
+	r, name := newTestRouter(t, gdb)
+
+	r.POST("/permissions", CreatePermissionRoute)
+	r.POST("/datasets/:id/permissions", GrantDatasetPermissionRoute)
+	r.DELETE("/datasets/:id/permissions/:permission", RevokeDatasetPermissionRoute)
+	r.POST("/samples/:id/permissions", GrantSamplePermissionRoute)
+
+	admin := []string{auth.AdminPermission}
+	params := PermissionParams{Permission: "reviewers"}
+
+	for _, test := range []struct {
+		method string
+		path   string
+	}{{method: http.MethodPost, path: "/permissions"},
+		{method: http.MethodPost, path: "/datasets/" + gextest.SecretDataset + "/permissions"},
+		{method: http.MethodDelete, path: "/datasets/" + gextest.OpenDataset + "/permissions/" + gextest.ViewPermission},
+		{method: http.MethodPost, path: "/samples/" + gextest.HiddenSample + "/permissions"}} {
+		w := request(t, r, name, test.method, test.path, admin, params)
+
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s %s: got %d, want %d: %s", test.method, test.path, w.Code, http.StatusConflict, w.Body.String())
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package routes
 
 import (
+	"database/sql"
 	"errors"
+	"net/http"
+	"slices"
+	"time"
 
 	"github.com/antonybholmes/go-gex"
-	"github.com/antonybholmes/go-gex/gexdb"
+	"github.com/antonybholmes/go-gex/audit"
+	"github.com/antonybholmes/go-sys/db"
 	"github.com/antonybholmes/go-sys/log"
 	"github.com/antonybholmes/go-web"
 	"github.com/antonybholmes/go-web/auth/token"
@@ -12,12 +17,22 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const DefaultSamplesPageSize = 100
+
 type GexParams struct {
 	//Genome     string   `json:"genome"`
 	//Technology string   `json:"technology"`
 	//ExprType   string   `json:"type"` // use pointer so we can check for nil
-	Genes    []string `json:"genes"`
-	Datasets []string `json:"datasets"`
+	// probe or gene ids, loci such as chr8:127,700,000-129,000,000 or
+	// selectors such as group:Immunoglobulin* and biotype:lncRNA
+	Genes []string `json:"genes"`
+	// gene set ids or names which are expanded to their member genes
+	GeneSets []string `json:"geneSets"`
+	// gene sets to score in each sample, which are returned as
+	// extra rows after the genes
+	Scores      []string `json:"scores"`
+	ScoreMethod string   `json:"scoreMethod"`
+	Datasets    []string `json:"datasets"`
 }
 
 func parseParamsFromPost(c *gin.Context) (*GexParams, error) {
@@ -34,8 +49,15 @@ func parseParamsFromPost(c *gin.Context) (*GexParams, error) {
 }
 
 func GenomesRoute(c *gin.Context) {
+	gdb, release, ok := catalog(c)
+
+	if !ok {
+		return
+	}
+
+	defer release()
 
-	types, err := gexdb.Genomes()
+	types, err := gdb.Genomes()
 
 	if err != nil {
 		c.Error(err)
@@ -46,8 +68,15 @@ func GenomesRoute(c *gin.Context) {
 }
 
 func TechnologiesRoute(c *gin.Context) {
+	gdb, release, ok := catalog(c)
 
-	technologies, err := gexdb.Technologies() //gexdbcache.Technologies()
+	if !ok {
+		return
+	}
+
+	defer release()
+
+	technologies, err := gdb.Technologies() //gexdbcache.Technologies()
 
 	if err != nil {
 		c.Error(err)
@@ -78,13 +107,76 @@ func TechnologiesRoute(c *gin.Context) {
 // 	})
 // }
 
+func GeneSetsRoute(c *gin.Context) {
+	gdb, release, ok := catalog(c)
+
+	if !ok {
+		return
+	}
+
+	defer release()
+
+	genome := c.Query("genome")
+
+	geneSets, err := gdb.GeneSets(genome)
+
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	web.MakeDataResp(c, "", geneSets)
+}
+
+func SearchGeneSetsRoute(c *gin.Context) {
+	gdb, release, ok := catalog(c)
+
+	if !ok {
+		return
+	}
+
+	defer release()
+
+	genome := c.Query("genome")
+	q := c.Query("q")
+
+	if q == "" {
+		web.BadReqResp(c, errors.New("search query is required"))
+		return
+	}
+
+	geneSets, err := gdb.SearchGeneSets(genome, q, web.ParseN(c, gex.DefaultGeneSetSearchN))
+
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	web.MakeDataResp(c, "", geneSets)
+}
+
 func DatasetsRoute(c *gin.Context) {
 	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		gdb, release, ok := catalog(c)
+
+		if !ok {
+			return
+		}
+
+		defer release()
 
 		genome := c.Query("genome")
 		technology := c.Query("technology")
 
-		datasets, err := gexdb.Datasets(genome, technology, isAdmin, user.Permissions)
+		var datasets []*gex.Dataset
+		var err error
+
+		// summaries leave out the samples which can be large
+		if web.ParseBoolParam(c, "summary", false) {
+			datasets, err = gdb.DatasetSummaries(genome, technology, user.Permissions, isAdmin)
+		} else {
+			datasets, err = gdb.Datasets(genome, technology, user.Permissions, isAdmin)
+		}
 
 		if err != nil {
 			c.Error(err)
@@ -95,12 +187,100 @@ func DatasetsRoute(c *gin.Context) {
 	})
 }
 
+func DatasetRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		gdb, release, ok := catalog(c)
+
+		if !ok {
+			return
+		}
+
+		defer release()
+
+		datasetId := c.Param("id")
+
+		dataset, err := gdb.Dataset(datasetId, isAdmin, user.Permissions)
+
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				web.ErrorResp(c, http.StatusNotFound, errors.New("dataset not found"))
+				return
+			}
+
+			c.Error(err)
+			return
+		}
+
+		web.MakeDataResp(c, "", dataset)
+	})
+}
+
+func DatasetSamplesRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		gdb, release, ok := catalog(c)
+
+		if !ok {
+			return
+		}
+
+		defer release()
+
+		datasetId := c.Param("id")
+
+		page := web.ParseNumParam(c, "page", 1)
+		n := web.ParseN(c, DefaultSamplesPageSize)
+
+		samples, err := gdb.DatasetSamples(datasetId, page, n, isAdmin, user.Permissions)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		web.MakeDataResp(c, "", samples)
+	})
+}
+
+func MetadataSchemaRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		gdb, release, ok := catalog(c)
+
+		if !ok {
+			return
+		}
+
+		defer release()
+
+		datasetId := c.Param("id")
+
+		schema, err := gdb.MetadataSchema(datasetId, isAdmin, user.Permissions)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		web.MakeDataResp(c, "", schema)
+	})
+}
+
 func ExpressionRoute(c *gin.Context) {
 	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		gdb, release, ok := catalog(c)
+
+		if !ok {
+			return
+		}
+
+		defer release()
+
 		//genome := c.Query("genome")
 		//technology := c.Query("technology")
 		t := c.Param("type")
 
+		start := time.Now()
+		var timings gex.Timings
+
 		params, err := parseParamsFromPost(c)
 
 		if err != nil {
@@ -116,10 +296,24 @@ func ExpressionRoute(c *gin.Context) {
 			return
 		}
 
+		if len(params.Datasets) == 0 {
+			web.BadReqResp(c, errors.New("at least one dataset is required"))
+			return
+		}
+
+		limits := gdb.Limits().For(isAdmin)
+
+		err = checkLimits(params, limits)
+
+		if err != nil {
+			limitErrorResp(c, err)
+			return
+		}
+
 		results := make([]*gex.SearchResults, 0, len(params.Datasets))
 
 		// find the expression type desired
-		exprType, err := gexdb.ExprType(t)
+		exprType, err := gdb.ExprType(t)
 
 		if err != nil {
 			web.BadReqResp(c, errors.New("invalid expr type"))
@@ -127,7 +321,7 @@ func ExpressionRoute(c *gin.Context) {
 		}
 
 		// determin genome and technology from first dataset
-		genome, technology, err := gexdb.GenomeTechnology(params.Datasets[0])
+		genome, technology, err := gdb.GenomeTechnology(params.Datasets[0], isAdmin, user.Permissions)
 
 		if err != nil {
 			log.Debug().Msgf("not able to determine genome/technology from dataset: %v", err)
@@ -135,17 +329,83 @@ func ExpressionRoute(c *gin.Context) {
 			return
 		}
 
+		genes := params.Genes
+
+		// add the genes from any gene sets after the genes the
+		// user listed explicitly
+		if len(params.GeneSets) > 0 {
+			setGenes, err := gdb.GeneSetGenes(genome, params.GeneSets)
+
+			if err != nil {
+				log.Debug().Msgf("not able to expand gene sets: %v", err)
+				web.BadReqResp(c, errors.New("invalid gene sets"))
+				return
+			}
+
+			genes = append(slices.Clone(genes), setGenes...)
+		}
+
 		// match the genes to probes using either probe or gene ids
-		probes, err := gexdb.FindProbes(genome, technology, params.Genes)
+		findStart := time.Now()
+		probes, err := gdb.FindProbes(genome, technology, genes, limits)
+		findProbes := time.Since(findStart)
 
 		if err != nil {
+			if errors.Is(err, gex.ErrLimitExceeded) {
+				limitErrorResp(c, err)
+				return
+			}
+
+			if errors.Is(err, gex.ErrInvalidLocus) || errors.Is(err, gex.ErrInvalidSelector) {
+				web.BadReqResp(c, err)
+				return
+			}
+
 			web.BadReqResp(c, errors.New("invalid genes"))
 			return
 		}
 
+		scoreMethod, err := gex.ParseScoreMethod(params.ScoreMethod)
+
+		if err != nil {
+			web.BadReqResp(c, err)
+			return
+		}
+
+		// find the genes in each set we want to score
+		scoreSets := make([]*gex.GeneSet, 0, len(params.Scores))
+		scoreProbes := make([][]*gex.Probe, 0, len(params.Scores))
+
+		for _, id := range params.Scores {
+			geneSet, err := gdb.GeneSet(genome, id)
+
+			if err != nil {
+				log.Debug().Msgf("not able to find gene set to score: %v", err)
+				web.BadReqResp(c, errors.New("invalid gene sets"))
+				return
+			}
+
+			// sets are scored as one row so can have more probes than
+			// a search, but each is read so they are still limited
+			setProbes, err := gdb.FindProbes(genome, technology, geneSet.GeneIds(), limits.ForScoring())
+
+			if err != nil {
+				if errors.Is(err, gex.ErrLimitExceeded) {
+					limitErrorResp(c, err)
+					return
+				}
+
+				web.BadReqResp(c, errors.New("invalid gene sets"))
+				return
+			}
+
+			scoreSets = append(scoreSets, geneSet)
+			scoreProbes = append(scoreProbes, setProbes)
+		}
+
 		// search each dataset and gene in order user specified
 		for _, datasetId := range params.Datasets {
-			ret, err := gexdb.Expression(datasetId, exprType, probes, isAdmin, user.Permissions)
+			ret, err := gdb.CachedExpression(genes, datasetId, exprType, probes, isAdmin, user.Permissions, &timings)
 
 			// if there is an error accessing a dataset, we skip it and continue with the others
 			if err != nil {
@@ -153,6 +413,31 @@ func ExpressionRoute(c *gin.Context) {
 				continue
 			}
 
+			for i, geneSet := range scoreSets {
+				score, err := gdb.ScoreGeneSet(datasetId, exprType, geneSet, scoreProbes[i], scoreMethod, isAdmin, user.Permissions, limits)
+
+				// the user should know to score a smaller dataset
+				if errors.Is(err, gex.ErrLimitExceeded) {
+					limitErrorResp(c, err)
+					return
+				}
+
+				// a set with nothing to score in a dataset should not
+				// prevent the genes from being returned, but a file that
+				// cannot be read should not be hidden by leaving it out
+				if errors.Is(err, gex.ErrNoScorableGenes) {
+					log.Debug().Msgf("not able to score gene set: %s %s %v", datasetId, geneSet.Name, err)
+					continue
+				}
+
+				if err != nil {
+					c.Error(err)
+					return
+				}
+
+				ret.Probes = append(ret.Probes, score)
+			}
+
 			results = append(results, ret)
 		}
 
@@ -185,6 +470,61 @@ func ExpressionRoute(c *gin.Context) {
 		// 	}
 		// }
 
+		// record which datasets the user actually saw. If that fails we
+		// do not return the data since access must always be auditable
+		err = auditExpression(user, exprType, results, len(genes))
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		encodeStart := time.Now()
 		web.MakeDataResp(c, "", results)
+
+		// shows whether a slow search was spent in the catalog, reading
+		// files or encoding the response
+		log.Debug().
+			Dur("findProbes", findProbes).
+			Dur("sql", timings.SQL).
+			Dur("read", timings.Read).
+			Int64("bytes", timings.Bytes).
+			Int("cachedBlocks", timings.CachedBlocks).
+			Dur("encode", time.Since(encodeStart)).
+			Dur("total", time.Since(start)).
+			Int("datasets", len(results)).
+			Int("probes", len(probes)).
+			Msg("expression timings")
 	})
 }
+
+// Checks the lists in a request are within the user's limits before
+// anything is looked up
+func checkLimits(params *GexParams, limits *gex.RequestLimits) error {
+	return errors.Join(gex.CheckLimit("datasets", len(params.Datasets), limits.Datasets),
+		gex.CheckLimit("genes", len(params.Genes), limits.Genes),
+		gex.CheckLimit("gene sets", len(params.GeneSets)+len(params.Scores), limits.GeneSets))
+}
+
+// Tells the user which limit they exceeded
+func limitErrorResp(c *gin.Context, err error) {
+	web.ErrorResp(c, http.StatusRequestEntityTooLarge, err)
+}
+
+func auditExpression(user *token.AuthUserJwtClaims,
+	exprType *db.Entity,
+	results []*gex.SearchResults,
+	genes int) error {
+
+	if len(results) == 0 {
+		return nil
+	}
+
+	datasets := make([]string, 0, len(results))
+
+	for _, result := range results {
+		datasets = append(datasets, result.Dataset.PublicId)
+	}
+
+	return audit.Log(audit.NewRecord(user, audit.ActionExpression, datasets, exprType.Name, genes))
+}
@@ -0,0 +1,359 @@
+package routes
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/gexdb"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-gex/store"
+	"github.com/antonybholmes/go-web/auth"
+	"github.com/antonybholmes/go-web/auth/token"
+	"github.com/antonybholmes/go-web/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// the permissions of the user a test request is made as, comma
+// separated
+const permissionsHeader = "X-Test-Permissions"
+
+var catalogs atomic.Int64
+
+type testResp struct {
+	Status int             `json:"status"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Registers a catalog under a new name and returns a router with the
+// gex routes that selects it
+func newTestRouter(t *testing.T, gdb *gex.GexDB) (*gin.Engine, string) {
+	t.Helper()
+
+	name := fmt.Sprintf("test%d", catalogs.Add(1))
+
+	err := gexdb.Register(name, gdb)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { gexdb.Remove(name) })
+
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+
+	r.Use(middleware.ErrorHandlerMiddleware())
+
+	// stands in for the jwt middleware
+	r.Use(func(c *gin.Context) {
+		permissions := []string{}
+
+		if header := c.GetHeader(permissionsHeader); header != "" {
+			permissions = strings.Split(header, ",")
+		}
+
+		c.Set("user", &token.AuthUserJwtClaims{Permissions: permissions})
+
+		c.Next()
+	})
+
+	r.Use(CatalogMiddleware())
+
+	r.GET("/datasets", DatasetsRoute)
+	r.GET("/datasets/:id", DatasetRoute)
+	r.GET("/datasets/:id/samples", DatasetSamplesRoute)
+	r.GET("/datasets/:id/metadata", MetadataSchemaRoute)
+	r.POST("/expression/:type", ExpressionRoute)
+
+	return r, name
+}
+
+func request(t *testing.T, r *gin.Engine, catalog string, method string, path string, permissions []string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader *bytes.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(CatalogHeader, catalog)
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(permissions) > 0 {
+		req.Header.Set(permissionsHeader, strings.Join(permissions, ","))
+	}
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+// Decodes the data of a response, failing the test if it was not 200
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	var ret T
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp testResp
+
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if err == nil {
+		err = json.Unmarshal(resp.Data, &ret)
+	}
+
+	if err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+
+	return ret
+}
+
+// Users without a permission that a dataset has must not be able to
+// tell from any route that it or its samples exist
+func TestUnprivilegedRoutes(t *testing.T) {
+	r, name := newTestRouter(t, gextest.Open(t))
+
+	// users with no permissions at all are refused outright
+	for _, path := range []string{"/datasets?genome=human&technology=rna-seq",
+		"/datasets/" + gextest.OpenDataset,
+		"/datasets/" + gextest.OpenDataset + "/samples",
+		"/datasets/" + gextest.OpenDataset + "/metadata"} {
+		w := request(t, r, name, http.MethodGet, path, nil, nil)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s with no permissions: got %d, want %d", path, w.Code, http.StatusForbidden)
+		}
+	}
+
+	for test, permissions := range map[string][]string{"unrelated": {"other"},
+		// sample permissions do not give access to the dataset
+		"consortium": {gextest.ConsortiumPermission}} {
+		t.Run(test, func(t *testing.T) {
+			unprivileged(t, r, name, permissions)
+		})
+	}
+
+	// the secret dataset is hidden from users who can see the other
+	hidden := request(t, r, name, http.MethodGet, "/datasets/"+gextest.SecretDataset, []string{gextest.ViewPermission}, nil)
+	missing := request(t, r, name, http.MethodGet, "/datasets/no-such-dataset", []string{gextest.ViewPermission}, nil)
+
+	if hidden.Code != missing.Code || hidden.Body.String() != missing.Body.String() {
+		t.Errorf("a hidden dataset gave %d %s but a missing one %d %s", hidden.Code, hidden.Body.String(), missing.Code, missing.Body.String())
+	}
+}
+
+func unprivileged(t *testing.T, r *gin.Engine, name string, permissions []string) {
+	t.Helper()
+
+	for _, summary := range []string{"false", "true"} {
+		datasets := decode[[]*gex.Dataset](t, request(t, r, name, http.MethodGet, "/datasets?genome=human&technology=rna-seq&summary="+summary, permissions, nil))
+
+		if len(datasets) != 0 {
+			t.Errorf("summary=%s: got %d datasets, want 0", summary, len(datasets))
+		}
+	}
+
+	for _, datasetId := range []string{gextest.OpenDataset, gextest.SecretDataset} {
+		w := request(t, r, name, http.MethodGet, "/datasets/"+datasetId, permissions, nil)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want %d", datasetId, w.Code, http.StatusNotFound)
+		}
+
+		page := decode[gex.SamplesPage](t, request(t, r, name, http.MethodGet, "/datasets/"+datasetId+"/samples", permissions, nil))
+
+		if len(page.Samples) != 0 || page.Total != 0 {
+			t.Errorf("%s: got %d samples of %d, want none", datasetId, len(page.Samples), page.Total)
+		}
+
+		schema := decode[[]*gex.MetadataField](t, request(t, r, name, http.MethodGet, "/datasets/"+datasetId+"/metadata", permissions, nil))
+
+		if len(schema) != 0 {
+			t.Errorf("%s: got %d metadata fields, want 0", datasetId, len(schema))
+		}
+
+		w = request(t, r, name, http.MethodPost, "/expression/tpm", permissions, GexParams{Genes: []string{"MYC"}, Datasets: []string{datasetId}})
+
+		if w.Code == http.StatusOK {
+			t.Errorf("%s: got expression %s", datasetId, w.Body.String())
+		}
+	}
+}
+
+// Samples a user does not have a permission for are left out of every
+// list and count
+func TestHiddenSampleRoutes(t *testing.T) {
+	r, name := newTestRouter(t, gextest.Open(t))
+
+	viewer := []string{gextest.ViewPermission}
+
+	for _, path := range []string{"/datasets?genome=human&technology=rna-seq",
+		"/datasets?genome=human&technology=rna-seq&summary=true",
+		"/datasets/" + gextest.OpenDataset,
+		"/datasets/" + gextest.OpenDataset + "/samples"} {
+		w := request(t, r, name, http.MethodGet, path, viewer, nil)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", path, w.Code, w.Body.String())
+		}
+
+		body := w.Body.String()
+
+		if strings.Contains(body, `"O4"`) || strings.Contains(body, gextest.HiddenSample) {
+			t.Errorf("%s shows a hidden sample: %s", path, body)
+		}
+
+		if strings.Contains(body, gextest.SecretDataset) {
+			t.Errorf("%s shows a hidden dataset: %s", path, body)
+		}
+	}
+
+	dataset := decode[gex.Dataset](t, request(t, r, name, http.MethodGet, "/datasets/"+gextest.OpenDataset, viewer, nil))
+
+	if dataset.SampleCount != 3 {
+		t.Errorf("got a sample count of %d, want 3", dataset.SampleCount)
+	}
+
+	page := decode[gex.SamplesPage](t, request(t, r, name, http.MethodGet, "/datasets/"+gextest.OpenDataset+"/samples", viewer, nil))
+
+	if page.Total != 3 {
+		t.Errorf("got a total of %d samples, want 3", page.Total)
+	}
+}
+
+// A gene set that cannot be scored because its file cannot be read is
+// an error rather than a row quietly left out
+func TestScoreReadError(t *testing.T) {
+	admin := []string{auth.AdminPermission}
+	params := GexParams{Genes: []string{"BCL6"},
+		Scores:      []string{gextest.GeneSet},
+		ScoreMethod: gex.ScoreMethodZScore,
+		Datasets:    []string{gextest.OpenDataset}}
+
+	r, name := newTestRouter(t, gextest.Open(t))
+
+	results := decode[[]*gex.SearchResults](t, request(t, r, name, http.MethodPost, "/expression/tpm", admin, params))
+
+	if len(results) != 1 || len(results[0].Probes) != 2 {
+		t.Fatalf("got %v, want BCL6 and the score of the set", results)
+	}
+
+	// a new catalog so no blocks are cached
+	gdb := gextest.Open(t)
+
+	// only the header and the block of BCL6 are left
+	err := os.Truncate(filepath.Join(gdb.Dir(), "open", "tpm.bin"), gex.BinHeaderSize+store.BlockSize(gextest.FileSamples["open/tpm.bin"]))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, name = newTestRouter(t, gdb)
+
+	w := request(t, r, name, http.MethodPost, "/expression/tpm", admin, params)
+
+	if w.Code == http.StatusOK {
+		t.Errorf("got %s, want an error", w.Body.String())
+	}
+}
+
+// However many sets a search asks for it returns at most
+// MaxGeneSetSearchN
+func TestSearchGeneSetsN(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	conn, err := sql.Open("sqlite3", filepath.Join(gdb.Dir(), "gex.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	for i := range gex.MaxGeneSetSearchN + 5 {
+		_, err := conn.Exec(`INSERT INTO gene_sets (id, public_id, genome_id, collection, name, description, url) VALUES (?, ?, 1, 'C2', ?, '', '')`,
+			100+i, fmt.Sprintf("geneset-bulk-%d", i), fmt.Sprintf("BULK_%d", i))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, name := newTestRouter(t, gdb)
+
+	r.GET("/genesets/search", SearchGeneSetsRoute)
+
+	for _, test := range []struct {
+		n    string
+		want int
+	}{{n: "", want: gex.DefaultGeneSetSearchN},
+		{n: "5", want: 5},
+		{n: "100000000", want: gex.MaxGeneSetSearchN}} {
+		geneSets := decode[[]*gex.GeneSet](t, request(t, r, name, http.MethodGet, "/genesets/search?genome=human&q=bulk&n="+test.n, nil, nil))
+
+		if len(geneSets) != test.want {
+			t.Errorf("n=%s: got %d sets, want %d", test.n, len(geneSets), test.want)
+		}
+	}
+}
+
+// The probes of a scored gene set are limited even though the set is
+// not listed gene by gene
+func TestScoredProbesLimit(t *testing.T) {
+	gdb := gextest.Open(t)
+
+	limits := gex.DefaultLimits()
+	// the set has BCL6, MYC and TP53
+	limits.User.ScoredProbes = 2
+
+	gdb.SetLimits(limits)
+
+	r, name := newTestRouter(t, gdb)
+
+	params := GexParams{Genes: []string{"BCL6"},
+		Scores:      []string{gextest.GeneSet},
+		ScoreMethod: gex.ScoreMethodZScore,
+		Datasets:    []string{gextest.OpenDataset}}
+
+	w := request(t, r, name, http.MethodPost, "/expression/tpm", []string{gextest.ViewPermission}, params)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d %s, want %d", w.Code, w.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+
+	limits.User.ScoredProbes = 3
+
+	results := decode[[]*gex.SearchResults](t, request(t, r, name, http.MethodPost, "/expression/tpm", []string{gextest.ViewPermission}, params))
+
+	if len(results) != 1 || len(results[0].Probes) != 2 {
+		t.Errorf("got %v, want BCL6 and the score of the set", results)
+	}
+}
@@ -0,0 +1,478 @@
+package gex
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/antonybholmes/go-sys"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+// A change to the schema that upgrades a catalog from the version
+// before to Version. The statements work for sqlite and Postgres.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+	// a query that only works once the migration has been made, which
+	// is how the version of catalogs without a schema_version table is
+	// worked out
+	Check string
+	// fills in what the statements added from the data the catalog
+	// already has
+	fill func(tx *catalogTx) error
+}
+
+const (
+	// The schema step2_make_gex_sql_bin.py creates and the queries
+	// are written for
+	SchemaVersion = 9
+
+	SchemaVersionSQL = `SELECT sv.version FROM schema_version sv`
+
+	CreateSchemaVersionSQL = `CREATE TABLE schema_version (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		version INTEGER NOT NULL)`
+
+	InsertSchemaVersionSQL = `INSERT INTO schema_version (id, version) VALUES (1, :version)`
+
+	UpdateSchemaVersionSQL = `UPDATE schema_version SET version = :version`
+
+	UpdateDatasetTimesSQL = `UPDATE datasets SET created_at = :now, updated_at = :now`
+
+	// the values of each field in the order the samples were loaded
+	MigrateSampleMetadataSQL = `SELECT
+		s.dataset_id,
+		smd.metadata_id,
+		s.name,
+		smd.value
+		FROM sample_metadata smd
+		JOIN samples s ON s.id = smd.sample_id
+		ORDER BY s.dataset_id, smd.metadata_id, s.id`
+
+	InsertDatasetMetadataSQL = `INSERT INTO dataset_metadata (dataset_id, metadata_id, type, units, ord)
+		VALUES (:dataset, :metadata, :type, '', :ord)`
+
+	InsertMetadataCategorySQL = `INSERT INTO metadata_categories (id, public_id, dataset_id, metadata_id, name, color, ord)
+		VALUES (:id, :public_id, :dataset, :metadata, :name, '', :ord)`
+)
+
+// The tables of version 1, the first schema with the expression and
+// files tables, which every catalog that can be migrated has
+var BaseTables = []string{"genomes",
+	"sources",
+	"genes",
+	"alt_gene_names",
+	"technologies",
+	"probes",
+	"datasets",
+	"permissions",
+	"dataset_permissions",
+	"samples",
+	"metadata",
+	"sample_metadata",
+	"expression_types",
+	"files",
+	"data_types",
+	"expression"}
+
+// Upgrades catalogs built by older versions of step2_make_gex_sql_bin.py
+// to SchemaVersion. Keep RequiredTables and RequiredColumns in step.
+var Migrations = []*Migration{
+	{Version: 2,
+		Name: "gene sets",
+		Statements: []string{`CREATE TABLE gene_sets (
+				id INTEGER PRIMARY KEY,
+				public_id TEXT NOT NULL UNIQUE,
+				genome_id INTEGER NOT NULL,
+				collection TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				url TEXT NOT NULL DEFAULT '',
+				UNIQUE(genome_id, name),
+				FOREIGN KEY(genome_id) REFERENCES genomes(id))`,
+			`CREATE INDEX idx_gene_sets_genome_id ON gene_sets(genome_id)`,
+			`CREATE INDEX idx_gene_sets_name ON gene_sets (LOWER(name))`,
+			`CREATE TABLE gene_set_members (
+				gene_set_id INTEGER NOT NULL,
+				ord INTEGER NOT NULL,
+				symbol TEXT NOT NULL,
+				gene_id INTEGER,
+				PRIMARY KEY(gene_set_id, ord),
+				FOREIGN KEY(gene_set_id) REFERENCES gene_sets(id),
+				FOREIGN KEY(gene_id) REFERENCES genes(id))`,
+			`CREATE INDEX idx_gene_set_members_gene_set_id ON gene_set_members(gene_set_id)`},
+		Check: `SELECT gsm.gene_set_id FROM gene_set_members gsm LIMIT 1`},
+	{Version: 3,
+		Name: "dataset details",
+		// sqlite cannot add a column defaulting to the current time so
+		// the times are filled in afterwards
+		Statements: []string{`ALTER TABLE datasets ADD COLUMN pubmed TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE datasets ADD COLUMN geo TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE datasets ADD COLUMN ega TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE datasets ADD COLUMN sample_count INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE datasets ADD COLUMN probe_count INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE datasets ADD COLUMN created_at TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE datasets ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`,
+			`UPDATE datasets SET
+				sample_count = (SELECT COUNT(s.id) FROM samples s WHERE s.dataset_id = datasets.id),
+				probe_count = (SELECT COUNT(DISTINCT e.probe_id) FROM expression e WHERE e.dataset_id = datasets.id)`},
+		Check: `SELECT d.pubmed, d.probe_count, d.updated_at FROM datasets d LIMIT 1`,
+		fill: func(tx *catalogTx) error {
+			_, err := tx.Exec(UpdateDatasetTimesSQL, sql.Named("now", time.Now().UTC().Format(time.DateTime)))
+			return err
+		}},
+	{Version: 4,
+		Name: "metadata schema",
+		Statements: []string{`CREATE TABLE dataset_metadata (
+				dataset_id INTEGER NOT NULL,
+				metadata_id INTEGER NOT NULL,
+				type TEXT NOT NULL DEFAULT 'categorical',
+				units TEXT NOT NULL DEFAULT '',
+				ord INTEGER NOT NULL,
+				PRIMARY KEY(dataset_id, metadata_id),
+				CHECK(type IN ('categorical', 'numeric', 'date', 'boolean')),
+				FOREIGN KEY(dataset_id) REFERENCES datasets(id),
+				FOREIGN KEY(metadata_id) REFERENCES metadata(id))`,
+			`CREATE INDEX idx_dataset_metadata_dataset_id ON dataset_metadata(dataset_id)`,
+			`CREATE TABLE metadata_categories (
+				id INTEGER PRIMARY KEY,
+				public_id TEXT NOT NULL UNIQUE,
+				dataset_id INTEGER NOT NULL,
+				metadata_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				color TEXT NOT NULL DEFAULT '',
+				ord INTEGER NOT NULL,
+				UNIQUE(dataset_id, metadata_id, name),
+				FOREIGN KEY(dataset_id, metadata_id) REFERENCES dataset_metadata(dataset_id, metadata_id))`,
+			`CREATE INDEX idx_metadata_categories_dataset_metadata_id ON metadata_categories(dataset_id, metadata_id)`},
+		Check: `SELECT mc.id FROM metadata_categories mc LIMIT 1`,
+		fill:  fillMetadataSchema},
+	{Version: 5,
+		Name: "sample permissions",
+		Statements: []string{`CREATE TABLE sample_permissions (
+				sample_id INTEGER,
+				permission_id INTEGER,
+				PRIMARY KEY(sample_id, permission_id),
+				FOREIGN KEY (sample_id) REFERENCES samples(id),
+				FOREIGN KEY (permission_id) REFERENCES permissions(id))`,
+			`CREATE INDEX idx_sample_permissions_permission_id ON sample_permissions(permission_id)`},
+		Check: `SELECT sp.sample_id FROM sample_permissions sp LIMIT 1`},
+	{Version: 6,
+		Name: "gene locations",
+		Statements: []string{`ALTER TABLE genes ADD COLUMN chr TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE genes ADD COLUMN start INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE genes ADD COLUMN "end" INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE genes ADD COLUMN strand TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX idx_genes_location ON genes (chr, start)`},
+		Check: `SELECT g.chr FROM genes g LIMIT 1`},
+	{Version: 7,
+		Name: "gene biotypes",
+		Statements: []string{`ALTER TABLE genes ADD COLUMN biotype TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE genes ADD COLUMN gene_group TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX idx_genes_biotype ON genes (LOWER(biotype))`},
+		Check: `SELECT g.biotype FROM genes g LIMIT 1`},
+	{Version: 8,
+		Name: "catalog version",
+		Statements: []string{`CREATE TABLE catalog_version (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				version INTEGER NOT NULL)`,
+			`INSERT INTO catalog_version (id, version) VALUES (1, 1)`},
+		Check: `SELECT cv.version FROM catalog_version cv LIMIT 1`},
+	{Version: 9,
+		Name: "sample columns",
+		// older builds wrote the samples of a dataset to its files in
+		// the order they were loaded
+		Statements: []string{`ALTER TABLE samples ADD COLUMN file_column INTEGER NOT NULL DEFAULT -1`,
+			`UPDATE samples SET file_column = (SELECT COUNT(s.id) FROM samples s
+				WHERE s.dataset_id = samples.dataset_id AND s.id < samples.id)`},
+		Check: `SELECT s.file_column FROM samples s LIMIT 1`},
+}
+
+var ErrUnknownSchemaVersion = errors.New("catalog schema version is not supported")
+
+// Returns the schema version of the catalog. Catalogs built before
+// there was a schema_version table are not versioned, so their version
+// is worked out from the tables and columns they have, with 0 meaning
+// they are not a catalog this version can migrate, such as those made
+// with the old expr and dataset tables.
+func (gdb *GexDB) SchemaVersion() (int, bool, error) {
+	var version int
+
+	err := gdb.db.QueryRow(SchemaVersionSQL).Scan(&version)
+
+	if err == nil {
+		return version, true, nil
+	}
+
+	err = gdb.Ping()
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, table := range BaseTables {
+		if !gdb.canQuery(fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", table)) {
+			return 0, false, nil
+		}
+	}
+
+	version = 1
+
+	// migrations were made in order so the first one missing is the
+	// next to make
+	for _, migration := range Migrations {
+		if !gdb.canQuery(migration.Check) {
+			break
+		}
+
+		version = migration.Version
+	}
+
+	return version, false, nil
+}
+
+// Upgrades the catalog in place to SchemaVersion, adding the
+// schema_version table if it does not have one. Every migration is
+// made in one transaction so a failure leaves the catalog as it was.
+// The version the catalog was at is returned.
+func (gdb *GexDB) Migrate() (int, error) {
+	err := gdb.checkWritable()
+
+	if err != nil {
+		return 0, err
+	}
+
+	version, versioned, err := gdb.SchemaVersion()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if version == 0 {
+		return 0, fmt.Errorf("%w: not a gex catalog or too old to migrate", ErrInvalidSchema)
+	}
+
+	if version > SchemaVersion {
+		return version, fmt.Errorf("%w: %d but only up to %d can be used", ErrUnknownSchemaVersion, version, SchemaVersion)
+	}
+
+	if versioned && version == SchemaVersion {
+		return version, nil
+	}
+
+	tx, err := gdb.rwdb.Begin()
+
+	if err != nil {
+		return version, err
+	}
+
+	defer tx.Rollback()
+
+	if !versioned {
+		_, err = tx.Exec(CreateSchemaVersionSQL)
+
+		if err != nil {
+			return version, err
+		}
+
+		_, err = tx.Exec(InsertSchemaVersionSQL, sql.Named("version", version))
+
+		if err != nil {
+			return version, err
+		}
+	}
+
+	for _, migration := range Migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		log.Info().Msgf("migrating catalog to version %d: %s", migration.Version, migration.Name)
+
+		for _, statement := range migration.Statements {
+			_, err = tx.Exec(statement)
+
+			if err != nil {
+				return version, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		if migration.fill != nil {
+			err = migration.fill(tx)
+
+			if err != nil {
+				return version, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		_, err = tx.Exec(UpdateSchemaVersionSQL, sql.Named("version", migration.Version))
+
+		if err != nil {
+			return version, err
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return version, err
+	}
+
+	gdb.catalogCache.entries.Clear()
+
+	return version, nil
+}
+
+// Returns an error if the catalog has a schema version other than the
+// one the queries are written for. Catalogs without a version are
+// served if they look like the current version.
+func (gdb *GexDB) checkSchemaVersion() error {
+	version, versioned, err := gdb.SchemaVersion()
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version == 0:
+		return fmt.Errorf("%w: not a gex catalog, e.g. it has the old expr and dataset tables", ErrInvalidSchema)
+	case version > SchemaVersion:
+		return fmt.Errorf("%w: %d but only up to %d can be used", ErrUnknownSchemaVersion, version, SchemaVersion)
+	case version < SchemaVersion:
+		return fmt.Errorf("%w: version %d must be migrated to %d with gex-migrate", ErrInvalidSchema, version, SchemaVersion)
+	case !versioned:
+		log.Warn().Msgf("catalog has no schema version, add one with gex-migrate")
+	}
+
+	return nil
+}
+
+func (gdb *GexDB) canQuery(query string) bool {
+	rows, err := gdb.db.Query(query)
+
+	if err != nil {
+		return false
+	}
+
+	rows.Close()
+
+	return true
+}
+
+// Describes the metadata fields of each dataset from their values as
+// step2_make_gex_sql_bin.py does when it has no config for a dataset.
+// Fields are ordered by id, which is the order they were first loaded
+// in.
+func fillMetadataSchema(tx *catalogTx) error {
+	type field struct {
+		dataset  int
+		metadata int
+		values   []string
+		// the first column of the sample file is the sample name so
+		// its values are not worth listing as categories
+		names bool
+	}
+
+	rows, err := tx.Query(MigrateSampleMetadataSQL)
+
+	if err != nil {
+		return err
+	}
+
+	fields := []*field{}
+	var current *field
+
+	for rows.Next() {
+		var datasetId int
+		var metadataId int
+		var name string
+		var value string
+
+		err := rows.Scan(&datasetId, &metadataId, &name, &value)
+
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		if current == nil || current.dataset != datasetId || current.metadata != metadataId {
+			current = &field{dataset: datasetId, metadata: metadataId, names: true}
+			fields = append(fields, current)
+		}
+
+		current.names = current.names && value == name
+
+		if value != "" {
+			current.values = append(current.values, value)
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ord := 0
+	categoryId := 0
+
+	for i, field := range fields {
+		if i == 0 || field.dataset != fields[i-1].dataset {
+			ord = 0
+		}
+
+		ord++
+
+		metadataType := inferMetadataType(field.values)
+
+		_, err := tx.Exec(InsertDatasetMetadataSQL,
+			sql.Named("dataset", field.dataset),
+			sql.Named("metadata", field.metadata),
+			sql.Named("type", metadataType),
+			sql.Named("ord", ord))
+
+		if err != nil {
+			return err
+		}
+
+		if metadataType != MetadataTypeCategorical || field.names {
+			continue
+		}
+
+		// the values in the order they were first seen
+		seen := make(map[string]struct{})
+
+		for _, value := range field.values {
+			if _, ok := seen[value]; ok {
+				continue
+			}
+
+			seen[value] = struct{}{}
+
+			publicId, err := sys.Uuidv7()
+
+			if err != nil {
+				return err
+			}
+
+			categoryId++
+
+			_, err = tx.Exec(InsertMetadataCategorySQL,
+				sql.Named("id", categoryId),
+				sql.Named("public_id", publicId),
+				sql.Named("dataset", field.dataset),
+				sql.Named("metadata", field.metadata),
+				sql.Named("name", value),
+				sql.Named("ord", len(seen)))
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package gex_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+)
+
+func TestMigrateBaseline(t *testing.T) {
+	path := gextest.NewBaseline(t)
+
+	_, err := gex.OpenGexDB(path, nil)
+
+	if !errors.Is(err, gex.ErrInvalidSchema) {
+		t.Fatalf("opening a baseline catalog: got %v, want %v", err, gex.ErrInvalidSchema)
+	}
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer gdb.Close()
+
+	// what the migrations filled in from the baseline rows
+	schema, err := gdb.MetadataSchema(gextest.OpenDataset, true, nil)
+	check(t, "MetadataSchema", err)
+	want(t, "fields", len(schema), 3)
+	want(t, "sample type", schema[0].Type, gex.MetadataTypeCategorical)
+	want(t, "sample categories", len(schema[0].Categories), 0)
+	want(t, "COO type", schema[1].Type, gex.MetadataTypeCategorical)
+	want(t, "COO categories", len(schema[1].Categories), 2)
+	want(t, "first COO category", schema[1].Categories[0].Name, "ABC")
+	want(t, "age type", schema[2].Type, gex.MetadataTypeNumeric)
+
+	dataset, err := gdb.Dataset(gextest.OpenDataset, true, nil)
+	check(t, "Dataset", err)
+	want(t, "sample count", dataset.SampleCount, 4)
+	want(t, "probe count", dataset.ProbeCount, 4)
+
+	// migrating again does nothing
+	version, err := gdb.Migrate()
+	check(t, "Migrate", err)
+	want(t, "version", version, gex.SchemaVersion)
+
+	gdb.Close()
+
+	// the rows a current build would have so every query has
+	// something to find
+	exec(t, path, gextest.Statements(t, "annotations.sql")...)
+
+	gdb, err = gex.OpenGexDB(path, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer gdb.Close()
+
+	checkQueries(t, gdb)
+}
+
+// Catalogs without a schema_version table are given the version of the
+// last migration they have
+func TestSchemaVersionUnversioned(t *testing.T) {
+	for _, migration := range append([]*gex.Migration{{Version: 1}}, gex.Migrations...) {
+		t.Run(fmt.Sprintf("version %d", migration.Version), func(t *testing.T) {
+			path := gextest.NewBaseline(t)
+
+			for _, m := range gex.Migrations {
+				if m.Version <= migration.Version {
+					exec(t, path, m.Statements...)
+				}
+			}
+
+			_, err := gex.OpenGexDB(path, nil)
+
+			if migration.Version < gex.SchemaVersion {
+				if !errors.Is(err, gex.ErrInvalidSchema) || !strings.Contains(err.Error(), fmt.Sprintf("version %d ", migration.Version)) {
+					t.Fatalf("got %v, want version %d to need migrating", err, migration.Version)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer gdb.Close()
+
+			version, versioned, err := gdb.SchemaVersion()
+			check(t, "SchemaVersion", err)
+			want(t, "version", version, gex.SchemaVersion)
+			want(t, "versioned", versioned, true)
+		})
+	}
+}
+
+func exec(t *testing.T, path string, statements ...string) {
+	t.Helper()
+
+	conn, err := sql.Open("sqlite3", path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	for _, statement := range statements {
+		_, err := conn.Exec(statement)
+
+		if err != nil {
+			t.Fatalf("%s: %s", statement, err)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package gex
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/antonybholmes/go-gex/store"
+	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-web"
+)
+
+const (
+	// single sample gsea (Barbie et al. 2009) as implemented in GSVA
+	ScoreMethodSSGSEA = "ssgsea"
+	// mean of the per gene z-scores across samples
+	ScoreMethodZScore = "zscore"
+
+	// weight given to the ranks of genes in the set, as in GSVA
+	SSGSEAAlpha = 0.25
+
+	// magic number, version, num probes, num samples, block size
+	BinHeaderSize = 5 * 4
+	BinMagic      = 42
+	// the newest file format that can be read
+	BinVersion = 1
+
+	// all of the expression rows for a dataset so that every gene
+	// can be ranked, which is what ssGSEA needs
+	DatasetExprSQL = `SELECT
+		e.probe_id,
+		f.url,
+		e.offset,
+		e.length
+		FROM expression e
+		JOIN datasets d ON d.id = e.dataset_id
+		JOIN files f ON e.file_id = f.id
+		WHERE
+			d.id IN (
+				SELECT dp.dataset_id
+				FROM dataset_permissions dp
+				JOIN permissions p ON dp.permission_id = p.id
+				WHERE <<PERMISSIONS>>)
+			AND e.expression_type_id = :type
+			AND d.public_id = :dataset
+		ORDER BY f.url, e.offset`
+)
+
+var (
+	ErrInvalidScoreMethod = errors.New("invalid score method")
+	// none of the set's genes are in the dataset, or none vary in the
+	// samples the user can see, so there is nothing to score
+	ErrNoScorableGenes = errors.New("no genes in set could be scored")
+)
+
+// Normalizes a score method name, defaulting to ssGSEA if none
+// is given
+func ParseScoreMethod(method string) (string, error) {
+	switch web.FormatParam(method) {
+	case "", ScoreMethodSSGSEA:
+		return ScoreMethodSSGSEA, nil
+	case ScoreMethodZScore:
+		return ScoreMethodZScore, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidScoreMethod, method)
+	}
+}
+
+// Scores a gene set in each sample of a dataset. The probes are the
+// member genes of the set, as returned by FindProbes. The result is
+// returned as a pseudo probe row named after the gene set so it can be
+// displayed alongside the real genes. ssGSEA ranks every probe in the
+// dataset so a LimitError is returned if that is more values than the
+// limits allow, or if the set has more probes than they allow. If limits
+// is nil there is no limit. ErrNoScorableGenes is returned if the
+// dataset has nothing to score.
+func (gdb *GexDB) ScoreGeneSet(datasetId string,
+	exprType *db.Entity,
+	geneSet *GeneSet,
+	probes []*Probe,
+	method string,
+	isAdmin bool,
+	permissions []string,
+	limits *RequestLimits) (*ExpressionProbe, error) {
+
+	if limits != nil {
+		err := CheckLimit("scored probes", len(probes), limits.ScoredProbes)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var scores []float32
+	var err error
+
+	switch method {
+	case ScoreMethodZScore:
+		scores, err = gdb.zScore(datasetId, exprType, probes, isAdmin, permissions)
+	case ScoreMethodSSGSEA:
+		scores, err = gdb.ssgsea(datasetId, exprType, probes, isAdmin, permissions, limits)
+	default:
+		err = fmt.Errorf("%w: %s", ErrInvalidScoreMethod, method)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	probe := Probe{GeneSymbol: geneSet.Name}
+	probe.PublicId = geneSet.PublicId
+	probe.Name = geneSet.Name
+
+	return &ExpressionProbe{Probe: &probe, Values: scores, Score: method}, nil
+}
+
+func (gdb *GexDB) zScore(datasetId string,
+	exprType *db.Entity,
+	probes []*Probe,
+	isAdmin bool,
+	permissions []string) ([]float32, error) {
+
+	var sums []float64
+	var n int
+
+	// scores are calculated using only the samples the user can see
+	columns, err := gdb.sampleColumns(datasetId, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, probe := range probes {
+		values, err := gdb.probeValues(datasetId, exprType, probe, isAdmin, permissions, nil)
+
+		if err != nil {
+			// not every member of a set need be in a dataset
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		values, err = sliceSamples(values, columns)
+
+		if err != nil {
+			return nil, err
+		}
+
+		z, ok := zScores(values)
+
+		// genes that do not vary tell us nothing
+		if !ok {
+			continue
+		}
+
+		if sums == nil {
+			sums = make([]float64, len(z))
+		}
+
+		for i, v := range z {
+			sums[i] += v
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		return nil, ErrNoScorableGenes
+	}
+
+	scores := make([]float32, len(sums))
+
+	for i, v := range sums {
+		scores[i] = float32(v / float64(n))
+	}
+
+	return scores, nil
+}
+
+// z-score values using the sample standard deviation. Returns false
+// if the values have no variance.
+func zScores(values []float32) ([]float64, bool) {
+	n := float64(len(values))
+
+	if n < 2 {
+		return nil, false
+	}
+
+	var mean float64
+
+	for _, v := range values {
+		mean += float64(v)
+	}
+
+	mean /= n
+
+	var ss float64
+
+	for _, v := range values {
+		d := float64(v) - mean
+		ss += d * d
+	}
+
+	sd := math.Sqrt(ss / (n - 1))
+
+	if sd == 0 || math.IsNaN(sd) {
+		return nil, false
+	}
+
+	ret := make([]float64, len(values))
+
+	for i, v := range values {
+		ret[i] = (float64(v) - mean) / sd
+	}
+
+	return ret, true
+}
+
+func (gdb *GexDB) ssgsea(datasetId string,
+	exprType *db.Entity,
+	probes []*Probe,
+	isAdmin bool,
+	permissions []string,
+	limits *RequestLimits) ([]float32, error) {
+
+	matrix, err := gdb.datasetMatrix(datasetId, exprType, isAdmin, permissions, limits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matrix) == 0 {
+		return nil, fmt.Errorf("%w: no expression data for dataset %s", ErrNoScorableGenes, datasetId)
+	}
+
+	inSet := make(map[uint32]struct{}, len(probes))
+
+	for _, probe := range probes {
+		inSet[uint32(probe.Id)] = struct{}{}
+	}
+
+	probeIds := make([]uint32, 0, len(matrix))
+	numSamples := -1
+
+	for probeId, values := range matrix {
+		probeIds = append(probeIds, probeId)
+
+		if numSamples == -1 {
+			numSamples = len(values)
+		}
+	}
+
+	// keep a stable order so ties are ranked the same way every time
+	sort.Slice(probeIds, func(i, j int) bool { return probeIds[i] < probeIds[j] })
+
+	scores := make([]float64, numSamples)
+
+	for s := range numSamples {
+		scores[s] = ssgseaSample(matrix, probeIds, inSet, s)
+	}
+
+	// normalize by the range of scores across samples as GSVA does
+	minScore := math.Inf(1)
+	maxScore := math.Inf(-1)
+
+	for _, v := range scores {
+		minScore = math.Min(minScore, v)
+		maxScore = math.Max(maxScore, v)
+	}
+
+	r := maxScore - minScore
+
+	ret := make([]float32, numSamples)
+
+	for i, v := range scores {
+		if r > 0 {
+			v /= r
+		}
+
+		ret[i] = float32(v)
+	}
+
+	return ret, nil
+}
+
+// Calculates the ssGSEA enrichment score of one sample by walking down
+// the genes ranked by expression and summing the difference between the
+// weighted hits in the set and the misses outside it
+func ssgseaSample(matrix map[uint32][]float32, probeIds []uint32, inSet map[uint32]struct{}, sample int) float64 {
+	ranked := make([]uint32, 0, len(probeIds))
+
+	for _, probeId := range probeIds {
+		v := float64(matrix[probeId][sample])
+
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			ranked = append(ranked, probeId)
+		}
+	}
+
+	// highest expression first
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return matrix[ranked[i]][sample] > matrix[ranked[j]][sample]
+	})
+
+	n := len(ranked)
+
+	// the weights are the ranks where the top gene has rank n
+	var hitTotal float64
+	var numHits int
+
+	for i, probeId := range ranked {
+		if _, ok := inSet[probeId]; ok {
+			hitTotal += math.Pow(float64(n-i), SSGSEAAlpha)
+			numHits++
+		}
+	}
+
+	numMisses := n - numHits
+
+	if numHits == 0 || numMisses == 0 {
+		return 0
+	}
+
+	var es float64
+	var hits float64
+	var misses float64
+
+	for i, probeId := range ranked {
+		if _, ok := inSet[probeId]; ok {
+			hits += math.Pow(float64(n-i), SSGSEAAlpha) / hitTotal
+		} else {
+			misses += 1 / float64(numMisses)
+		}
+
+		es += hits - misses
+	}
+
+	return es
+}
+
+// Reads every probe in a dataset for an expression type and returns a
+// map of probe id to sample values. The size of the matrix is checked
+// against the limits before any file is read.
+func (gdb *GexDB) datasetMatrix(datasetId string,
+	exprType *db.Entity,
+	isAdmin bool,
+	permissions []string,
+	limits *RequestLimits) (map[uint32][]float32, error) {
+
+	rows, err := gdb.queryWithPermissions(DatasetExprSQL,
+		isAdmin,
+		permissions,
+		sql.Named("dataset", datasetId),
+		sql.Named("type", exprType.Id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	// rows are ordered by file so we only read each file once
+	urls := make([]string, 0, 2)
+	inDataset := make(map[uint32]struct{}, 20000)
+	// the values of every sample, which is what is read
+	numValues := 0
+
+	for rows.Next() {
+		var probeId uint32
+		var url string
+		var offset int64
+		var length int
+
+		err := rows.Scan(&probeId, &url, &offset, &length)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(urls) == 0 || urls[len(urls)-1] != url {
+			urls = append(urls, url)
+		}
+
+		inDataset[probeId] = struct{}{}
+		numValues += length
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// ranks are calculated using only the samples the user can see
+	columns, err := gdb.sampleColumns(datasetId, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// nil columns means every sample is visible
+	if columns != nil {
+		numValues = len(inDataset) * len(columns)
+	}
+
+	if limits != nil {
+		err = CheckLimit("scored values", numValues, limits.ScoredValues)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make(map[uint32][]float32, len(inDataset))
+
+	for _, url := range urls {
+		err := gdb.readAllGeneBlocks(url, func(probeId uint32, values []float32) error {
+			if _, ok := inDataset[probeId]; !ok {
+				return nil
+			}
+
+			values, err := sliceSamples(values, columns)
+
+			if err != nil {
+				return err
+			}
+
+			ret[probeId] = values
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// read every block in a binary expression file, calling f with the
+// probe id and values of each block in turn until it returns an error
+func (gdb *GexDB) readAllGeneBlocks(url string, f func(probeId uint32, values []float32) error) error {
+	file, err := gdb.store.Open(context.Background(), url)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	header, err := parseBinHeader(r, url)
+
+	if err != nil {
+		return err
+	}
+
+	numSamples := int(header.Samples)
+
+	// newer versions may pad blocks so step by the block size the
+	// file gives rather than assume it
+	block := make([]byte, header.BlockSize)
+
+	for range header.Probes {
+		_, err = io.ReadFull(r, block)
+
+		if err != nil {
+			return err
+		}
+
+		probeId, values, err := store.DecodeBlock(block, numSamples)
+
+		if err != nil {
+			return err
+		}
+
+		err = f(probeId, values)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
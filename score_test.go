@@ -0,0 +1,210 @@
+package gex_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-gex/store"
+)
+
+// ssGSEA reads the whole dataset so is refused if it has more values
+// than the limits allow
+func TestSSGSEALimit(t *testing.T) {
+	gdb, score := openScoring(t, gextest.NewBaseline(t))
+
+	defer gdb.Close()
+
+	// 4 probes of 4 samples since the baseline has no sample permissions
+	for _, test := range []struct {
+		limit    int
+		exceeded bool
+	}{{limit: 15, exceeded: true}, {limit: 16}} {
+		_, err := score(gex.ScoreMethodSSGSEA, &gex.RequestLimits{ScoredValues: test.limit, ScoredProbes: 2})
+
+		if errors.Is(err, gex.ErrLimitExceeded) != test.exceeded {
+			t.Errorf("limit %d: got %v, want exceeded %v", test.limit, err, test.exceeded)
+		}
+	}
+}
+
+// A z-score reads every probe of the set so the set is limited too
+func TestScoredProbesLimit(t *testing.T) {
+	gdb, score := openScoring(t, gextest.NewBaseline(t))
+
+	defer gdb.Close()
+
+	// the set is BCL6 and MYC
+	for _, method := range []string{gex.ScoreMethodZScore, gex.ScoreMethodSSGSEA} {
+		for _, test := range []struct {
+			limit    int
+			exceeded bool
+		}{{limit: 1, exceeded: true}, {limit: 2}} {
+			_, err := score(method, &gex.RequestLimits{ScoredProbes: test.limit, ScoredValues: 16})
+
+			if errors.Is(err, gex.ErrLimitExceeded) != test.exceeded {
+				t.Errorf("%s limit %d: got %v, want exceeded %v", method, test.limit, err, test.exceeded)
+			}
+		}
+	}
+}
+
+// Blocks are stepped through by the block size in the header, which
+// newer writers may pad, and versions newer than BinVersion are refused
+func TestSSGSEAFileFormat(t *testing.T) {
+	want, err := scoreFile(t, gex.BinVersion, 0)
+	check(t, "ScoreGeneSet", err)
+
+	got, err := scoreFile(t, gex.BinVersion, 8)
+	check(t, "ScoreGeneSet padded", err)
+
+	if !slices.Equal(got, want) {
+		t.Errorf("padded blocks: got %v, want %v", got, want)
+	}
+
+	_, err = scoreFile(t, gex.BinVersion+1, 0)
+
+	if err == nil {
+		t.Errorf("version %d was read", gex.BinVersion+1)
+	}
+}
+
+// BCL6 and MYC go up by 1 in each of the 4 samples of the baseline,
+// which has no sample permissions, so both have the same z-scores as
+// their mean
+func TestZScore(t *testing.T) {
+	gdb, score := openScoring(t, gextest.NewBaseline(t))
+
+	defer gdb.Close()
+
+	ret, err := score(gex.ScoreMethodZScore, nil)
+	check(t, "ScoreGeneSet", err)
+
+	want(t, "score", ret.Score, gex.ScoreMethodZScore)
+	want(t, "name", ret.Probe.Name, "test")
+
+	// the sample standard deviation of 0, 1, 2 and 3
+	sd := math.Sqrt(5.0 / 3)
+
+	if len(ret.Values) != 4 {
+		t.Fatalf("got %v, want 4 scores", ret.Values)
+	}
+
+	for i, v := range ret.Values {
+		if math.Abs(float64(v)-(float64(i)-1.5)/sd) > 1e-6 {
+			t.Errorf("got scores %v, want (i - 1.5) / %f", ret.Values, sd)
+			break
+		}
+	}
+}
+
+// A set with nothing to score is told apart from a file that cannot be
+// read, which must not be hidden
+func TestZScoreErrors(t *testing.T) {
+	path := gextest.NewBaseline(t)
+
+	gdb, score := openScoring(t, path)
+
+	defer gdb.Close()
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	_, err = gdb.ScoreGeneSet(gextest.OpenDataset, exprType, &gex.GeneSet{}, []*gex.Probe{}, gex.ScoreMethodZScore, false, []string{gextest.ViewPermission}, nil)
+
+	if !errors.Is(err, gex.ErrNoScorableGenes) {
+		t.Errorf("no genes: got %v, want %v", err, gex.ErrNoScorableGenes)
+	}
+
+	// only the header and the block of BCL6 are left
+	err = os.Truncate(filepath.Join(filepath.Dir(path), "open", "tpm.bin"), gex.BinHeaderSize+store.BlockSize(gextest.FileSamples["open/tpm.bin"]))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = score(gex.ScoreMethodZScore, nil)
+
+	if err == nil || errors.Is(err, gex.ErrNoScorableGenes) {
+		t.Errorf("truncated file: got %v, want a read error", err)
+	}
+}
+
+// Scores the test gene set after replacing the open dataset's file with
+// one of the given version whose blocks have padding extra bytes
+func scoreFile(t *testing.T, version uint32, padding int) ([]float32, error) {
+	t.Helper()
+
+	path := gextest.NewBaseline(t)
+
+	samples := gextest.FileSamples["open/tpm.bin"]
+	blockSize := store.BlockSize(samples) + int64(padding)
+
+	data := make([]byte, 0, gex.BinHeaderSize+int64(len(gextest.FileProbes))*blockSize)
+
+	for _, v := range []uint32{gex.BinMagic, version, uint32(len(gextest.FileProbes)), uint32(samples), uint32(blockSize)} {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+
+	for _, probe := range gextest.FileProbes {
+		data = binary.LittleEndian.AppendUint32(data, probe)
+
+		for column := range samples {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(gextest.Value(probe, column)))
+		}
+
+		data = append(data, make([]byte, padding)...)
+	}
+
+	err := os.WriteFile(filepath.Join(filepath.Dir(path), "open", "tpm.bin"), data, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gdb, score := openScoring(t, path)
+
+	defer gdb.Close()
+
+	ret, err := score(gex.ScoreMethodSSGSEA, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ret.Values, nil
+}
+
+// Migrates a baseline catalog and returns a function that scores a gene
+// set of BCL6 and MYC in the open dataset as a viewer
+func openScoring(t *testing.T, path string) (*gex.GexDB, func(method string, limits *gex.RequestLimits) (*gex.ExpressionProbe, error)) {
+	t.Helper()
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exprType, err := gdb.ExprType("tpm")
+	check(t, "ExprType", err)
+
+	genome, technology, err := gdb.GenomeTechnology(gextest.OpenDataset, true, nil)
+	check(t, "GenomeTechnology", err)
+
+	probes, err := gdb.FindProbes(genome, technology, []string{"BCL6", "MYC"}, nil)
+	check(t, "FindProbes", err)
+
+	geneSet := &gex.GeneSet{}
+	geneSet.Name = "test"
+
+	return gdb, func(method string, limits *gex.RequestLimits) (*gex.ExpressionProbe, error) {
+		return gdb.ScoreGeneSet(gextest.OpenDataset, exprType, geneSet, probes, method, false, []string{gextest.ViewPermission}, limits)
+	}
+}
@@ -20,12 +20,39 @@ DIR = "../data/modules/gex"
 VERSION = 1
 
 
-def load_sample_data(df: pd.DataFrame):  # , num_id_cols: int = 1):
+METADATA_TYPES = ["categorical", "numeric", "date", "boolean"]
+
+
+def infer_metadata_type(values):
+    values = [v for v in values if v != ""]
+
+    if len(values) == 0:
+        return "categorical"
+
+    if all(re.match(r"^-?\d+(\.\d+)?([eE][-+]?\d+)?$", v) for v in values):
+        return "numeric"
+
+    if all(v.lower() in ["true", "false", "yes", "no"] for v in values):
+        return "boolean"
+
+    if all(re.match(r"^\d{4}-\d{2}-\d{2}$", v) for v in values):
+        return "date"
+
+    return "categorical"
+
+
+def load_sample_data(
+    df: pd.DataFrame, metadata_config: dict = {}
+):  # , num_id_cols: int = 1):
 
     # id_names = df.columns.values[0:num_id_cols]
     sample_metadata_names = df.columns.values  # [num_id_cols:]
 
-    sample_metadata_map = collections.defaultdict(lambda: collections.defaultdict(dict))
+    sample_metadata_map = collections.defaultdict(dict)
+
+    # colors given in older phenotype files as value|color are moved
+    # into the categories of the schema rather than kept on the value
+    cell_colors = collections.defaultdict(dict)
 
     sample_names = df.iloc[:, 0].values
     sample_id_map = {
@@ -42,18 +69,68 @@ def load_sample_data(df: pd.DataFrame):  # , num_id_cols: int = 1):
 
         for metadata_name, value in zip(sample_metadata_names, values):
             if value != "":
-                color = ""
-
-                # we can color ABC/GCB types differently if we want
                 if "|" in value:
                     value, color = value.split("|")
                     value = value.strip()
-                    color = color.strip()
+                    cell_colors[metadata_name][value] = color.strip()
+
+                sample_metadata_map[sample_name][metadata_name] = value
+
+    #
+    # describe each metadata field using the dataset config if
+    # available, otherwise infer the type from the values
+    #
+
+    metadata_schema = {}
+
+    for mi, metadata_name in enumerate(sample_metadata_names):
+        config = metadata_config.get(metadata_name, {})
+
+        values = [
+            sample_metadata_map[sample_name][metadata_name]
+            for sample_name in sample_names
+            if metadata_name in sample_metadata_map[sample_name]
+        ]
+
+        metadata_type = config.get("type", infer_metadata_type(values))
+
+        if metadata_type not in METADATA_TYPES:
+            raise ValueError(f"{metadata_name} has unknown type {metadata_type}")
 
-                sample_metadata_map[sample_name][metadata_name] = {
-                    "value": value,
-                    "color": color,
-                }
+        categories = []
+
+        # the first column is the sample name so every value is
+        # unique and there is no point listing them as categories
+        if metadata_type == "categorical" and mi > 0:
+            # configured categories come first and in the order given
+            for category in config.get("categories", []):
+                categories.append(
+                    {
+                        "name": category["name"],
+                        "color": category.get(
+                            "color", cell_colors[metadata_name].get(category["name"], "")
+                        ),
+                    }
+                )
+
+            used = set([c["name"] for c in categories])
+
+            # then any other values in the order we first see them
+            for value in values:
+                if value not in used:
+                    categories.append(
+                        {
+                            "name": value,
+                            "color": cell_colors[metadata_name].get(value, ""),
+                        }
+                    )
+                    used.add(value)
+
+        metadata_schema[metadata_name] = {
+            "type": metadata_type,
+            "units": config.get("units", ""),
+            "categories": categories,
+        }
 
     # print(sample_names)
 
@@ -61,6 +138,7 @@ def load_sample_data(df: pd.DataFrame):  # , num_id_cols: int = 1):
         sample_names,
         sample_id_map,
         sample_metadata_map,
+        metadata_schema,
     ]
 
 
@@ -222,6 +300,12 @@ for i, symbol in enumerate(df_hugo["Approved symbol"].values):
     ensembl = df_hugo["Ensembl gene ID"].values[i].split(".")[0]
     refseq = df_hugo["RefSeq IDs"].values[i].replace(" ", "")
     ncbi = df_hugo["NCBI Gene ID"].values[i].replace(" ", "")
+    # groups are separated by | and not in older downloads
+    gene_group = (
+        df_hugo["Gene group name"].values[i]
+        if "Gene group name" in df_hugo.columns
+        else ""
+    )
 
     info = {
         "index": gene_index,
@@ -230,6 +314,7 @@ for i, symbol in enumerate(df_hugo["Approved symbol"].values):
         "ensembl": ensembl,
         "refseq": refseq,
         "ncbi": ncbi,
+        "gene_group": gene_group,
     }
 
     official_symbols["human"][hugo] = info
@@ -280,6 +365,7 @@ for i, symbol in enumerate(df_mgi["gene_symbol"].values):
         "ensembl": ensembl,
         "refseq": refseq,
         "ncbi": ncbi,
+        "gene_group": "",
     }
 
     gene_id_map["mouse"][mgi] = mgi
@@ -369,6 +455,12 @@ cursor.execute(
         refseq TEXT NOT NULL DEFAULT '',
         ncbi INTEGER NOT NULL DEFAULT 0,
         symbol TEXT NOT NULL DEFAULT '',
+        chr TEXT NOT NULL DEFAULT '',
+        start INTEGER NOT NULL DEFAULT 0,
+        "end" INTEGER NOT NULL DEFAULT 0,
+        strand TEXT NOT NULL DEFAULT '',
+        biotype TEXT NOT NULL DEFAULT '',
+        gene_group TEXT NOT NULL DEFAULT '',
         FOREIGN KEY(source_id) REFERENCES sources(id));
 
     """,
@@ -378,6 +470,10 @@ cursor.execute("CREATE INDEX idx_genes_ensembl ON genes (LOWER(ensembl));")
 cursor.execute("CREATE INDEX idx_genes_refseq ON genes (LOWER(refseq));")
 cursor.execute("CREATE INDEX idx_genes_symbol ON genes (LOWER(symbol));")
 cursor.execute("CREATE INDEX idx_genes_source_id ON genes(source_id);")
+# locations and biotypes are loaded afterwards from a GTF file with
+# gex-annotate -gtf
+cursor.execute("CREATE INDEX idx_genes_location ON genes (chr, start);")
+cursor.execute("CREATE INDEX idx_genes_biotype ON genes (LOWER(biotype));")
 
 cursor.execute(
     f"""
@@ -452,6 +548,13 @@ cursor.execute(
         platform TEXT NOT NULL,
         institution TEXT NOT NULL,
         description TEXT NOT NULL DEFAULT '',
+        pubmed TEXT NOT NULL DEFAULT '',
+        geo TEXT NOT NULL DEFAULT '',
+        ega TEXT NOT NULL DEFAULT '',
+        sample_count INTEGER NOT NULL DEFAULT 0,
+        probe_count INTEGER NOT NULL DEFAULT 0,
+        created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
         FOREIGN KEY(genome_id) REFERENCES genomes(id),
         FOREIGN KEY(technology_id) REFERENCES technologies(id));
     """,
@@ -463,13 +566,15 @@ cursor.execute("CREATE INDEX idx_datasets_technology_id ON datasets(technology_i
 cursor.execute(f""" CREATE TABLE permissions (
 	id INTEGER PRIMARY KEY ASC,
     public_id TEXT NOT NULL UNIQUE,
-	name TEXT NOT NULL);
+	name TEXT NOT NULL UNIQUE);
 """)
 
 cursor.execute(
     f"INSERT INTO permissions (id, public_id, name) VALUES (1, '{rdfViewId}', 'rdf:view');"
 )
 
+permission_map = {"rdf:view": 1}
+
 
 cursor.execute(f""" CREATE TABLE dataset_permissions (
 	dataset_id INTEGER,
@@ -494,6 +599,8 @@ cursor.execute(
         dataset_id INTEGER NOT NULL,
         name TEXT NOT NULL,
         description TEXT NOT NULL DEFAULT '',
+        -- the column of the sample's values in the dataset's files
+        file_column INTEGER NOT NULL DEFAULT -1,
         FOREIGN KEY(dataset_id) REFERENCES datasets(id));
     """,
 )
@@ -501,6 +608,22 @@ cursor.execute(
 cursor.execute("CREATE INDEX idx_samples_dataset_id ON samples(dataset_id);")
 cursor.execute("CREATE INDEX idx_samples_name ON samples(LOWER(name));")
 
+# samples with no permissions of their own can be seen by anyone who can
+# see their dataset. Samples with permissions can only be seen by users
+# with one of them, so a permission attached to several samples acts as
+# a named subset of the dataset, e.g. the samples shared with a consortium
+cursor.execute(f""" CREATE TABLE sample_permissions (
+	sample_id INTEGER,
+    permission_id INTEGER,
+    PRIMARY KEY(sample_id, permission_id),
+    FOREIGN KEY (sample_id) REFERENCES samples(id),
+    FOREIGN KEY (permission_id) REFERENCES permissions(id));
+""")
+
+cursor.execute(
+    "CREATE INDEX idx_sample_permissions_permission_id ON sample_permissions(permission_id);"
+)
+
 
 cursor.execute(
     f"""
@@ -513,6 +636,48 @@ cursor.execute(
     """,
 )
 
+# how a metadata field is used in a dataset. Fields are shared between
+# datasets, but their type and units can differ, e.g. one dataset
+# may record Age in years and another in months
+cursor.execute(
+    f"""
+    CREATE TABLE dataset_metadata (
+        dataset_id INTEGER NOT NULL,
+        metadata_id INTEGER NOT NULL,
+        type TEXT NOT NULL DEFAULT 'categorical',
+        units TEXT NOT NULL DEFAULT '',
+        ord INTEGER NOT NULL,
+        PRIMARY KEY(dataset_id, metadata_id),
+        CHECK(type IN ('categorical', 'numeric', 'date', 'boolean')),
+        FOREIGN KEY(dataset_id) REFERENCES datasets(id),
+        FOREIGN KEY(metadata_id) REFERENCES metadata(id));
+    """,
+)
+
+cursor.execute(
+    "CREATE INDEX idx_dataset_metadata_dataset_id ON dataset_metadata(dataset_id);"
+)
+
+# the ordered values of categorical fields with their colors
+cursor.execute(
+    f"""
+    CREATE TABLE metadata_categories (
+        id INTEGER PRIMARY KEY,
+        public_id TEXT NOT NULL UNIQUE,
+        dataset_id INTEGER NOT NULL,
+        metadata_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        color TEXT NOT NULL DEFAULT '',
+        ord INTEGER NOT NULL,
+        UNIQUE(dataset_id, metadata_id, name),
+        FOREIGN KEY(dataset_id, metadata_id) REFERENCES dataset_metadata(dataset_id, metadata_id));
+    """,
+)
+
+cursor.execute(
+    "CREATE INDEX idx_metadata_categories_dataset_metadata_id ON metadata_categories(dataset_id, metadata_id);"
+)
+
 cursor.execute(
     f"""
     CREATE TABLE sample_metadata (
@@ -600,6 +765,70 @@ cursor.execute("CREATE INDEX idx_expression_probe_id ON expression(probe_id);")
 cursor.execute("CREATE INDEX idx_expression_data_type_id ON expression(data_type_id);")
 cursor.execute("CREATE INDEX idx_expression_file_id ON expression(file_id);")
 
+# gene sets such as the MSigDB hallmarks so users can search
+# a whole list by name rather than pasting in genes
+cursor.execute(
+    f"""
+    CREATE TABLE gene_sets (
+        id INTEGER PRIMARY KEY,
+        public_id TEXT NOT NULL UNIQUE,
+        genome_id INTEGER NOT NULL,
+        collection TEXT NOT NULL DEFAULT '',
+        name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        url TEXT NOT NULL DEFAULT '',
+        UNIQUE(genome_id, name),
+        FOREIGN KEY(genome_id) REFERENCES genomes(id));
+    """,
+)
+
+cursor.execute("CREATE INDEX idx_gene_sets_genome_id ON gene_sets(genome_id);")
+cursor.execute("CREATE INDEX idx_gene_sets_name ON gene_sets (LOWER(name));")
+
+# members keep the order they were listed in the gmt file. The
+# symbol is kept as given and gene_id is the matching hugo/mgi
+# gene if we could find one
+cursor.execute(
+    f"""
+    CREATE TABLE gene_set_members (
+        gene_set_id INTEGER NOT NULL,
+        ord INTEGER NOT NULL,
+        symbol TEXT NOT NULL,
+        gene_id INTEGER,
+        PRIMARY KEY(gene_set_id, ord),
+        FOREIGN KEY(gene_set_id) REFERENCES gene_sets(id),
+        FOREIGN KEY(gene_id) REFERENCES genes(id));
+    """,
+)
+
+cursor.execute(
+    "CREATE INDEX idx_gene_set_members_gene_set_id ON gene_set_members(gene_set_id);"
+)
+
+# bumped by the api whenever permissions change so every instance
+# sharing the catalog knows to drop its cached dataset lists
+cursor.execute(
+    f"""
+    CREATE TABLE catalog_version (
+        id INTEGER PRIMARY KEY CHECK (id = 1),
+        version INTEGER NOT NULL);
+    """,
+)
+
+cursor.execute("INSERT INTO catalog_version (id, version) VALUES (1, 1);")
+
+# the schema the service expects, which gex-migrate upgrades older
+# catalogs to. Keep in step with SchemaVersion in schema.go.
+cursor.execute(
+    f"""
+    CREATE TABLE schema_version (
+        id INTEGER PRIMARY KEY CHECK (id = 1),
+        version INTEGER NOT NULL);
+    """,
+)
+
+cursor.execute("INSERT INTO schema_version (id, version) VALUES (1, 9);")
+
 
 genomes = ["human", "mouse"]
 
@@ -613,7 +842,7 @@ for gi, genome in enumerate(genomes):
         d = official_symbols[genome][id]
 
         cursor.execute(
-            f"INSERT INTO genes (id, public_id, source_id, gene_id, ensembl, refseq, ncbi, symbol) VALUES (?, ?, ?, ?, ?, ?, ?, ?);",
+            f"INSERT INTO genes (id, public_id, source_id, gene_id, ensembl, refseq, ncbi, symbol, gene_group) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);",
             (
                 d["index"],
                 str(uuid.uuid7()),
@@ -623,6 +852,7 @@ for gi, genome in enumerate(genomes):
                 d["refseq"],
                 d["ncbi"],
                 d["symbol"],
+                d["gene_group"],
             ),
         )
 
@@ -679,6 +909,82 @@ for gi, genome in enumerate(genomes):
             ),
         )
 
+#
+# Load gene sets from gmt files
+#
+
+gene_sets = []
+
+if os.path.exists("gene_sets.json"):
+    with open("gene_sets.json") as f:
+        gene_sets = json.load(f)
+
+gene_set_index = 1
+
+for gene_set_file in gene_sets:
+    genome = gene_set_file["genome"].lower()
+    genome_id = genome_map[gene_set_file["genome"]]
+    collection = gene_set_file.get("collection", "")
+
+    print(gene_set_file["path"])
+
+    with open(gene_set_file["path"]) as f:
+        for line in f:
+            tokens = line.rstrip("\n").split("\t")
+
+            if len(tokens) < 3:
+                continue
+
+            name = tokens[0].strip()
+            description = tokens[1].strip()
+            url = ""
+
+            # msigdb puts a link to the set in the description column
+            if description.startswith("http"):
+                url = description
+                description = ""
+
+            cursor.execute(
+                f"INSERT INTO gene_sets (id, public_id, genome_id, collection, name, description, url) VALUES (?, ?, ?, ?, ?, ?, ?);",
+                (
+                    gene_set_index,
+                    str(uuid.uuid7()),
+                    genome_id,
+                    collection,
+                    name,
+                    description,
+                    url,
+                ),
+            )
+
+            symbols = [x.strip() for x in tokens[2:]]
+            symbols = [x for x in symbols if x != ""]
+
+            for ord, symbol in enumerate(symbols):
+                gene_id = gene_id_map[genome].get(symbol, "")
+
+                if gene_id == "":
+                    gene_id = prev_gene_id_map[genome].get(symbol, "")
+
+                if gene_id == "":
+                    gene_id = alias_gene_id_map[genome].get(symbol, "")
+
+                gene_idx = (
+                    official_symbols[genome][gene_id]["index"]
+                    if gene_id in official_symbols[genome]
+                    else None
+                )
+
+                if gene_idx is None:
+                    print(f"Could not find gene id for {symbol} in gene set {name}")
+
+                cursor.execute(
+                    f"INSERT INTO gene_set_members (gene_set_id, ord, symbol, gene_id) VALUES (?, ?, ?, ?);",
+                    (gene_set_index, ord + 1, symbol, gene_idx),
+                )
+
+            gene_set_index += 1
+
 
 sample_index = 1
 probe_index = 1
@@ -706,7 +1012,20 @@ for di, dataset in enumerate(datasets):
     technology_id = technology_map[technology]
 
     cursor.execute(
-        f"INSERT INTO datasets (id, public_id, genome_id, name, technology_id, platform, institution) VALUES ({dataset_index}, '{dataset_id}', {genome_id}, '{dataset['name']}', {technology_id}, '{dataset['platform']}', '{dataset['institution']}');",
+        f"INSERT INTO datasets (id, public_id, genome_id, name, technology_id, platform, institution, description, pubmed, geo, ega) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+        (
+            dataset_index,
+            dataset_id,
+            genome_id,
+            dataset["name"],
+            technology_id,
+            dataset["platform"],
+            dataset["institution"],
+            dataset.get("description", ""),
+            dataset.get("pubmed", ""),
+            dataset.get("geo", ""),
+            dataset.get("ega", ""),
+        ),
     )
 
     cursor.execute(
@@ -720,34 +1039,83 @@ for di, dataset in enumerate(datasets):
         keep_default_na=False,
     )
 
-    sample_names, sample_id_map, sample_metadata_map = load_sample_data(df_samples)
+    sample_names, sample_id_map, sample_metadata_map, metadata_schema = (
+        load_sample_data(df_samples, dataset.get("metadata", {}))
+    )
+
+    for mi, m in enumerate(metadata_schema):
+        if m not in metadata_map:
+            id = len(metadata_map) + 1
+            metadata_map[m] = id
+
+            cursor.execute(
+                f"INSERT INTO metadata (id, public_id, name) VALUES (?, ?, ?);",
+                (id, str(uuid.uuid7()), m),
+            )
+
+        metadata_id = metadata_map[m]
+
+        cursor.execute(
+            f"INSERT INTO dataset_metadata (dataset_id, metadata_id, type, units, ord) VALUES (?, ?, ?, ?, ?);",
+            (
+                dataset_index,
+                metadata_id,
+                metadata_schema[m]["type"],
+                metadata_schema[m]["units"],
+                mi + 1,
+            ),
+        )
+
+        for ci, category in enumerate(metadata_schema[m]["categories"]):
+            cursor.execute(
+                f"INSERT INTO metadata_categories (id, public_id, dataset_id, metadata_id, name, color, ord) VALUES (?, ?, ?, ?, ?, ?, ?);",
+                (
+                    None,
+                    str(uuid.uuid7()),
+                    dataset_index,
+                    metadata_id,
+                    category["name"],
+                    category["color"],
+                    ci + 1,
+                ),
+            )
+
+    # permission name -> the samples it lets users see
+    sample_permissions = dataset.get("samplePermissions", {})
+
+    for permission in sample_permissions:
+        if permission not in permission_map:
+            permission_map[permission] = len(permission_map) + 1
+            cursor.execute(
+                f"INSERT INTO permissions (id, public_id, name) VALUES (?, ?, ?);",
+                (permission_map[permission], str(uuid.uuid7()), permission.lower()),
+            )
 
-    for sample_name in sample_names:
+    # the files are written with the samples in this order
+    for file_column, sample_name in enumerate(sample_names):
         id = str(uuid.uuid7())
 
         cursor.execute(
-            f"INSERT INTO samples (id, public_id, dataset_id, name) VALUES ('{sample_index}', '{id}', '{dataset_index}', '{sample_name}');",
+            f"INSERT INTO samples (id, public_id, dataset_id, name, file_column) VALUES ('{sample_index}', '{id}', '{dataset_index}', '{sample_name}', {file_column});",
         )
 
+        for permission in sample_permissions:
+            if sample_name in sample_permissions[permission]:
+                cursor.execute(
+                    f"INSERT INTO sample_permissions (sample_id, permission_id) VALUES (?, ?);",
+                    (sample_index, permission_map[permission]),
+                )
+
         #
         # add metadata to sample
         #
 
         for m in sample_metadata_map[sample_name]:
-            if m not in metadata_map:
-                id = len(metadata_map) + 1
-                metadata_map[m] = id
-
-                cursor.execute(
-                    f"""INSERT INTO metadata (id, public_id, name, color) VALUES ({id}, '{str(uuid.uuid7())}', '{m}', '{sample_metadata_map[sample_name][m]["color"]}');
-                    """
-                )
-
             metadata_id = metadata_map[m]
 
             cursor.execute(
-                f"""INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES ({sample_index}, '{metadata_id}', '{sample_metadata_map[sample_name][m]["value"]}' );
-                    """
+                f"INSERT INTO sample_metadata (sample_id, metadata_id, value) VALUES (?, ?, ?);",
+                (sample_index, metadata_id, sample_metadata_map[sample_name][m]),
             )
 
         sample_index += 1
@@ -756,6 +1124,8 @@ for di, dataset in enumerate(datasets):
     # load exp data
     #
 
+    dataset_probes = set()
+
     for file in dataset["data"]:
         print(file["path"])
         probes, genes, exp_map = load_data(
@@ -858,6 +1228,12 @@ for di, dataset in enumerate(datasets):
                 # use 4 bytes per sample for float32
                 offset += lb
 
+                dataset_probes.add(probe_id)
+
+    cursor.execute(
+        f"UPDATE datasets SET sample_count = ?, probe_count = ? WHERE id = ?;",
+        (len(sample_names), len(dataset_probes), dataset_index),
+    )
 
 # print(exp_map)
 
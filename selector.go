@@ -0,0 +1,241 @@
+package gex
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/antonybholmes/go-sys/db"
+)
+
+const (
+	SelectorGroup   = "group"
+	SelectorBiotype = "biotype"
+
+	// groups are stored | separated so wrapping them in | lets one
+	// LIKE match a whole group name
+	GroupGenesSQL = `SELECT
+		g.gene_id
+		FROM genes g
+		JOIN sources s ON s.id = g.source_id
+		WHERE
+			s.genome_id = :genome
+			AND '|' || LOWER(g.gene_group) || '|' LIKE :pattern ESCAPE '\'
+		ORDER BY g.symbol
+		LIMIT :limit`
+
+	BiotypeGenesSQL = `SELECT
+		g.gene_id
+		FROM genes g
+		JOIN sources s ON s.id = g.source_id
+		WHERE
+			s.genome_id = :genome
+			AND LOWER(g.biotype) LIKE :pattern ESCAPE '\'
+		ORDER BY g.symbol
+		LIMIT :limit`
+)
+
+var ErrInvalidSelector = errors.New("invalid selector")
+
+// The ids of the genes in an HGNC gene group such as
+// Immunoglobulin heavy locus at 14q32.33 ordered by symbol. A trailing
+// * matches every group starting with the name, e.g. Immunoglobulin*.
+// A LimitError is returned if there are more than limit genes.
+func (gdb *GexDB) GroupGenes(genome *db.Entity, group string, limit int) ([]string, error) {
+	return gdb.limitedGeneIds(GroupGenesSQL, limit, groupArgs(genome, group)...)
+}
+
+// The ids of the genes of a biotype such as lncRNA ordered by symbol.
+// A trailing * matches every biotype starting with the name. A
+// LimitError is returned if there are more than limit genes.
+func (gdb *GexDB) BiotypeGenes(genome *db.Entity, biotype string, limit int) ([]string, error) {
+	return gdb.limitedGeneIds(BiotypeGenesSQL, limit, biotypeArgs(genome, biotype)...)
+}
+
+func groupArgs(genome *db.Entity, group string) []any {
+	pattern, prefix := likePattern(group)
+
+	if prefix {
+		pattern = "%|" + pattern + "%"
+	} else {
+		pattern = "%|" + pattern + "|%"
+	}
+
+	return []any{sql.Named("genome", genome.Id), sql.Named("pattern", pattern)}
+}
+
+func biotypeArgs(genome *db.Entity, biotype string) []any {
+	pattern, prefix := likePattern(biotype)
+
+	if prefix {
+		pattern += "%"
+	}
+
+	return []any{sql.Named("genome", genome.Id), sql.Named("pattern", pattern)}
+}
+
+// Lowercases a name and escapes it for use in a LIKE pattern, returning
+// whether it ended with a * to match by prefix
+func likePattern(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	prefix := strings.HasSuffix(name, "*")
+
+	name = strings.TrimSuffix(name, "*")
+
+	name = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(name)
+
+	return name, prefix
+}
+
+// Replaces any loci (chr8:127,700,000-129,000,000) or selectors
+// (group:Immunoglobulin*, biotype:lncRNA) in a list of ids with the ids
+// of the genes they match so they can be searched for in place. A
+// LimitError is returned if in total they expand to more genes than
+// the limits allow.
+func (gdb *GexDB) expandIds(genome *db.Entity, ids []string, limits *RequestLimits) ([]string, error) {
+	ret := make([]string, 0, len(ids))
+
+	remaining := -1
+
+	if limits != nil {
+		remaining = limits.ExpandedGenes
+	}
+
+	for _, id := range ids {
+		query, args, err := selectorQuery(genome, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// an ordinary probe or gene id
+		if query == "" {
+			ret = append(ret, id)
+			continue
+		}
+
+		geneIds, err := gdb.geneIds(query, remaining, args...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if limits != nil {
+			if len(geneIds) > remaining {
+				return nil, &LimitError{Name: "expanded genes", Limit: limits.ExpandedGenes}
+			}
+
+			remaining -= len(geneIds)
+		}
+
+		ret = append(ret, geneIds...)
+	}
+
+	return ret, nil
+}
+
+// Returns the query to find the genes a locus or selector matches, or
+// an empty query if id is neither
+func selectorQuery(genome *db.Entity, id string) (string, []any, error) {
+	if IsLocus(id) {
+		locus, err := ParseLocus(id)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		return LocusGenesSQL, locusArgs(genome, locus), nil
+	}
+
+	selector, value, ok := strings.Cut(id, ":")
+
+	if !ok {
+		return "", nil, nil
+	}
+
+	selector = strings.ToLower(strings.TrimSpace(selector))
+
+	if selector != SelectorGroup && selector != SelectorBiotype {
+		return "", nil, nil
+	}
+
+	if strings.Trim(strings.TrimSpace(value), "*") == "" {
+		return "", nil, fmt.Errorf("%w: %s", ErrInvalidSelector, id)
+	}
+
+	if selector == SelectorGroup {
+		return GroupGenesSQL, groupArgs(genome, value), nil
+	}
+
+	return BiotypeGenesSQL, biotypeArgs(genome, value), nil
+}
+
+func (gdb *GexDB) limitedGeneIds(query string, limit int, namedArgs ...any) ([]string, error) {
+	ret, err := gdb.geneIds(query, limit, namedArgs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret) > limit {
+		return nil, &LimitError{Name: "genes", Limit: limit}
+	}
+
+	return ret, nil
+}
+
+// Returns the gene ids a query finds. At most limit + 1 are returned
+// so callers can tell if there are more than limit without reading
+// them all. If limit is negative all are returned.
+func (gdb *GexDB) geneIds(query string, limit int, namedArgs ...any) ([]string, error) {
+	// LIMIT -1 means no limit in sqlite but not postgres
+	if limit < 0 {
+		query = strings.Replace(query, "LIMIT :limit", "", 1)
+	} else {
+		namedArgs = append(namedArgs, sql.Named("limit", limit+1))
+	}
+
+	rows, err := gdb.db.Query(query, namedArgs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := make([]string, 0, 50)
+
+	for rows.Next() {
+		var geneId string
+
+		err := rows.Scan(&geneId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, geneId)
+	}
+
+	return ret, rows.Err()
+}
+
+func splitGroups(groups string) []string {
+	ret := make([]string, 0, 5)
+
+	for _, group := range strings.Split(groups, "|") {
+		group = strings.TrimSpace(group)
+
+		if group != "" {
+			ret = append(ret, group)
+		}
+	}
+
+	return ret
+}
+
+func joinGroups(groups []string) string {
+	return strings.Join(groups, "|")
+}
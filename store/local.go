@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Reads expression files from a directory, normally the one the
+// catalog database is in
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+func (s *LocalStore) Dir() string {
+	return s.dir
+}
+
+// urls must stay inside the store directory
+func (s *LocalStore) path(url string) (string, error) {
+	p := filepath.FromSlash(url)
+
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidUrl, url)
+	}
+
+	return filepath.Join(s.dir, p), nil
+}
+
+func (s *LocalStore) ReadBlock(ctx context.Context, url string, offset int64, length int) (uint32, []float32, error) {
+	p, err := s.path(url)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	f, err := os.Open(p)
+
+	if err != nil {
+		return 0, nil, notFound(err, url)
+	}
+
+	defer f.Close()
+
+	b := make([]byte, BlockSize(length))
+
+	_, err = f.ReadAt(b, offset)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return DecodeBlock(b, length)
+}
+
+func (s *LocalStore) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	p, err := s.path(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+
+	if err != nil {
+		return nil, notFound(err, url)
+	}
+
+	return f, nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, url string) (*FileInfo, error) {
+	p, err := s.path(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+
+	if err != nil {
+		return nil, notFound(err, url)
+	}
+
+	return &FileInfo{Url: url, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]*FileInfo, error) {
+	ret := make([]*FileInfo, 0, 20)
+
+	// walk from the deepest directory in the prefix rather than
+	// the whole store
+	start := s.dir
+
+	if dir := path.Dir(prefix); dir != "." {
+		p, err := s.path(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		start = p
+	}
+
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, p)
+
+		if err != nil {
+			return err
+		}
+
+		url := filepath.ToSlash(rel)
+
+		if !strings.HasPrefix(url, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		ret = append(ret, &FileInfo{Url: url, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func notFound(err error, url string) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, url)
+	}
+
+	return err
+}
@@ -0,0 +1,355 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+type (
+	S3Config struct {
+		// e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+		// for MinIO
+		Endpoint string
+		Bucket   string
+		// prepended to every url so several catalogs can share a bucket
+		Prefix       string
+		Region       string
+		AccessKey    string
+		SecretKey    string
+		SessionToken string
+	}
+
+	// Reads expression files from an S3 compatible object store using
+	// path style urls, e.g. <endpoint>/<bucket>/<key>, which MinIO and
+	// AWS both support. Blocks are fetched with range requests so only
+	// the bytes needed are transferred. If no access key is given
+	// requests are not signed, which works for public buckets.
+	S3Store struct {
+		config S3Config
+		client *http.Client
+	}
+
+	listBucketResult struct {
+		Contents []struct {
+			Key          string `xml:"Key"`
+			Size         int64  `xml:"Size"`
+			LastModified string `xml:"LastModified"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+)
+
+const (
+	DefaultS3Region = "us-east-1"
+
+	// hash of an empty body, which is all we ever send
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+func NewS3Store(config S3Config) (*S3Store, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket are required")
+	}
+
+	if config.Region == "" {
+		config.Region = DefaultS3Region
+	}
+
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return &S3Store{config: config, client: &http.Client{Timeout: 60 * time.Second}}, nil
+}
+
+func (s *S3Store) key(url string) string {
+	return s.config.Prefix + strings.TrimPrefix(url, "/")
+}
+
+func (s *S3Store) ReadBlock(ctx context.Context, url string, offset int64, length int) (uint32, []float32, error) {
+	end := offset + BlockSize(length) - 1
+
+	resp, err := s.do(ctx,
+		http.MethodGet,
+		s.key(url),
+		nil,
+		map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", offset, end)})
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, nil, s.statusError(resp, url)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, BlockSize(length)))
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return DecodeBlock(b, length)
+}
+
+func (s *S3Store) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(url), nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s.statusError(resp, url)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, url string) (*FileInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.key(url), nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.statusError(resp, url)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &FileInfo{Url: url, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]*FileInfo, error) {
+	ret := make([]*FileInfo, 0, 20)
+
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.key(prefix))
+
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := s.statusError(resp, prefix)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			modTime, _ := time.Parse(time.RFC3339, object.LastModified)
+
+			ret = append(ret, &FileInfo{Url: strings.TrimPrefix(object.Key, s.config.Prefix),
+				Size:    object.Size,
+				ModTime: modTime})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		token = result.NextContinuationToken
+	}
+
+	return ret, nil
+}
+
+func (s *S3Store) do(ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	headers map[string]string) (*http.Response, error) {
+
+	u := s.config.Endpoint + "/" + s.config.Bucket + "/" + escapePath(key)
+
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if s.config.AccessKey != "" {
+		s.sign(req, time.Now().UTC())
+	}
+
+	return s.client.Do(req)
+}
+
+func (s *S3Store) statusError(resp *http.Response, url string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, url)
+	}
+
+	return fmt.Errorf("s3 %s %s: %s", resp.Request.Method, url, resp.Status)
+}
+
+// Signs a request with AWS signature version 4
+// (https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html)
+func (s *S3Store) sign(req *http.Request, t time.Time) {
+	amzDate := t.Format(amzDateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+
+	// host is not in req.Header so add it separately
+	headers := map[string]string{"host": req.URL.Host}
+
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSha256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSha256(key, s.config.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSha256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// escape each part of a key as sigv4 requires, keeping the slashes
+func escapePath(key string) string {
+	parts := strings.Split(key, "/")
+
+	for i, part := range parts {
+		parts[i] = escape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// query params sorted by name with values escaped as sigv4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+
+	for k := range query {
+		names = append(names, k)
+	}
+
+	slices.Sort(names)
+
+	params := make([]string, 0, len(query))
+
+	for _, k := range names {
+		values := slices.Clone(query[k])
+		slices.Sort(values)
+
+		for _, v := range values {
+			params = append(params, escape(k)+"="+escape(v))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// url encode everything except the unreserved characters of RFC 3986
+func escape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
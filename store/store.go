@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+type (
+	FileInfo struct {
+		Url     string    `json:"url"`
+		Size    int64     `json:"size"`
+		ModTime time.Time `json:"modTime"`
+	}
+
+	// Where the binary expression files live. Urls are the paths
+	// stored in the files table of the catalog, relative to the root
+	// of the store.
+	ExpressionStore interface {
+		// Reads the block at offset, returning the probe id and the
+		// length sample values that follow it
+		ReadBlock(ctx context.Context, url string, offset int64, length int) (uint32, []float32, error)
+		// Streams a whole file for when every block is needed
+		Open(ctx context.Context, url string) (io.ReadCloser, error)
+		Stat(ctx context.Context, url string) (*FileInfo, error)
+		// Lists the files whose urls begin with prefix
+		List(ctx context.Context, prefix string) ([]*FileInfo, error)
+	}
+)
+
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrInvalidUrl   = errors.New("invalid file url")
+)
+
+// the number of bytes in a block of a probe id and its values
+func BlockSize(length int) int64 {
+	return 4 + int64(length)*4
+}
+
+// Decodes a block of a little endian uint32 probe id followed by
+// length float32 values
+func DecodeBlock(b []byte, length int) (uint32, []float32, error) {
+	if int64(len(b)) < BlockSize(length) {
+		return 0, nil, fmt.Errorf("block is %d bytes, expected %d: %w", len(b), BlockSize(length), io.ErrUnexpectedEOF)
+	}
+
+	probeId := binary.LittleEndian.Uint32(b)
+
+	values := make([]float32, length)
+
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4+i*4:]))
+	}
+
+	return probeId, values, nil
+}
@@ -0,0 +1,63 @@
+package gex
+
+import (
+	"time"
+
+	"github.com/antonybholmes/go-gex/metrics"
+)
+
+// Where the time of a search went so slow searches can be explained.
+// A nil Timings records nothing.
+type Timings struct {
+	// finding which blocks to read
+	SQL time.Duration
+	// reading and decoding blocks from the store
+	Read time.Duration
+	// bytes read from the store
+	Bytes int64
+	// blocks found in the block cache
+	CachedBlocks int
+}
+
+var (
+	findProbesSeconds = metrics.NewHistogram("gex_find_probes_seconds",
+		"Time to match genes, loci and selectors to probes.",
+		nil)
+
+	expressionSQLSeconds = metrics.NewHistogram("gex_expression_sql_seconds",
+		"Time to find the block of a probe in the catalog.",
+		nil)
+
+	blockReadSeconds = metrics.NewHistogram("gex_block_read_seconds",
+		"Time to read and decode a block of expression values.",
+		nil)
+
+	blockReadBytes = metrics.NewCounter("gex_block_read_bytes_total",
+		"Bytes of expression values read from the store.")
+
+	openFiles = metrics.NewGauge("gex_open_files",
+		"Expression files currently open for reading.")
+
+	resultsCacheRequests = metrics.NewCounter("gex_results_cache_requests_total",
+		"Search results looked up in the results cache by whether they were found.",
+		"result")
+)
+
+func (t *Timings) addSQL(d time.Duration) {
+	if t != nil {
+		t.SQL += d
+	}
+}
+
+func (t *Timings) addRead(d time.Duration, bytes int64) {
+	if t != nil {
+		t.Read += d
+		t.Bytes += bytes
+	}
+}
+
+func (t *Timings) addCachedBlock() {
+	if t != nil {
+		t.CachedBlocks++
+	}
+}
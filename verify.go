@@ -0,0 +1,408 @@
+package gex
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/antonybholmes/go-gex/store"
+)
+
+type (
+	VerifyOptions struct {
+		// NaN and infinite values are problems unless allowed, e.g.
+		// for datasets with missing values
+		AllowNonFinite bool
+		// the most problems listed, with the rest only counted. 0 uses
+		// DefaultMaxVerifyProblems.
+		MaxProblems int
+	}
+
+	// A problem with a file or one block of expression values. The
+	// block fields are empty for problems with a whole file.
+	VerifyProblem struct {
+		Url      string `json:"url"`
+		Offset   int64  `json:"offset,omitempty"`
+		Probe    string `json:"probe,omitempty"`
+		ExprType string `json:"exprType,omitempty"`
+		Problem  string `json:"problem"`
+	}
+
+	// Whether every expression row of a dataset points at a valid
+	// block of the right probe
+	VerifyReport struct {
+		Id      string `json:"id"`
+		Name    string `json:"name"`
+		Ok      bool   `json:"ok"`
+		Samples int    `json:"samples"`
+		Blocks  int64  `json:"blocks"`
+		Files   int    `json:"files"`
+		// probes without a gene, which searches by gene cannot find so
+		// are listed to be checked
+		UnmappedProbes []string         `json:"unmappedProbes"`
+		Problems       []*VerifyProblem `json:"problems"`
+		// problems found after MaxProblems were listed
+		MoreProblems int `json:"moreProblems"`
+	}
+
+	verifyBlock struct {
+		probeId  int64
+		probe    sql.NullString
+		geneId   sql.NullInt64
+		gene     sql.NullInt64
+		exprType sql.NullString
+		url      sql.NullString
+		offset   int64
+		length   int
+	}
+
+	// Reads the blocks of a file in order so each file is only read
+	// once, whatever the store
+	blockReader struct {
+		r   *bufio.Reader
+		f   io.Closer
+		pos int64
+	}
+)
+
+const (
+	DefaultMaxVerifyProblems = 1000
+
+	VerifyDatasetSQL = `SELECT
+		d.id,
+		d.name,
+		COUNT(s.id)
+		FROM datasets d
+		LEFT JOIN samples s ON s.dataset_id = d.id
+		WHERE d.public_id = :id
+		GROUP BY d.id, d.name`
+
+	VerifyDatasetsSQL = `SELECT
+		d.public_id
+		FROM datasets d
+		ORDER BY d.name`
+
+	// rows are left joined so those pointing at missing probes, genes
+	// or files are found too
+	VerifyBlocksSQL = `SELECT
+		e.probe_id,
+		p.public_id,
+		p.gene_id,
+		g.id,
+		et.name,
+		f.url,
+		e.offset,
+		e.length
+		FROM expression e
+		LEFT JOIN probes p ON p.id = e.probe_id
+		LEFT JOIN genes g ON g.id = p.gene_id
+		LEFT JOIN expression_types et ON et.id = e.expression_type_id
+		LEFT JOIN files f ON f.id = e.file_id
+		WHERE e.dataset_id = :dataset
+		ORDER BY f.url, e.offset`
+)
+
+// Checks every block of expression values of a dataset. Unlike Check,
+// which only reads file headers, every block is read, so this is meant
+// for checking a new build before it is deployed.
+func (gdb *GexDB) Verify(datasetId string) (*VerifyReport, error) {
+	return gdb.VerifyWithOptions(datasetId, nil)
+}
+
+// Verifies a dataset, checking that each expression row's file exists
+// and has a header this version can read, that the block at its offset starts with its probe id and has one
+// finite value per sample, and that its probe maps to a gene
+func (gdb *GexDB) VerifyWithOptions(datasetId string, options *VerifyOptions) (*VerifyReport, error) {
+	if options == nil {
+		options = &VerifyOptions{}
+	}
+
+	// a copy so the caller's options are not changed
+	opts := *options
+	options = &opts
+
+	if options.MaxProblems <= 0 {
+		options.MaxProblems = DefaultMaxVerifyProblems
+	}
+
+	ctx := context.Background()
+
+	report := VerifyReport{Id: datasetId, UnmappedProbes: []string{}, Problems: []*VerifyProblem{}}
+
+	var id int
+
+	err := gdb.db.QueryRow(VerifyDatasetSQL, sql.Named("id", datasetId)).Scan(&id, &report.Name, &report.Samples)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrDatasetNotFound, datasetId)
+		}
+
+		return nil, err
+	}
+
+	rows, err := gdb.db.Query(VerifyBlocksSQL, sql.Named("dataset", id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	// probes have a block for each expression type but are only
+	// listed once
+	unmapped := make(map[string]struct{})
+
+	var reader *blockReader
+	var url string
+	// the file cannot be read so its other blocks are skipped
+	skipFile := false
+
+	defer func() {
+		if reader != nil {
+			reader.Close()
+		}
+	}()
+
+	for rows.Next() {
+		var block verifyBlock
+
+		err := rows.Scan(&block.probeId,
+			&block.probe,
+			&block.geneId,
+			&block.gene,
+			&block.exprType,
+			&block.url,
+			&block.offset,
+			&block.length)
+
+		if err != nil {
+			return nil, err
+		}
+
+		report.Blocks++
+
+		problem := &VerifyProblem{Url: block.url.String,
+			Offset:   block.offset,
+			Probe:    block.probe.String,
+			ExprType: block.exprType.String}
+
+		if !block.probe.Valid {
+			problem.Probe = strconv.FormatInt(block.probeId, 10)
+			report.add(problem, "probe is not in the catalog", options)
+		} else if !block.geneId.Valid {
+			unmapped[block.probe.String] = struct{}{}
+		} else if !block.gene.Valid {
+			report.add(problem, fmt.Sprintf("gene %d of the probe is not in the catalog", block.geneId.Int64), options)
+		}
+
+		if !block.exprType.Valid {
+			report.add(problem, "expression type is not in the catalog", options)
+		}
+
+		if block.length != report.Samples {
+			report.add(problem, fmt.Sprintf("block has %d values but the dataset has %d samples", block.length, report.Samples), options)
+		}
+
+		if !block.url.Valid {
+			report.add(problem, "file is not in the catalog", options)
+			continue
+		}
+
+		if block.url.String != url {
+			if reader != nil {
+				reader.Close()
+				reader = nil
+			}
+
+			url = block.url.String
+			report.Files++
+
+			reader, err = gdb.openBlockReader(ctx, url)
+
+			skipFile = err != nil
+
+			if skipFile {
+				if errors.Is(err, store.ErrFileNotFound) {
+					report.add(&VerifyProblem{Url: url}, "file is missing", options)
+				} else {
+					report.add(&VerifyProblem{Url: url}, fmt.Sprintf("file cannot be read: %s", err), options)
+				}
+			} else {
+				// blocks of a file this version cannot read are not
+				// checked since they may not mean what they seem
+				err = reader.ReadHeader(url)
+
+				if err != nil {
+					report.add(&VerifyProblem{Url: url}, fmt.Sprintf("header is not valid: %s", err), options)
+					skipFile = true
+				}
+			}
+		}
+
+		if skipFile {
+			continue
+		}
+
+		probeId, values, err := reader.ReadBlock(block.offset, block.length)
+
+		if err != nil {
+			report.add(problem, fmt.Sprintf("block cannot be read: %s", err), options)
+
+			// once a read fails the position in the file is unknown
+			if !errors.Is(err, errBlockOverlaps) {
+				skipFile = true
+			}
+
+			continue
+		}
+
+		if int64(probeId) != block.probeId {
+			report.add(problem, fmt.Sprintf("block is for probe %d but the catalog says %d", probeId, block.probeId), options)
+		}
+
+		if !options.AllowNonFinite {
+			nonFinite := 0
+
+			for _, v := range values {
+				if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+					nonFinite++
+				}
+			}
+
+			if nonFinite > 0 {
+				report.add(problem, fmt.Sprintf("block has %d NaN or infinite values", nonFinite), options)
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for probe := range unmapped {
+		report.UnmappedProbes = append(report.UnmappedProbes, probe)
+	}
+
+	slices.Sort(report.UnmappedProbes)
+
+	report.Ok = len(report.Problems) == 0
+
+	return &report, nil
+}
+
+// Verifies every dataset in the catalog
+func (gdb *GexDB) VerifyAll(options *VerifyOptions) ([]*VerifyReport, error) {
+	rows, err := gdb.db.Query(VerifyDatasetsSQL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+
+		err := rows.Scan(&id)
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reports := make([]*VerifyReport, 0, len(ids))
+
+	for _, id := range ids {
+		report, err := gdb.VerifyWithOptions(id, options)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// Lists a problem, or only counts it once MaxProblems are listed
+func (report *VerifyReport) add(problem *VerifyProblem, message string, options *VerifyOptions) {
+	if len(report.Problems) >= options.MaxProblems {
+		report.MoreProblems++
+		return
+	}
+
+	p := *problem
+	p.Problem = message
+
+	report.Problems = append(report.Problems, &p)
+}
+
+var errBlockOverlaps = errors.New("block overlaps the block before it")
+
+func (gdb *GexDB) openBlockReader(ctx context.Context, url string) (*blockReader, error) {
+	f, err := gdb.store.Open(ctx, url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &blockReader{r: bufio.NewReader(f), f: f}, nil
+}
+
+// Reads and checks the header at the start of the file
+func (b *blockReader) ReadHeader(url string) error {
+	_, err := parseBinHeader(b.r, url)
+
+	b.pos = BinHeaderSize
+
+	return err
+}
+
+// Reads the block at offset, which must not be before the end of the
+// last block read
+func (b *blockReader) ReadBlock(offset int64, length int) (uint32, []float32, error) {
+	if offset < b.pos {
+		return 0, nil, errBlockOverlaps
+	}
+
+	n, err := b.r.Discard(int(offset - b.pos))
+
+	b.pos += int64(n)
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("offset is past the end of the file: %w", err)
+	}
+
+	buf := make([]byte, store.BlockSize(length))
+
+	n, err = io.ReadFull(b.r, buf)
+
+	b.pos += int64(n)
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("block runs past the end of the file: %w", err)
+	}
+
+	return store.DecodeBlock(buf, length)
+}
+
+func (b *blockReader) Close() error {
+	return b.f.Close()
+}
@@ -0,0 +1,147 @@
+package gex_test
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	gex "github.com/antonybholmes/go-gex"
+	"github.com/antonybholmes/go-gex/internal/gextest"
+	"github.com/antonybholmes/go-gex/store"
+)
+
+// Each way a build can be broken is reported as the right problem
+func TestVerifyProblems(t *testing.T) {
+	// the second block of the open dataset, which is MYC
+	offset := gex.BinHeaderSize + store.BlockSize(gextest.FileSamples["open/tpm.bin"])
+
+	// writes a uint32 over the open dataset's file
+	write := func(at int64, value uint32) func(t *testing.T, file string) {
+		return func(t *testing.T, file string) { writeUint32(t, file, at, value) }
+	}
+
+	for _, test := range []struct {
+		name       string
+		statements []string
+		change     func(t *testing.T, file string)
+		want       string
+	}{{name: "wrong probe", change: write(offset, 3), want: "block is for probe 3 but the catalog says 2"},
+		{name: "length", statements: []string{`UPDATE expression SET length = 3 WHERE id = 2`},
+			want: "block has 3 values but the dataset has 4 samples"},
+		{name: "nan", change: write(offset+4, math.Float32bits(float32(math.NaN()))), want: "block has 1 NaN or infinite values"},
+		{name: "inf", change: write(offset+8, math.Float32bits(float32(math.Inf(-1)))), want: "block has 1 NaN or infinite values"},
+		{name: "missing file", change: func(t *testing.T, file string) { check(t, "Remove", os.Remove(file)) }, want: "file is missing"},
+		{name: "magic", change: write(0, gex.BinMagic+1), want: "is not a gex binary file"},
+		{name: "version", change: write(4, gex.BinVersion+1), want: "only up to"}} {
+		t.Run(test.name, func(t *testing.T) {
+			report := verify(t, test.statements, test.change, nil)
+
+			if report.Ok || len(report.Problems) != 1 || !strings.Contains(report.Problems[0].Problem, test.want) {
+				t.Fatalf("got %s, want one problem %q", problems(report), test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAllowNonFinite(t *testing.T) {
+	offset := gex.BinHeaderSize + store.BlockSize(gextest.FileSamples["open/tpm.bin"])
+
+	report := verify(t, nil, func(t *testing.T, file string) {
+		writeUint32(t, file, offset+4, math.Float32bits(float32(math.NaN())))
+		writeUint32(t, file, offset+8, math.Float32bits(float32(math.Inf(1))))
+	}, &gex.VerifyOptions{AllowNonFinite: true})
+
+	if !report.Ok {
+		t.Errorf("got %s, want no problems", problems(report))
+	}
+}
+
+// Probes without a gene are listed but are not a problem
+func TestVerifyUnmappedProbes(t *testing.T) {
+	report := verify(t, []string{`UPDATE probes SET gene_id = NULL WHERE id = 3`}, nil, nil)
+
+	if !report.Ok {
+		t.Errorf("got %s, want no problems", problems(report))
+	}
+
+	if want := []string{"probe-orphan", "probe-tp53"}; !slices.Equal(report.UnmappedProbes, want) {
+		t.Errorf("got unmapped probes %v, want %v", report.UnmappedProbes, want)
+	}
+}
+
+// Problems past MaxProblems are only counted
+func TestVerifyMaxProblems(t *testing.T) {
+	report := verify(t, []string{`UPDATE expression SET length = 3 WHERE dataset_id = 1`}, nil, &gex.VerifyOptions{MaxProblems: 1})
+
+	want(t, "problems", len(report.Problems), 1)
+	want(t, "more problems", report.MoreProblems, int(report.Blocks)-1)
+	want(t, "blocks", report.Blocks, int64(len(gextest.FileProbes)))
+}
+
+// Verifies the open dataset of a catalog after running statements on it
+// and changing its expression file, either of which can be nil
+func verify(t *testing.T, statements []string, change func(t *testing.T, file string), options *gex.VerifyOptions) *gex.VerifyReport {
+	t.Helper()
+
+	path := gextest.NewBaseline(t)
+
+	gdb, err := gex.OpenGexDB(path, &gex.Options{Migrate: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gdb.Close()
+
+	exec(t, path, statements...)
+
+	if change != nil {
+		change(t, filepath.Join(filepath.Dir(path), "open", "tpm.bin"))
+	}
+
+	gdb, err = gex.OpenGexDB(path, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer gdb.Close()
+
+	report, err := gdb.VerifyWithOptions(gextest.OpenDataset, options)
+	check(t, "Verify", err)
+
+	return report
+}
+
+func writeUint32(t *testing.T, path string, offset int64, value uint32) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = file.WriteAt(binary.LittleEndian.AppendUint32(nil, value), offset)
+
+	file.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The messages of the problems of a report
+func problems(report *gex.VerifyReport) []string {
+	ret := make([]string, 0, len(report.Problems))
+
+	for _, problem := range report.Problems {
+		ret = append(ret, problem.Problem)
+	}
+
+	return ret
+}